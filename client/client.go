@@ -0,0 +1,379 @@
+// Package client submits requests to the gateway and receives their results
+package client
+
+import (
+	"context"
+	"distribuidos/tp1/protocol"
+	"distribuidos/tp1/utils"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("log")
+
+const KB int = 1 << 10
+
+const DEFAULT_CONNECTION_ADDRESS = "127.0.0.1:9001"
+const DEFAULT_DATA_ADDRESS = "127.0.0.1:9002"
+const DEFAULT_BATCH_SIZE = 8 * KB
+
+type Options struct {
+	// Addresses of the gateway endpoints
+	ConnectionAddress string
+	DataAddress       string
+	// Size of the batches the input files are split into
+	BatchSize int
+	// Times to retry the request while the gateway is busy
+	MaxRetries int
+	// Formats of the input files, defaults to CSV
+	GamesFormat   string
+	ReviewsFormat string
+	// Sizes of the input files, 0 if unknown
+	GamesSize   uint64
+	ReviewsSize uint64
+	// Called with each progress message received from the gateway
+	OnProgress func(protocol.Progress)
+}
+
+func (o Options) withDefaults() Options {
+	if o.ConnectionAddress == "" {
+		o.ConnectionAddress = DEFAULT_CONNECTION_ADDRESS
+	}
+	if o.DataAddress == "" {
+		o.DataAddress = DEFAULT_DATA_ADDRESS
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DEFAULT_BATCH_SIZE
+	}
+	if o.GamesFormat == "" {
+		o.GamesFormat = protocol.FormatCSV
+	}
+	if o.ReviewsFormat == "" {
+		o.ReviewsFormat = protocol.FormatCSV
+	}
+	return o
+}
+
+// Returned when the gateway can't admit more clients
+type BusyError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("gateway is busy, retry after %v", e.RetryAfter)
+}
+
+// Returned by Report when the gateway predates validation reports
+var ErrNoReport = errors.New("gateway does not send validation reports")
+
+// Returned when the gateway rejects the request
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("request rejected by gateway: %v", e.Reason)
+}
+
+// Submits a request to the gateway, and starts uploading the given files in
+// the background. Results are received through the returned job.
+//
+// The readers must not be used until the job finishes
+func Submit(ctx context.Context, games io.Reader, reviews io.Reader, opts Options) (*Job, error) {
+	return submit(ctx, games, reviews, opts)
+}
+
+// The given closers are closed once the files have been sent
+func submit(ctx context.Context, games io.Reader, reviews io.Reader, opts Options, closers ...io.Closer) (*Job, error) {
+	opts = opts.withDefaults()
+
+	conn, accept, err := requestAdmission(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	job := newJob(ctx, conn, accept, opts)
+	go job.send(ctx, games, reviews, closers)
+	go job.receive()
+
+	return job, nil
+}
+
+// Like Submit, but reads the input from the given paths. The format and
+// size of each file are inferred, unless set in the options
+func SubmitFiles(ctx context.Context, gamesPath string, reviewsPath string, opts Options) (*Job, error) {
+	var err error
+
+	if opts.GamesFormat == "" {
+		opts.GamesFormat, err = FormatFromPath(gamesPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.ReviewsFormat == "" {
+		opts.ReviewsFormat, err = FormatFromPath(reviewsPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	games, err := os.Open(gamesPath)
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := os.Open(reviewsPath)
+	if err != nil {
+		games.Close()
+		return nil, err
+	}
+
+	if opts.GamesSize == 0 {
+		opts.GamesSize, _ = fileSize(games)
+	}
+	if opts.ReviewsSize == 0 {
+		opts.ReviewsSize, _ = fileSize(reviews)
+	}
+
+	job, err := submit(ctx, games, reviews, opts, games, reviews)
+	if err != nil {
+		games.Close()
+		reviews.Close()
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Asks the gateway for the status of a previous request
+func Status(ctx context.Context, address string, id uint64) (status protocol.JobStatus, err error) {
+	conn, err := dial(address)
+	if err != nil {
+		return
+	}
+	closer := utils.SpawnCloser(ctx, conn)
+	defer func() {
+		closeErr := closer.Close()
+		err = errors.Join(err, closeErr)
+	}()
+
+	err = conn.Send(protocol.StatusRequest{ClientID: id})
+	if err != nil {
+		return
+	}
+	err = conn.Recv(&status)
+	return
+}
+
+// Receives the results of a previous request, which must have finished.
+// Requests are only kept by the gateway for a limited time
+func Fetch(ctx context.Context, address string, id uint64) (*Job, error) {
+	conn, err := dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.Send(protocol.FetchRequest{ClientID: id})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	job := newJob(ctx, conn, protocol.AcceptRequest{ClientID: id, Version: protocol.ProtocolVersion}, Options{})
+	job.finishSend(nil)
+	go job.receive()
+
+	return job, nil
+}
+
+// Infers the format of an input file from its extension
+func FormatFromPath(path string) (string, error) {
+	switch {
+	case strings.HasSuffix(path, ".csv"):
+		return protocol.FormatCSV, nil
+	case strings.HasSuffix(path, ".csv.gz"):
+		return protocol.FormatCSVGzip, nil
+	case strings.HasSuffix(path, ".jsonl"), strings.HasSuffix(path, ".ndjson"):
+		return protocol.FormatJSONL, nil
+	case strings.HasSuffix(path, ".jsonl.gz"), strings.HasSuffix(path, ".ndjson.gz"):
+		return protocol.FormatJSONLGzip, nil
+	}
+	return "", fmt.Errorf("unknown format of file %v", path)
+}
+
+func fileSize(file *os.File) (uint64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Size()), nil
+}
+
+// Connects to the connection endpoint and sends the request, retrying
+// while the gateway is busy. On success, the connection is left open
+func requestAdmission(ctx context.Context, opts Options) (*protocol.Conn, protocol.AcceptRequest, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := dial(opts.ConnectionAddress)
+		if err != nil {
+			return nil, protocol.AcceptRequest{}, err
+		}
+
+		accept, err := sendRequest(conn, opts)
+		if err == nil {
+			return conn, accept, nil
+		}
+		_ = conn.Close()
+
+		var busy *BusyError
+		if !errors.As(err, &busy) || attempt >= opts.MaxRetries {
+			return nil, protocol.AcceptRequest{}, err
+		}
+
+		log.Warningf("Gateway is busy, retrying after %v", busy.RetryAfter)
+		select {
+		case <-time.After(busy.RetryAfter):
+		case <-ctx.Done():
+			return nil, protocol.AcceptRequest{}, ctx.Err()
+		}
+	}
+}
+
+func dial(address string) (*protocol.Conn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.NewConn(conn), nil
+}
+
+// Sends request hello and waits for the client id
+func sendRequest(conn *protocol.Conn, opts Options) (protocol.AcceptRequest, error) {
+	request := protocol.RequestHello{
+		Version:       protocol.ProtocolVersion,
+		GameSize:      opts.GamesSize,
+		ReviewSize:    opts.ReviewsSize,
+		GamesFormat:   opts.GamesFormat,
+		ReviewsFormat: opts.ReviewsFormat,
+	}
+	err := conn.Send(&request)
+	if err != nil {
+		return protocol.AcceptRequest{}, fmt.Errorf("failed to send hello: %w", err)
+	}
+
+	var anyMsg any
+	err = conn.Recv(&anyMsg)
+	if err != nil {
+		return protocol.AcceptRequest{}, fmt.Errorf("could not receive id from gateway: %w", err)
+	}
+
+	switch msg := anyMsg.(type) {
+	case protocol.AcceptRequest:
+		return msg, nil
+	case protocol.Reject:
+		return protocol.AcceptRequest{}, &RejectedError{Reason: msg.Reason}
+	case protocol.Busy:
+		return protocol.AcceptRequest{}, &BusyError{RetryAfter: time.Duration(msg.RetryAfter) * time.Second}
+	default:
+		return protocol.AcceptRequest{}, fmt.Errorf("unexpected message from gateway: %T", msg)
+	}
+}
+
+// Sends games and reviews, each through its own data connection. If the
+// gateway doesn't support it, both are sent through a single connection
+func (j *Job) send(ctx context.Context, games io.Reader, reviews io.Reader, closers []io.Closer) {
+	var err error
+	defer func() {
+		for _, c := range closers {
+			_ = c.Close()
+		}
+		j.finishSend(err)
+	}()
+
+	if j.version < protocol.StreamsVersion {
+		err = j.sendStream(ctx, protocol.StreamAll, games, reviews)
+		return
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+
+	var gamesErr, reviewsErr error
+	go func() {
+		defer wg.Done()
+		gamesErr = j.sendStream(ctx, protocol.StreamGames, games)
+	}()
+	go func() {
+		defer wg.Done()
+		reviewsErr = j.sendStream(ctx, protocol.StreamReviews, reviews)
+	}()
+
+	wg.Wait()
+	err = errors.Join(gamesErr, reviewsErr)
+}
+
+// Connects to the data endpoint and sends each of the given files through
+// it, in order. When done closes connection
+func (j *Job) sendStream(ctx context.Context, stream protocol.DataStream, files ...io.Reader) (err error) {
+	dataConn, err := dial(j.opts.DataAddress)
+	if err != nil {
+		return
+	}
+
+	closer := utils.SpawnCloser(ctx, dataConn)
+	defer func() {
+		closeErr := closer.Close()
+		err = errors.Join(err, closeErr)
+	}()
+
+	hello := protocol.DataHello{
+		ClientID: j.id,
+		Stream:   stream,
+	}
+	err = dataConn.Send(&hello)
+	if err != nil {
+		return fmt.Errorf("failed to send data hello: %w", err)
+	}
+	var accept protocol.DataAccept
+	err = dataConn.Recv(&accept)
+	if err != nil {
+		return fmt.Errorf("failed to receive data accept: %w", err)
+	}
+
+	for _, file := range files {
+		err = sendFile(dataConn, file, j.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sends the file in batches of the given size
+func sendFile(dataConn *protocol.Conn, file io.Reader, batchSize int) error {
+	buf := make([]byte, batchSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			sendErr := dataConn.Send(&protocol.Batch{Data: buf[:n]})
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return dataConn.Send(&protocol.Finish{})
+}
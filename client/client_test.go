@@ -0,0 +1,425 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"distribuidos/tp1/client"
+	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/protocol"
+	"errors"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const GAMES = "AppID,Name\n1,Game\n"
+const REVIEWS = "app_id,review_text,review_score\n1,Good,1\n"
+
+// Fake gateway, that answers a single request with fixed results
+type fakeGateway struct {
+	t       *testing.T
+	conn    net.Listener
+	data    net.Listener
+	version uint16
+	// busy responses sent before accepting the request
+	busy int
+	// whether the client aborts the job instead of finishing it
+	aborts bool
+
+	mu       *sync.Mutex
+	received map[protocol.DataStream][]string
+}
+
+func newFakeGateway(t *testing.T, version uint16) *fakeGateway {
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		data.Close()
+	})
+
+	return &fakeGateway{
+		t:        t,
+		conn:     conn,
+		data:     data,
+		version:  version,
+		mu:       &sync.Mutex{},
+		received: make(map[protocol.DataStream][]string),
+	}
+}
+
+func (g *fakeGateway) options() client.Options {
+	return client.Options{
+		ConnectionAddress: g.conn.Addr().String(),
+		DataAddress:       g.data.Addr().String(),
+		BatchSize:         8,
+		MaxRetries:        g.busy,
+	}
+}
+
+func (g *fakeGateway) serve(results []any) {
+	for range g.busy {
+		conn := g.accept(g.conn)
+		var hello protocol.RequestHello
+		g.check(conn.Recv(&hello))
+		g.check(conn.Send(protocol.Busy{RetryAfter: 0}))
+		conn.Close()
+	}
+
+	conn := g.accept(g.conn)
+	defer conn.Close()
+	var hello protocol.RequestHello
+	g.check(conn.Recv(&hello))
+	g.check(conn.Send(protocol.AcceptRequest{ClientID: 1, Version: g.version}))
+
+	connections := 2
+	if g.version < protocol.StreamsVersion {
+		connections = 1
+	}
+	wg := &sync.WaitGroup{}
+	for range connections {
+		dataConn := g.accept(g.data)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer dataConn.Close()
+			g.receiveData(dataConn)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if _, ok := result.(protocol.Progress); ok && g.version < protocol.ProgressVersion {
+			continue
+		}
+		if _, ok := result.(protocol.ValidationReport); ok && g.version < protocol.ValidationVersion {
+			continue
+		}
+		g.check(conn.Send(result))
+	}
+	if g.aborts {
+		return
+	}
+	var finish protocol.Finish
+	g.check(conn.Recv(&finish))
+}
+
+func (g *fakeGateway) receiveData(conn *protocol.Conn) {
+	var hello protocol.DataHello
+	g.check(conn.Recv(&hello))
+	g.check(conn.Send(protocol.DataAccept{}))
+
+	files := 1
+	if hello.Stream == protocol.StreamAll {
+		files = 2
+	}
+	for range files {
+		var file bytes.Buffer
+		for {
+			var msg any
+			g.check(conn.Recv(&msg))
+			batch, ok := msg.(protocol.Batch)
+			if !ok {
+				break
+			}
+			file.Write(batch.Data)
+		}
+
+		g.mu.Lock()
+		g.received[hello.Stream] = append(g.received[hello.Stream], file.String())
+		g.mu.Unlock()
+	}
+}
+
+func (g *fakeGateway) accept(l net.Listener) *protocol.Conn {
+	conn, err := l.Accept()
+	g.check(err)
+	return protocol.NewConn(conn)
+}
+
+func (g *fakeGateway) check(err error) {
+	if err != nil {
+		g.t.Errorf("fake gateway failed: %v", err)
+	}
+}
+
+var q4Games = []middleware.GameStat{
+	{AppID: 1, Name: "a", Stat: 10},
+	{AppID: 2, Name: "b", Stat: 20},
+	{AppID: 3, Name: "c", Stat: 30},
+}
+
+var results = []any{
+	protocol.Progress{BytesReceived: 10, FinishedStages: []string{}},
+	protocol.Q4Result{Games: q4Games[:2]},
+	protocol.Q1Result{Windows: 1, Linux: 2, Mac: 3},
+	protocol.Q2Result{TopN: []middleware.GameStat{{AppID: 4, Name: "d", Stat: 1}}},
+	protocol.Q4Result{Games: q4Games[2:]},
+	protocol.Q3Result{TopN: []middleware.GameStat{}},
+	protocol.ValidationReport{
+		Rejections: []protocol.RejectionCount{{File: "games", Reason: "invalid app id", Count: 1}},
+		Samples:    []protocol.RejectedRow{},
+	},
+	protocol.Q4Finish{},
+	protocol.Q5Result{Percentile90: []middleware.GameStat{{AppID: 5, Name: "e", Stat: 2}}},
+}
+
+func collect[T any](t *testing.T, rows func(func(T, error) bool)) []T {
+	collected := make([]T, 0)
+	for row, err := range rows {
+		if err != nil {
+			t.Fatalf("Failed to iterate results: %v", err)
+		}
+		collected = append(collected, row)
+	}
+	return collected
+}
+
+func submit(t *testing.T, g *fakeGateway, opts client.Options) *client.Job {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.serve(results)
+	}()
+	t.Cleanup(func() { <-done })
+
+	job, err := client.Submit(context.Background(), strings.NewReader(GAMES), strings.NewReader(REVIEWS), opts)
+	if err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+	t.Cleanup(func() { job.Close() })
+
+	return job
+}
+
+func checkResults(t *testing.T, job *client.Job) {
+	q1 := collect(t, job.Q1())
+	if !reflect.DeepEqual(q1, []protocol.Q1Result{{Windows: 1, Linux: 2, Mac: 3}}) {
+		t.Fatalf("Unexpected Q1 results: %v", q1)
+	}
+	q2 := collect(t, job.Q2())
+	if !reflect.DeepEqual(q2, []middleware.GameStat{{AppID: 4, Name: "d", Stat: 1}}) {
+		t.Fatalf("Unexpected Q2 results: %v", q2)
+	}
+	q3 := collect(t, job.Q3())
+	if len(q3) != 0 {
+		t.Fatalf("Unexpected Q3 results: %v", q3)
+	}
+	q4 := collect(t, job.Q4())
+	if !reflect.DeepEqual(q4, q4Games) {
+		t.Fatalf("Unexpected Q4 results: %v", q4)
+	}
+	q5 := collect(t, job.Q5())
+	if !reflect.DeepEqual(q5, []middleware.GameStat{{AppID: 5, Name: "e", Stat: 2}}) {
+		t.Fatalf("Unexpected Q5 results: %v", q5)
+	}
+
+	report, err := job.Report()
+	if job.Version() < protocol.ValidationVersion {
+		if !errors.Is(err, client.ErrNoReport) {
+			t.Fatalf("Expected no report from a legacy gateway, got %v", err)
+		}
+	} else if err != nil {
+		t.Fatalf("Failed to receive report: %v", err)
+	} else if len(report.Rejections) != 1 || report.Rejections[0].Count != 1 {
+		t.Fatalf("Unexpected report: %v", report)
+	}
+
+	err = job.Wait()
+	if err != nil {
+		t.Fatalf("Job failed: %v", err)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	g := newFakeGateway(t, protocol.ProtocolVersion)
+
+	progress := make(chan protocol.Progress, 1)
+	opts := g.options()
+	opts.OnProgress = func(p protocol.Progress) { progress <- p }
+
+	job := submit(t, g, opts)
+	if job.ID() != 1 {
+		t.Fatalf("Expected id 1, got %v", job.ID())
+	}
+	checkResults(t, job)
+
+	if p := <-progress; p.BytesReceived != 10 {
+		t.Fatalf("Unexpected progress: %v", p)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	expected := map[protocol.DataStream][]string{
+		protocol.StreamGames:   {GAMES},
+		protocol.StreamReviews: {REVIEWS},
+	}
+	if !reflect.DeepEqual(g.received, expected) {
+		t.Fatalf("Expected data %q, got %q", expected, g.received)
+	}
+}
+
+func TestSubmitDuplicateReport(t *testing.T) {
+	g := newFakeGateway(t, protocol.ProtocolVersion)
+	g.aborts = true
+
+	// the report is sent again before the last result
+	report := slices.IndexFunc(results, func(r any) bool {
+		_, ok := r.(protocol.ValidationReport)
+		return ok
+	})
+	duplicated := slices.Insert(slices.Clone(results), len(results)-1, results[report])
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.serve(duplicated)
+	}()
+	defer func() { <-done }()
+
+	job, err := client.Submit(context.Background(), strings.NewReader(GAMES), strings.NewReader(REVIEWS), g.options())
+	if err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+	defer job.Close()
+
+	err = job.Wait()
+	if !errors.Is(err, protocol.ErrUnexpectedMessage) {
+		t.Fatalf("Expected the duplicated report to fail the job, got %v", err)
+	}
+	// the first report is kept
+	if _, err := job.Report(); err != nil {
+		t.Fatalf("Failed to receive report: %v", err)
+	}
+}
+
+func TestSubmitLegacyGateway(t *testing.T) {
+	g := newFakeGateway(t, protocol.FormatsVersion)
+
+	job := submit(t, g, g.options())
+	checkResults(t, job)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	expected := map[protocol.DataStream][]string{
+		protocol.StreamAll: {GAMES, REVIEWS},
+	}
+	if !reflect.DeepEqual(g.received, expected) {
+		t.Fatalf("Expected data %q, got %q", expected, g.received)
+	}
+}
+
+func TestSubmitBusy(t *testing.T) {
+	g := newFakeGateway(t, protocol.ProtocolVersion)
+	g.busy = 2
+
+	job := submit(t, g, g.options())
+	checkResults(t, job)
+}
+
+func TestSubmitRejected(t *testing.T) {
+	g := newFakeGateway(t, protocol.ProtocolVersion)
+
+	go func() {
+		conn := g.accept(g.conn)
+		defer conn.Close()
+		var hello protocol.RequestHello
+		g.check(conn.Recv(&hello))
+		g.check(conn.Send(protocol.Reject{Reason: "unsupported"}))
+	}()
+
+	_, err := client.Submit(context.Background(), strings.NewReader(GAMES), strings.NewReader(REVIEWS), g.options())
+	var rejected *client.RejectedError
+	if !errors.As(err, &rejected) || rejected.Reason != "unsupported" {
+		t.Fatalf("Expected rejection, got %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	g := newFakeGateway(t, protocol.ProtocolVersion)
+	expected := protocol.JobStatus{
+		ClientID:        3,
+		State:           protocol.JobRunning,
+		FinishedQueries: []uint8{1},
+		Progress:        protocol.Progress{BytesReceived: 10, FinishedStages: []string{}},
+	}
+
+	go func() {
+		conn := g.accept(g.conn)
+		defer conn.Close()
+		var request protocol.StatusRequest
+		g.check(conn.Recv(&request))
+		g.check(conn.Send(expected))
+	}()
+
+	status, err := client.Status(context.Background(), g.conn.Addr().String(), 3)
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Fatalf("Expected status %v, got %v", expected, status)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	g := newFakeGateway(t, protocol.ProtocolVersion)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn := g.accept(g.conn)
+		defer conn.Close()
+		var request protocol.FetchRequest
+		g.check(conn.Recv(&request))
+		for _, result := range results {
+			g.check(conn.Send(result))
+		}
+		var finish protocol.Finish
+		g.check(conn.Recv(&finish))
+	}()
+
+	job, err := client.Fetch(context.Background(), g.conn.Addr().String(), 1)
+	if err != nil {
+		t.Fatalf("Failed to fetch: %v", err)
+	}
+	defer job.Close()
+	checkResults(t, job)
+	<-done
+}
+
+func TestFetchRejected(t *testing.T) {
+	g := newFakeGateway(t, protocol.ProtocolVersion)
+
+	go func() {
+		conn := g.accept(g.conn)
+		defer conn.Close()
+		var request protocol.FetchRequest
+		g.check(conn.Recv(&request))
+		g.check(conn.Send(protocol.Reject{Reason: "unknown request"}))
+	}()
+
+	job, err := client.Fetch(context.Background(), g.conn.Addr().String(), 1)
+	if err != nil {
+		t.Fatalf("Failed to fetch: %v", err)
+	}
+	defer job.Close()
+
+	var rejected *client.RejectedError
+	err = job.Wait()
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected rejection, got %v", err)
+	}
+	for _, err := range job.Q1() {
+		if !errors.As(err, &rejected) {
+			t.Fatalf("Expected rejection, got %v", err)
+		}
+	}
+}
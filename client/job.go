@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/protocol"
+	"distribuidos/tp1/utils"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+)
+
+const MAX_RESULTS = 5
+
+// A request submitted to the gateway. Results are received in the
+// background, and can be consumed through the iterator of each query
+type Job struct {
+	id      uint64
+	version uint16
+	opts    Options
+
+	conn      *protocol.Conn
+	closer    utils.Closer
+	closeOnce *sync.Once
+	closeErr  error
+
+	q1 *stream[protocol.Q1Result]
+	q2 *stream[middleware.GameStat]
+	q3 *stream[middleware.GameStat]
+	q4 *stream[middleware.GameStat]
+	q5 *stream[middleware.GameStat]
+
+	// closed when the validation report is received, or the job fails
+	reported  chan struct{}
+	report    protocol.ValidationReport
+	reportErr error
+
+	sent     chan struct{}
+	sendErr  error
+	received chan struct{}
+	recvErr  error
+}
+
+func newJob(ctx context.Context, conn *protocol.Conn, accept protocol.AcceptRequest, opts Options) *Job {
+	return &Job{
+		id:        accept.ClientID,
+		version:   accept.Version,
+		opts:      opts,
+		conn:      conn,
+		closer:    utils.SpawnCloser(ctx, conn),
+		closeOnce: &sync.Once{},
+		q1:        newStream[protocol.Q1Result](),
+		q2:        newStream[middleware.GameStat](),
+		q3:        newStream[middleware.GameStat](),
+		q4:        newStream[middleware.GameStat](),
+		q5:        newStream[middleware.GameStat](),
+		reported:  make(chan struct{}),
+		sent:      make(chan struct{}),
+		received:  make(chan struct{}),
+	}
+}
+
+// Id assigned by the gateway to the request
+func (j *Job) ID() uint64 { return j.id }
+
+// Protocol version negotiated with the gateway
+func (j *Job) Version() uint16 { return j.version }
+
+// Games per supported platform
+func (j *Job) Q1() iter.Seq2[protocol.Q1Result, error] { return j.q1.all() }
+
+// Top indie games of the 2010s by average playtime
+func (j *Job) Q2() iter.Seq2[middleware.GameStat, error] { return j.q2.all() }
+
+// Top indie games by positive reviews
+func (j *Job) Q3() iter.Seq2[middleware.GameStat, error] { return j.q3.all() }
+
+// Action games with many negative english reviews. Yielded as they are found
+func (j *Job) Q4() iter.Seq2[middleware.GameStat, error] { return j.q4.all() }
+
+// Action games in the 90th percentile of negative reviews
+func (j *Job) Q5() iter.Seq2[middleware.GameStat, error] { return j.q5.all() }
+
+// Blocks until the validation report is received. Fails with ErrNoReport if
+// the gateway predates it
+func (j *Job) Report() (protocol.ValidationReport, error) {
+	<-j.reported
+	return j.report, j.reportErr
+}
+
+// Blocks until all data has been sent, and all results have been received
+func (j *Job) Wait() error {
+	<-j.sent
+	<-j.received
+	return errors.Join(j.sendErr, j.recvErr)
+}
+
+// Waits until all data has been sent, and stops receiving results. The
+// gateway keeps processing the request, its results can be retrieved later
+// with Fetch
+func (j *Job) Detach() error {
+	<-j.sent
+	if j.sendErr != nil {
+		return j.sendErr
+	}
+
+	err := j.conn.Send(protocol.Finish{})
+	return errors.Join(err, j.Close())
+}
+
+// Closes the connection with the gateway, aborting the job if unfinished
+func (j *Job) Close() error {
+	j.closeOnce.Do(func() {
+		j.closeErr = j.closer.Close()
+	})
+	return j.closeErr
+}
+
+func (j *Job) finishSend(err error) {
+	j.sendErr = err
+	close(j.sent)
+	if err != nil {
+		// results will never arrive
+		_ = j.Close()
+	}
+}
+
+func (j *Job) receive() {
+	err := j.receiveResults()
+	if err == nil {
+		err = j.conn.Send(protocol.Finish{})
+	}
+	_ = j.Close()
+
+	// on success, every stream has already been finished
+	j.recvErr = err
+	for _, finish := range []func(error){j.q1.finish, j.q2.finish, j.q3.finish, j.q4.finish, j.q5.finish} {
+		finish(err)
+	}
+	select {
+	case <-j.reported:
+	default:
+		j.reportErr = err
+		if err == nil {
+			j.reportErr = ErrNoReport
+		}
+		close(j.reported)
+	}
+	close(j.received)
+}
+
+func (j *Job) receiveResults() error {
+	results := make(map[int]bool)
+	// older gateways never send the report
+	validated := j.version < protocol.ValidationVersion
+
+	for len(results) < MAX_RESULTS || !validated {
+		var msg any
+		err := j.conn.Recv(&msg)
+		if err != nil {
+			return err
+		}
+
+		switch r := msg.(type) {
+		case protocol.Reject:
+			return &RejectedError{Reason: r.Reason}
+		case protocol.Progress:
+			if j.opts.OnProgress != nil {
+				j.opts.OnProgress(r)
+			}
+		case protocol.ValidationReport:
+			select {
+			case <-j.reported:
+				return fmt.Errorf("%w: validation report received twice", protocol.ErrUnexpectedMessage)
+			default:
+			}
+			validated = true
+			j.report = r
+			close(j.reported)
+		case protocol.Q1Result:
+			results[r.Number()] = true
+			j.q1.push(r)
+			j.q1.finish(nil)
+		case protocol.Q2Result:
+			results[r.Number()] = true
+			j.q2.push(r.TopN...)
+			j.q2.finish(nil)
+		case protocol.Q3Result:
+			results[r.Number()] = true
+			j.q3.push(r.TopN...)
+			j.q3.finish(nil)
+		case protocol.Q4Result:
+			j.q4.push(r.Games...)
+		case protocol.Q4Finish:
+			results[r.Number()] = true
+			j.q4.finish(nil)
+		case protocol.Q5Result:
+			results[r.Number()] = true
+			j.q5.push(r.Percentile90...)
+			j.q5.finish(nil)
+		}
+	}
+
+	return nil
+}
+
+// Rows of a single query's result. Rows are kept after being yielded, so
+// the stream can be iterated any number of times, even concurrently
+type stream[T any] struct {
+	mu   *sync.Mutex
+	cond *sync.Cond
+	rows []T
+	done bool
+	err  error
+}
+
+func newStream[T any]() *stream[T] {
+	mu := &sync.Mutex{}
+	return &stream[T]{
+		mu:   mu,
+		cond: sync.NewCond(mu),
+		rows: make([]T, 0),
+	}
+}
+
+func (s *stream[T]) push(rows ...T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.rows = append(s.rows, rows...)
+	s.cond.Broadcast()
+}
+
+// Marks the stream as complete. Only the first call has effect
+func (s *stream[T]) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	s.err = err
+	s.cond.Broadcast()
+}
+
+// Yields each row as it arrives. If the stream fails, the error is yielded last
+func (s *stream[T]) all() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for i := 0; ; i++ {
+			s.mu.Lock()
+			for i >= len(s.rows) && !s.done {
+				s.cond.Wait()
+			}
+			if i >= len(s.rows) {
+				err := s.err
+				s.mu.Unlock()
+				if err != nil {
+					var zero T
+					yield(zero, err)
+				}
+				return
+			}
+			row := s.rows[i]
+			s.mu.Unlock()
+
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
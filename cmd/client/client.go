@@ -1,278 +1,173 @@
 package main
 
 import (
-	"context"
+	"distribuidos/tp1/client"
+	"distribuidos/tp1/middleware"
 	"distribuidos/tp1/protocol"
-	"distribuidos/tp1/utils"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net"
+	"iter"
 	"os"
-	"sync"
+	"path/filepath"
+	"strconv"
 )
 
 const GAMES_PATH = ".data/games.csv"
 const REVIEWS_PATH = ".data/reviews.csv"
 const RESULTS_PATH = ".results"
-const MAX_RESULTS = 5
+const REJECTED_FILE = "rejected.csv"
 
-type client struct {
-	config   config
-	id       uint64
-	conn     *protocol.Conn
-	dataConn *protocol.Conn
-	results  map[int]bool
-}
+// Formats of the results files
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_JSON = "json"
+)
 
-func newClient(config config) *client {
-	protocol.Register()
-	return &client{
-		config:  config,
-		results: make(map[int]bool),
-	}
+// Where, and how, the results of a job are written
+type output struct {
+	dir    string
+	format string
+	// queries whose results are written. The gateway resolves all of them,
+	// so the rest are still received, but not written
+	queries []int
 }
 
-// Connects client to connection endpoint and data endpoint
-func (c *client) start(ctx context.Context) (err error) {
-	err = c.startConnection()
-	if err != nil {
-		return
-	}
-	closer := utils.SpawnCloser(ctx, c.conn)
-	defer func() {
-		closeErr := closer.Close()
-		err = errors.Join(err, closeErr)
-	}()
-
-	if err = c.sendRequest(); err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := c.sendData(ctx); err != nil {
-			log.Errorf("Failed to send data: %v", err)
+// Writes each query's results to a file as they are received, followed by
+// the validation report
+func (o output) write(job *client.Job) error {
+	q1ToRows := func(r protocol.Q1Result) [][]string { return r.ToCSV() }
+	statToRows := func(s middleware.GameStat) [][]string {
+		return protocol.GameStatsToCSV([]middleware.GameStat{s})
+	}
+
+	for _, q := range o.queries {
+		var err error
+		switch q {
+		case 1:
+			err = writeResult(o, protocol.Q1Result{}, job.Q1(), q1ToRows)
+		case 2:
+			err = writeResult(o, protocol.Q2Result{}, job.Q2(), statToRows)
+		case 3:
+			err = writeResult(o, protocol.Q3Result{}, job.Q3(), statToRows)
+		case 4:
+			err = writeResult(o, protocol.Q4Result{}, job.Q4(), statToRows)
+		case 5:
+			err = writeResult(o, protocol.Q5Result{}, job.Q5(), statToRows)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write Q%v results: %w", q, err)
 		}
-	}()
-	defer wg.Wait()
-
-	if err = c.waitResults(); err != nil {
-		return fmt.Errorf("failed to wait results: %w", err)
-	}
-
-	return nil
-}
-
-func (c *client) startConnection() error {
-	conn, err := net.Dial("tcp", c.config.ConnectionEndpointAddress)
-	if err != nil {
-		return err
 	}
-	c.conn = protocol.NewConn(conn)
-	log.Info("Connected to connection gateway")
-	return nil
-}
 
-// Starts connection with connection endpoint, sending request hello and waiting for id
-func (c *client) sendRequest() error {
-	err := c.sendRequestHello()
-	if err != nil {
-		return fmt.Errorf("failed to send hello: %w", err)
-	}
-	err = c.waitID()
-	if err != nil {
-		return fmt.Errorf("failed to receive id: %w", err)
+	report, err := job.Report()
+	switch {
+	case errors.Is(err, client.ErrNoReport):
+		log.Warningf("Gateway does not send validation reports, skipping %v", REJECTED_FILE)
+	case err != nil:
+		return fmt.Errorf("failed to receive validation report: %w", err)
+	default:
+		err = writeValidationReport(filepath.Join(o.dir, REJECTED_FILE), report)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
-
-func (c *client) sendRequestHello() error {
-	gameSize, err := getFileSize(GAMES_PATH)
+	err = job.Wait()
 	if err != nil {
 		return err
 	}
-	reviewsSize, err := getFileSize(REVIEWS_PATH)
-	if err != nil {
-		return err
-	}
-
-	request := protocol.RequestHello{
-		GameSize:   gameSize,
-		ReviewSize: reviewsSize,
-	}
-
-	return c.conn.Send(&request)
-}
-
-func (c *client) waitID() error {
-	var msg protocol.AcceptRequest
-	err := c.conn.Recv(&msg)
-	if err != nil {
-		return fmt.Errorf("could not receive id from gateway: %w", err)
-	}
-
-	c.id = msg.ClientID
-	log.Infof("Received ID: %v", c.id)
-	return nil
-}
-
-// Starts connection with data endpoint and sends games and reviews files. When done closes connection
-func (c *client) sendData(ctx context.Context) (err error) {
-	err = c.startDataConnection()
-	if err != nil {
-		return
-	}
-
-	closer := utils.SpawnCloser(ctx, c.dataConn)
-	defer func() {
-		closeErr := closer.Close()
-		err = errors.Join(err, closeErr)
-	}()
-
-	err = c.sendDataHello()
-	if err != nil {
-		return fmt.Errorf("failed to send data hello: %w", err)
-	}
-	err = c.sendFile(GAMES_PATH)
-	if err != nil {
-		return fmt.Errorf("failed to send games: %w", err)
-	}
-	log.Info("Sent all games")
-	err = c.sendFile(REVIEWS_PATH)
-	if err != nil {
-		return fmt.Errorf("failed to send reviews: %w", err)
-	}
-	log.Info("Sent all reviews")
+	log.Infof("Received all results")
 
 	return nil
 }
 
-func (c *client) startDataConnection() error {
-	dataConn, err := net.Dial("tcp", c.config.DataEndpointAddress)
+// Writes the rows of a query's result to its file
+func writeResult[T any](o output, q protocol.Result, rows iter.Seq2[T, error], toRows func(T) [][]string) error {
+	path := filepath.Join(o.dir, fmt.Sprintf("%v.%v", q.Number(), o.format))
+	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
-	log.Info("Connected to data gateway")
-	c.dataConn = protocol.NewConn(dataConn)
-	return nil
-}
+	defer file.Close()
 
-func (c *client) sendDataHello() error {
-	hello := protocol.DataHello{
-		ClientID: c.id,
+	switch o.format {
+	case FORMAT_JSON:
+		err = writeJSONResult(file, q.Header(), rows, toRows)
+	default:
+		err = writeCSVResult(file, q.Header(), rows, toRows)
 	}
-	err := c.dataConn.Send(&hello)
 	if err != nil {
 		return err
 	}
+	log.Infof("Received Q%v results", q.Number())
 
-	var accept protocol.DataAccept
-	return c.dataConn.Recv(&accept)
+	return nil
 }
 
-// Sends specified file in different batches of size obtained from config
-func (c *client) sendFile(filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	buf := make([]byte, c.config.BatchSize)
-	for {
-		n, err := file.Read(buf)
-		if n > 0 {
-			sendErr := c.dataConn.SendAny(&protocol.Batch{Data: buf[:n]})
-			if sendErr != nil {
-				return sendErr
-			}
-		}
-		if err == io.EOF {
-			break
-		}
+func writeCSVResult[T any](w io.Writer, header []string, rows iter.Seq2[T, error], toRows func(T) [][]string) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write(header)
+	for row, err := range rows {
 		if err != nil {
 			return err
 		}
+		_ = writer.WriteAll(toRows(row))
 	}
-
-	return c.dataConn.SendAny(&protocol.Finish{})
+	writer.Flush()
+	return writer.Error()
 }
 
-func (c *client) waitResults() error {
-	writers, err := initResultWriters()
-	if err != nil {
-		return err
-	}
-
-	for {
-		var r protocol.Result
-		err := c.conn.Recv(&r)
+// Writes the rows as a list of objects, with the columns of the header as keys
+func writeJSONResult[T any](w io.Writer, header []string, rows iter.Seq2[T, error], toRows func(T) [][]string) error {
+	objects := make([]map[string]any, 0)
+	for row, err := range rows {
 		if err != nil {
 			return err
 		}
-
-		switch r := r.(type) {
-		case protocol.Q4Finish:
-			log.Infof("Received Q4 Finish")
-			c.results[r.Number()] = true
-		default:
-			log.Infof("Received Q%v results", r.Number())
-			if r.Number() != 4 {
-				c.results[r.Number()] = true
+		for _, fields := range toRows(row) {
+			object := make(map[string]any, len(header))
+			for i, column := range header {
+				object[column] = jsonValue(fields[i])
 			}
-			writer := writers[r.Number()-1]
-
-			err = writer.WriteAll(r.ToCSV())
-			if err != nil {
-				return err
-			}
-		}
-
-		if len(c.results) == MAX_RESULTS {
-			log.Infof("Received all results")
-			break
+			objects = append(objects, object)
 		}
 	}
-	return c.conn.Send(protocol.Finish{})
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(objects)
 }
 
-func getFileSize(filePath string) (uint64, error) {
-	file, err := os.Stat(filePath)
+// Numeric fields are encoded as numbers, the rest as strings
+func jsonValue(field string) any {
+	n, err := strconv.ParseUint(field, 10, 64)
 	if err != nil {
-		return 0, err
+		return field
 	}
-	return uint64(file.Size()), nil
+	return n
 }
 
-func initResultWriter(q protocol.Result) (*csv.Writer, error) {
-	n := q.Number()
-	path := fmt.Sprintf("%v/%v.csv", RESULTS_PATH, n)
+// Logs the rejection totals, and writes the sample of rejected rows
+func writeValidationReport(path string, report protocol.ValidationReport) error {
+	for _, r := range report.Rejections {
+		log.Warningf("Rejected %v rows from %v: %v", r.Count, r.File, r.Reason)
+	}
+
 	file, err := os.Create(path)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	writer := csv.NewWriter(file)
-	_ = writer.Write(q.Header())
-	writer.Flush()
-	err = writer.Error()
-	return writer, err
-}
-
-func initResultWriters() ([]*csv.Writer, error) {
-	writers := make([]*csv.Writer, MAX_RESULTS)
-	for i, result := range protocol.AllResultTypes() {
-		writer, err := initResultWriter(result)
-		if err != nil {
-			return nil, err
-		}
+	defer file.Close()
 
-		writers[i] = writer
+	writer := csv.NewWriter(file)
+	_ = writer.Write([]string{"File", "Line", "Reason", "Record"})
+	for _, s := range report.Samples {
+		_ = writer.Write([]string{s.File, strconv.Itoa(int(s.Line)), s.Reason, s.Record})
 	}
+	writer.Flush()
 
-	return writers, nil
+	return writer.Error()
 }
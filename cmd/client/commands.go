@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"distribuidos/tp1/client"
+	"distribuidos/tp1/protocol"
+	"distribuidos/tp1/results"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const USAGE = `Usage: client [command] [flags] [args]
+
+Commands:
+  submit                  sends a request and waits for its results (default)
+  status <id>             shows the status of a request
+  fetch <id>              receives the results of a finished request
+  compare <dir> <dir>     compares two results directories
+
+Run 'client <command> -h' to see the flags of each command
+`
+
+var unknownCommandError = errors.New("unknown command")
+
+// Runs the command given by the arguments. Without a command, runs submit
+func runCommand(ctx context.Context, config config, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runSubmit(ctx, config, args)
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "submit":
+		return runSubmit(ctx, config, args)
+	case "status":
+		return runStatus(ctx, config, args)
+	case "fetch":
+		return runFetch(ctx, config, args)
+	case "compare":
+		return runCompare(args)
+	case "help":
+		fmt.Fprint(os.Stderr, USAGE)
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, USAGE)
+	return fmt.Errorf("%w: %v", unknownCommandError, command)
+}
+
+// Flags shared by the commands that write results
+type outputFlags struct {
+	dir     string
+	format  string
+	queries string
+}
+
+func (o *outputFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.dir, "output", RESULTS_PATH, "directory where results are written")
+	fs.StringVar(&o.format, "format", FORMAT_CSV, "format of the results, csv or json")
+	fs.StringVar(&o.queries, "queries", "1,2,3,4,5", "comma separated list of queries whose results are written, all of them are still resolved")
+}
+
+func (o *outputFlags) toOutput() (output, error) {
+	if o.format != FORMAT_CSV && o.format != FORMAT_JSON {
+		return output{}, fmt.Errorf("unknown results format %q", o.format)
+	}
+	queries, err := parseQueries(o.queries)
+	if err != nil {
+		return output{}, err
+	}
+	err = os.MkdirAll(o.dir, 0o755)
+	if err != nil {
+		return output{}, err
+	}
+
+	return output{dir: o.dir, format: o.format, queries: queries}, nil
+}
+
+func runSubmit(ctx context.Context, config config, args []string) error {
+	fs := flag.NewFlagSet("submit", flag.ContinueOnError)
+	games := fs.String("games", config.GamesPath, "path of the games file, its format is inferred from the extension")
+	reviews := fs.String("reviews", config.ReviewsPath, "path of the reviews file, its format is inferred from the extension")
+	detach := fs.Bool("detach", false, "exit once the files are sent, results can then be retrieved with fetch")
+	var flags outputFlags
+	flags.register(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", positional)
+	}
+	out, err := flags.toOutput()
+	if err != nil {
+		return err
+	}
+
+	display := newProgressDisplay()
+	defer display.done()
+
+	job, err := client.SubmitFiles(ctx, *games, *reviews, client.Options{
+		ConnectionAddress: config.ConnectionEndpointAddress,
+		DataAddress:       config.DataEndpointAddress,
+		BatchSize:         config.BatchSize,
+		MaxRetries:        config.MaxRetries,
+		OnProgress:        display.render,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer job.Close()
+	log.Infof("Received ID: %v, using protocol version %v", job.ID(), job.Version())
+
+	if *detach {
+		err = job.Detach()
+		if err != nil {
+			return fmt.Errorf("failed to detach: %w", err)
+		}
+		log.Infof("Sent all data, fetch the results with: client fetch %v", job.ID())
+		fmt.Println(job.ID())
+		return nil
+	}
+
+	return out.write(job)
+}
+
+func runStatus(ctx context.Context, config config, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(positional)
+	if err != nil {
+		return err
+	}
+
+	status, err := client.Status(ctx, config.ConnectionEndpointAddress, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Request %v: %v\n", status.ClientID, formatState(status.State))
+	if status.State == protocol.JobUnknown {
+		return nil
+	}
+	queries := make([]string, 0, len(status.FinishedQueries))
+	for _, q := range status.FinishedQueries {
+		queries = append(queries, fmt.Sprintf("Q%v", q))
+	}
+	if len(queries) == 0 {
+		queries = append(queries, "none")
+	}
+	fmt.Printf("Finished queries: %v\n", strings.Join(queries, " "))
+	fmt.Printf("Progress: %v\n", formatProgress(status.Progress))
+
+	return nil
+}
+
+func runFetch(ctx context.Context, config config, args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	var flags outputFlags
+	flags.register(fs)
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(positional)
+	if err != nil {
+		return err
+	}
+	out, err := flags.toOutput()
+	if err != nil {
+		return err
+	}
+
+	job, err := client.Fetch(ctx, config.ConnectionEndpointAddress, id)
+	if err != nil {
+		return err
+	}
+	defer job.Close()
+
+	return out.write(job)
+}
+
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	tolerance := fs.Float64("tolerance", 0, "maximum difference between two numeric values to consider them equal")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("expected two results directories, got %v arguments", len(positional))
+	}
+
+	return compareDirs(positional[0], positional[1], results.Options{Tolerance: *tolerance})
+}
+
+// Parses flags placed anywhere between the positional arguments, which are returned
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func parseID(positional []string) (uint64, error) {
+	if len(positional) != 1 {
+		return 0, fmt.Errorf("expected a single request id, got %v arguments", len(positional))
+	}
+	id, err := strconv.ParseUint(positional[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid request id %q", positional[0])
+	}
+	return id, nil
+}
+
+// Parses a comma separated list of query numbers
+func parseQueries(spec string) ([]int, error) {
+	queries := make([]int, 0)
+	for _, q := range strings.Split(spec, ",") {
+		q = strings.TrimPrefix(strings.TrimSpace(q), "Q")
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 || n > client.MAX_RESULTS {
+			return nil, fmt.Errorf("invalid query %q, expected a number between 1 and %v", q, client.MAX_RESULTS)
+		}
+		if !slices.Contains(queries, n) {
+			queries = append(queries, n)
+		}
+	}
+	slices.Sort(queries)
+	return queries, nil
+}
+
+func formatState(state protocol.JobState) string {
+	switch state {
+	case protocol.JobRunning:
+		return "running"
+	case protocol.JobFinished:
+		return "finished"
+	case protocol.JobFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"distribuidos/tp1/results"
+	"errors"
+	"os"
+)
+
+var differentResultsError = errors.New("results are different")
+
+// Compares the results of each query, and prints the differences
+func compareDirs(actual string, expected string, opts results.Options) error {
+	actualResults, err := results.Load(actual)
+	if err != nil {
+		return err
+	}
+	expectedResults, err := results.Load(expected)
+	if err != nil {
+		return err
+	}
+
+	diffs := results.Compare(actualResults, expectedResults, opts)
+	if results.WriteReport(os.Stdout, diffs) {
+		return differentResultsError
+	}
+	return nil
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"distribuidos/tp1/client"
+	"errors"
+	"flag"
+	"os"
 	"os/signal"
 	"syscall"
 
@@ -15,20 +19,29 @@ type config struct {
 	ConnectionEndpointAddress string
 	DataEndpointAddress       string
 	BatchSize                 int
+	// Input files, their format is inferred from the extension
+	GamesPath   string
+	ReviewsPath string
+	// Times to retry the request while the gateway is busy
+	MaxRetries int
 }
 
-const KB int = 1 << 10
-
 func getConfig() (config, error) {
 	v := viper.New()
 
-	v.SetDefault("ConnectionEndpointAddress", "127.0.0.1:9001")
-	v.SetDefault("DataEndpointAddress", "127.0.0.1:9002")
-	v.SetDefault("BatchSize", 8*KB)
+	v.SetDefault("ConnectionEndpointAddress", client.DEFAULT_CONNECTION_ADDRESS)
+	v.SetDefault("DataEndpointAddress", client.DEFAULT_DATA_ADDRESS)
+	v.SetDefault("BatchSize", client.DEFAULT_BATCH_SIZE)
+	v.SetDefault("MaxRetries", 10)
+	v.SetDefault("GamesPath", GAMES_PATH)
+	v.SetDefault("ReviewsPath", REVIEWS_PATH)
 
 	_ = v.BindEnv("ConnectionEndpointAddress", "GATEWAY_CONN_ADDR")
 	_ = v.BindEnv("DataEndpointAddress", "GATEWAY_DATA_ADDR")
 	_ = v.BindEnv("BatchSize", "BATCH_SIZE")
+	_ = v.BindEnv("MaxRetries", "MAX_RETRIES")
+	_ = v.BindEnv("GamesPath", "GAMES_PATH")
+	_ = v.BindEnv("ReviewsPath", "REVIEWS_PATH")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -41,11 +54,12 @@ func main() {
 		log.Fatalf("Failed to read config: %v", err)
 	}
 
-	client := newClient(config)
-
 	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 
-	err = client.start(ctx)
+	err = runCommand(ctx, config, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatalf("Failed to run client: %v", err)
 	}
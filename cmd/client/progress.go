@@ -0,0 +1,97 @@
+package main
+
+import (
+	"distribuidos/tp1/protocol"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var queryPattern = regexp.MustCompile(`Q[1-5]`)
+
+// Renders progress messages received from the gateway. When attached to a
+// terminal, a single status line is updated in place, otherwise each
+// message is logged
+type progressDisplay struct {
+	out         *os.File
+	interactive bool
+	lastWidth   int
+}
+
+func newProgressDisplay() *progressDisplay {
+	info, err := os.Stderr.Stat()
+	interactive := err == nil && info.Mode()&os.ModeCharDevice != 0
+
+	return &progressDisplay{
+		out:         os.Stderr,
+		interactive: interactive,
+	}
+}
+
+func (d *progressDisplay) render(p protocol.Progress) {
+	line := formatProgress(p)
+	if !d.interactive {
+		log.Infof("Progress: %v", line)
+		return
+	}
+
+	padding := max(d.lastWidth-len(line), 0)
+	d.lastWidth = len(line)
+	fmt.Fprintf(d.out, "\r%v%v", line, strings.Repeat(" ", padding))
+}
+
+// Ends the status line, so that following logs are not mixed with it
+func (d *progressDisplay) done() {
+	if d.interactive && d.lastWidth > 0 {
+		fmt.Fprintln(d.out)
+	}
+}
+
+func formatProgress(p protocol.Progress) string {
+	return fmt.Sprintf(
+		"sent %v in %v batches | games %v ok, %v rejected | reviews %v ok, %v rejected | finished %v",
+		formatBytes(p.BytesReceived), p.BatchesReceived,
+		p.GamesParsed, p.GamesRejected,
+		p.ReviewsParsed, p.ReviewsRejected,
+		formatStages(p.FinishedStages),
+	)
+}
+
+// Groups finished stages by query, stages that don't belong to a single
+// query (ej: filters) are grouped under "common"
+func formatStages(stages []string) string {
+	byQuery := make(map[string]int)
+	for _, stage := range stages {
+		query := queryPattern.FindString(stage)
+		if query == "" {
+			query = "common"
+		}
+		byQuery[query] += 1
+	}
+	if len(byQuery) == 0 {
+		return "none"
+	}
+
+	queries := make([]string, 0, len(byQuery))
+	for query, count := range byQuery {
+		queries = append(queries, fmt.Sprintf("%v:%v", query, count))
+	}
+	slices.Sort(queries)
+
+	return strings.Join(queries, " ")
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%vB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
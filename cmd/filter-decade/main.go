@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"fmt"
 	"os/signal"
@@ -19,6 +20,9 @@ var log = logging.MustGetLogger("log")
 type config struct {
 	RabbitIP string
 	Decade   int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -26,9 +30,12 @@ func getConfig() (config, error) {
 
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("Decade", "2010")
+	v.SetDefault("Stage", "decade-filter")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("Decade", "DECADE")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -57,14 +64,10 @@ func main() {
 	}
 
 	key := fmt.Sprintf("%v-%v", middleware.DecadeKeyPrefix, cfg.Decade)
-	filterCfg := middleware.FilterConfig{
-		RabbitIP: cfg.RabbitIP,
-		Queue:    middleware.GamesDecade,
-		Exchange: middleware.ExchangeDecade,
-		QueuesByKey: map[string][]string{
-			key: {middleware.GamesQ2},
-		},
-	}
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	filterCfg, err := stage.Filter(cfg.RabbitIP, key)
+	utils.Expect(err, "Failed to configure filter")
 
 	h := handler{
 		decade: cfg.Decade,
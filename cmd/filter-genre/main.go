@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"os/signal"
 	"slices"
@@ -17,6 +18,9 @@ var log = logging.MustGetLogger("log")
 type config struct {
 	RabbitIP  string
 	BatchSize int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -24,9 +28,12 @@ func getConfig() (config, error) {
 
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("BatchSize", "100")
+	v.SetDefault("Stage", "genre-filter")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("BatchSize", "BATCH_SIZE")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -50,21 +57,11 @@ func main() {
 		log.Fatalf("failed to read config: %v", err)
 	}
 
-	filterCfg := middleware.FilterConfig{
-		RabbitIP: cfg.RabbitIP,
-		Queue:    middleware.GamesGenre,
-		Exchange: middleware.ExchangeGenre,
-		QueuesByKey: map[string][]string{
-			middleware.IndieKey: {
-				middleware.GamesDecade,
-				middleware.GamesQ3,
-			},
-			middleware.ActionKey: {
-				middleware.GamesQ4,
-				middleware.GamesQ5,
-			},
-		},
-	}
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	filterCfg, err := stage.Filter(cfg.RabbitIP, middleware.IndieKey, middleware.ActionKey)
+	utils.Expect(err, "Failed to configure filter")
+
 	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 	p, err := middleware.NewFilter(filterCfg, Filter)
 	utils.Expect(err, "Failed to create filter")
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"distribuidos/tp1/language"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"os/signal"
 	"syscall"
@@ -17,14 +19,20 @@ var log = logging.MustGetLogger("log")
 
 type config struct {
 	RabbitIP string
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
 	v := viper.New()
 
 	v.SetDefault("RabbitIP", "localhost")
+	v.SetDefault("Stage", "language-filter")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -45,8 +53,7 @@ func (h handler) Filter(r middleware.Review) []string {
 
 // Detects if received text is English or not
 func (h handler) isEnglish(text string) bool {
-	lang, _ := h.detector.DetectLanguageOf(text)
-	return lang == lingua.English
+	return language.IsEnglish(h.detector, text)
 }
 
 func main() {
@@ -55,27 +62,15 @@ func main() {
 		log.Fatalf("failed to read config: %v", err)
 	}
 
-	languages := []lingua.Language{
-		lingua.English,
-		lingua.Spanish,
-	}
-	detector := lingua.NewLanguageDetectorBuilder().
-		FromLanguages(languages...).
-		Build()
 	h := handler{
-		detector: detector,
+		detector: language.NewDetector(),
 	}
 
-	filterCfg := middleware.FilterConfig{
-		RabbitIP: cfg.RabbitIP,
-		Queue:    middleware.ReviewsLanguage,
-		Exchange: middleware.ExchangeLanguage,
-		QueuesByKey: map[string][]string{
-			middleware.EnglishKey: {
-				middleware.ReviewsQ4,
-			},
-		},
-	}
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	filterCfg, err := stage.Filter(cfg.RabbitIP, middleware.EnglishKey)
+	utils.Expect(err, "Failed to configure filter")
+
 	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 	p, err := middleware.NewFilter(filterCfg, h.Filter)
 	utils.Expect(err, "Failed to create filter")
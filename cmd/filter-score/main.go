@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"os/signal"
 	"syscall"
@@ -15,6 +16,9 @@ var log = logging.MustGetLogger("log")
 
 type config struct {
 	RabbitIP string
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func Filter(r middleware.Review) []string {
@@ -29,8 +33,11 @@ func getConfig() (config, error) {
 	v := viper.New()
 
 	v.SetDefault("RabbitIP", "localhost")
+	v.SetDefault("Stage", "review-filter")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -43,20 +50,11 @@ func main() {
 		log.Fatalf("Failed to read config: %v", err)
 	}
 
-	filterCfg := middleware.FilterConfig{
-		RabbitIP: cfg.RabbitIP,
-		Queue:    middleware.ReviewsScore,
-		Exchange: middleware.ExchangeScore,
-		QueuesByKey: map[string][]string{
-			middleware.PositiveKey: {
-				middleware.ReviewsQ3,
-			},
-			middleware.NegativeKey: {
-				middleware.ReviewsQ5,
-				middleware.ReviewsLanguage,
-			},
-		},
-	}
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	filterCfg, err := stage.Filter(cfg.RabbitIP, middleware.PositiveKey, middleware.NegativeKey)
+	utils.Expect(err, "Failed to configure filter")
+
 	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 	p, err := middleware.NewFilter(filterCfg, Filter)
 	utils.Expect(err, "Failed to create filter")
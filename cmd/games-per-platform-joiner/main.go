@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
 	"encoding/binary"
@@ -19,18 +20,21 @@ import (
 var log = logging.MustGetLogger("log")
 
 type config struct {
-	RabbitIP   string
-	Partitions int
+	RabbitIP string
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
 	v := viper.New()
 
 	v.SetDefault("RabbitIP", "localhost")
-	v.SetDefault("Partitions", "1")
+	v.SetDefault("Stage", "q1-joiner")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
-	_ = v.BindEnv("Partitions", "PARTITIONS")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -178,11 +182,20 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
+	spec, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	input, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	qOutput, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+	partitions, err := spec.Partitions(stage)
+	utils.Expect(err, "Failed to load pipeline")
+
 	queues := make([]middleware.QueueConfig, 0)
 	endpoints := make(map[string]middleware.HandlerFunc[*handler], 0)
 
-	for i := 1; i <= cfg.Partitions; i++ {
-		qName := middleware.Cat(middleware.PartialQ1, i)
+	for i := 1; i <= partitions; i++ {
+		qName := middleware.Cat(input, i)
 		qcfg := middleware.QueueConfig{
 			Name: qName,
 		}
@@ -190,7 +203,6 @@ func main() {
 		endpoints[qName] = buildHandler(i)
 	}
 
-	qOutput := middleware.Results
 	queues = append(queues, middleware.QueueConfig{Name: qOutput})
 
 	err = middleware.Topology{
@@ -207,7 +219,7 @@ func main() {
 			db, err := database.NewDatabase(database_path)
 			utils.Expect(err, "unrecoverable error")
 
-			joiner := middleware.NewJoinerDisk("joiner", cfg.Partitions)
+			joiner := middleware.NewJoinerDisk("joiner", partitions)
 			err = joiner.Load(db)
 			utils.Expect(err, "unrecoverable error")
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"encoding/binary"
 	"io"
@@ -19,6 +20,9 @@ var log = logging.MustGetLogger("log")
 type config struct {
 	RabbitIP    string
 	PartitionID int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -26,9 +30,12 @@ func getConfig() (config, error) {
 
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("PartitionID", "0")
+	v.SetDefault("Stage", "q1-count")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("PartitionID", "PARTITION_ID")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -176,8 +183,15 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
-	inputQ := middleware.Cat(middleware.GamesQ1, "x", cfg.PartitionID)
-	outputQ := middleware.Cat(middleware.PartialQ1, cfg.PartitionID)
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	input, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	output, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+
+	inputQ := middleware.Cat(input, cfg.PartitionID)
+	outputQ := middleware.Cat(output, cfg.PartitionID)
 	err = middleware.Topology{
 		Queues: []middleware.QueueConfig{
 			{Name: inputQ},
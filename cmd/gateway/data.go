@@ -2,18 +2,15 @@ package main
 
 import (
 	"context"
+	"distribuidos/tp1/dataset"
 	"distribuidos/tp1/middleware"
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strconv"
-	"strings"
 	"sync"
-	"time"
 )
 
 func (g *gateway) startDataEndpoint(ctx context.Context) (err error) {
@@ -67,10 +64,23 @@ func (g *gateway) handleClientData(ctx context.Context, rawConn net.Conn) (err e
 	log.Infof("Client data hello with id: %v", hello.ClientID)
 	g.mu.Lock()
 	if _, ok := g.clients[int(hello.ClientID)]; !ok {
+		g.mu.Unlock()
 		return fmt.Errorf("Client ID received is unknown")
 	}
+	request := g.requests[int(hello.ClientID)]
 	g.mu.Unlock()
 
+	// legacy clients send both files, one after the other
+	var streams []protocol.DataStream
+	switch hello.Stream {
+	case protocol.StreamAll:
+		streams = []protocol.DataStream{protocol.StreamGames, protocol.StreamReviews}
+	case protocol.StreamGames, protocol.StreamReviews:
+		streams = []protocol.DataStream{hello.Stream}
+	default:
+		return fmt.Errorf("unknown data stream %v", hello.Stream)
+	}
+
 	err = conn.Send(&protocol.DataAccept{})
 	if err != nil {
 		return err
@@ -80,6 +90,7 @@ func (g *gateway) handleClientData(ctx context.Context, rawConn net.Conn) (err e
 	if err != nil {
 		return err
 	}
+	defer rawCh.Close()
 	err = rawCh.Confirm(false)
 	if err != nil {
 		return err
@@ -90,43 +101,77 @@ func (g *gateway) handleClientData(ctx context.Context, rawConn net.Conn) (err e
 		CleanAction: middleware.NotClean,
 	}
 
+	progress := g.getProgress(int(hello.ClientID))
+
 	wg := &sync.WaitGroup{}
-	wg.Add(2)
+	defer wg.Wait()
 
-	gamesRecv, gamesSend := net.Pipe()
-	go func() {
-		defer wg.Done()
-		err := g.queueGames(gamesRecv, ch)
+	for _, stream := range streams {
+		send := g.spawnQueue(ctx, wg, stream, request, ch, progress)
+		err = g.receiveStream(conn, send, progress)
 		if err != nil {
-			log.Errorf("Error while queuing games: %v", err)
+			return err
 		}
-	}()
-	err = g.receiveData(conn, gamesSend)
-	if err != nil {
-		return err
 	}
-	gamesSend.Close()
 
-	reviewsRecv, reviewsSend := net.Pipe()
+	wg.Wait()
+	for _, stream := range streams {
+		progress.finishStream(stream)
+	}
+
+	return nil
+}
+
+// Spawns a goroutine that queues the records of the given stream, as they
+// are written to the returned pipe. The stream is only finished if the pipe
+// is closed without error
+func (g *gateway) spawnQueue(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	stream protocol.DataStream,
+	request protocol.RequestHello,
+	ch middleware.Channel,
+	progress *clientProgress,
+) *io.PipeWriter {
+	recv, send := io.Pipe()
+
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err := g.queueReviews(reviewsRecv, ch)
-		if err != nil {
-			log.Errorf("Error while queuing reviews: %v", err)
+		// unblocks the writer if queueing fails
+		defer recv.Close()
+
+		var err error
+		switch stream {
+		case protocol.StreamGames:
+			err = g.queueGames(ctx, recv, request.GamesFormat, ch, progress)
+			if err != nil {
+				log.Errorf("Error while queuing games: %v", err)
+			}
+		case protocol.StreamReviews:
+			err = g.queueReviews(ctx, recv, request.ReviewsFormat, ch, progress)
+			if err != nil {
+				log.Errorf("Error while queuing reviews: %v", err)
+			}
 		}
 	}()
-	err = g.receiveData(conn, reviewsSend)
-	if err != nil {
-		return err
-	}
-	reviewsSend.Close()
 
-	wg.Wait()
+	return send
+}
 
-	return nil
+// Writes the data of the stream to the pipe until the client finishes it. A
+// truncated upload closes the pipe with an error, so that the parser fails
+// instead of finishing the stream as if it was complete
+func (g *gateway) receiveStream(conn *protocol.Conn, send *io.PipeWriter, progress *clientProgress) error {
+	err := g.receiveData(conn, send, progress)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	send.CloseWithError(err)
+	return err
 }
 
-func (g *gateway) receiveData(unm *protocol.Conn, w io.Writer) error {
+func (g *gateway) receiveData(unm *protocol.Conn, w io.Writer, progress *clientProgress) error {
 	for {
 		var anyMsg any
 		err := unm.Recv(&anyMsg)
@@ -140,15 +185,25 @@ func (g *gateway) receiveData(unm *protocol.Conn, w io.Writer) error {
 			if err != nil {
 				return err
 			}
+			progress.bytes.Add(uint64(len(msg.Data)))
+			progress.batches.Add(1)
 		case protocol.Finish:
 			return nil
 		}
 	}
 }
 
-func (g *gateway) queueGames(r io.Reader, ch middleware.Channel) error {
-	csvReader := csv.NewReader(r)
-	csvReader.FieldsPerRecord = -1
+func (g *gateway) queueGames(ctx context.Context, r io.Reader, format string, ch middleware.Channel, progress *clientProgress) error {
+	limiter, err := g.newIngestLimiter(progress.rate, g.config.stage.RoutedQueues(g.config.gamesExchange)...)
+	if err != nil {
+		return err
+	}
+	defer limiter.Close()
+
+	reader, err := dataset.NewRecordReader(r, format, g.config.gamesSchema)
+	if err != nil {
+		return err
+	}
 
 	var sentGames int
 	batch := middleware.Batch[middleware.Game]{
@@ -157,48 +212,60 @@ func (g *gateway) queueGames(r io.Reader, ch middleware.Channel) error {
 		EOF:     false,
 	}
 
-	_, err := csvReader.Read()
+	header, err := reader.Header()
 	if err != nil {
 		return err
 	}
+	mapping, err := dataset.NewColumnMapping(header, g.config.gamesSchema)
+	if err != nil {
+		return fmt.Errorf("invalid games header: %w", err)
+	}
 
-	for {
-		record, err := csvReader.Read()
-		if errors.Is(err, &csv.ParseError{}) {
+	parse := func(record []string) (middleware.Game, error) {
+		return dataset.GameFromRecord(record, mapping)
+	}
+	reject := func(line int, record []string, err error) {
+		progress.gamesRejected.Add(1)
+		var malformedErr *dataset.MalformedRecordError
+		if errors.As(err, &malformedErr) {
 			log.Errorf("Failed to parse row: %v", err)
-			continue
+			progress.report.rejectMalformed(GAMES_FILE, malformedErr)
+			return
 		}
-		if errors.Is(err, io.EOF) {
-			break
+		// ignoring known errors to avoid spam
+		if !errors.Is(err, dataset.ErrEmptyGameName) && !errors.Is(err, dataset.ErrEmptyGameGenres) {
+			log.Errorf("Failed to parse game: %v", err)
+		}
+		progress.report.reject(GAMES_FILE, line, record, err)
+	}
+	send := func(game middleware.Game) error {
+		batch.Data = append(batch.Data, game)
+		sentGames += 1
+		progress.gamesParsed.Add(1)
+
+		if len(batch.Data) < g.config.BatchSize {
+			return nil
 		}
+		err := limiter.Wait(ctx)
 		if err != nil {
 			return err
 		}
-
-		game, err := gameFromFullRecord(record)
+		err = ch.Send(batch, g.config.gamesExchange, "")
 		if err != nil {
-			// ignoring known errors to avoid spam
-			if err != emptyGameNameError && err != emptyGameGenresError {
-				log.Errorf("Failed to parse game: %v", err)
-			}
-			continue
+			return err
 		}
+		batch.Data = batch.Data[:0]
+		batch.BatchID += 1
+		return nil
+	}
 
-		batch.Data = append(batch.Data, game)
-		sentGames += 1
-
-		if len(batch.Data) == g.config.BatchSize {
-			err = ch.Send(batch, middleware.ExchangeGames, "")
-			if err != nil {
-				return err
-			}
-			batch.Data = batch.Data[:0]
-			batch.BatchID += 1
-		}
+	err = dataset.ParseRecords(reader, g.config.ParseWorkers, parse, send, reject)
+	if err != nil {
+		return err
 	}
 
 	batch.EOF = true
-	err = ch.Send(batch, middleware.ExchangeGames, "")
+	err = ch.Send(batch, g.config.gamesExchange, "")
 	if err != nil {
 		return err
 	}
@@ -208,9 +275,17 @@ func (g *gateway) queueGames(r io.Reader, ch middleware.Channel) error {
 	return nil
 }
 
-func (g *gateway) queueReviews(r io.Reader, ch middleware.Channel) error {
-	csvReader := csv.NewReader(r)
-	csvReader.FieldsPerRecord = -1
+func (g *gateway) queueReviews(ctx context.Context, r io.Reader, format string, ch middleware.Channel, progress *clientProgress) error {
+	limiter, err := g.newIngestLimiter(progress.rate, g.config.stage.RoutedQueues(g.config.reviewsExchange)...)
+	if err != nil {
+		return err
+	}
+	defer limiter.Close()
+
+	reader, err := dataset.NewRecordReader(r, format, g.config.reviewsSchema)
+	if err != nil {
+		return err
+	}
 
 	var sentReviews int
 	batch := middleware.Batch[middleware.Review]{
@@ -219,45 +294,60 @@ func (g *gateway) queueReviews(r io.Reader, ch middleware.Channel) error {
 		EOF:     false,
 	}
 
-	_, _ = csvReader.Read()
+	header, err := reader.Header()
+	if err != nil {
+		return err
+	}
+	mapping, err := dataset.NewColumnMapping(header, g.config.reviewsSchema)
+	if err != nil {
+		return fmt.Errorf("invalid reviews header: %w", err)
+	}
 
-	for {
-		record, err := csvReader.Read()
-		if errors.Is(err, &csv.ParseError{}) {
+	parse := func(record []string) (middleware.Review, error) {
+		return dataset.ReviewFromRecord(record, mapping)
+	}
+	reject := func(line int, record []string, err error) {
+		progress.reviewsRejected.Add(1)
+		var malformedErr *dataset.MalformedRecordError
+		if errors.As(err, &malformedErr) {
 			log.Errorf("Failed to parse row: %v", err)
-			continue
+			progress.report.rejectMalformed(REVIEWS_FILE, malformedErr)
+			return
+		}
+		// ignoring known errors to avoid spam
+		if !errors.Is(err, dataset.ErrEmptyReviewText) {
+			log.Errorf("Failed to parse review: %v", err)
 		}
-		if errors.Is(err, io.EOF) {
-			break
+		progress.report.reject(REVIEWS_FILE, line, record, err)
+	}
+	send := func(review middleware.Review) error {
+		batch.Data = append(batch.Data, review)
+		sentReviews += 1
+		progress.reviewsParsed.Add(1)
+
+		if len(batch.Data) < g.config.BatchSize {
+			return nil
 		}
+		err := limiter.Wait(ctx)
 		if err != nil {
 			return err
 		}
-
-		review, err := reviewFromFullRecord(record)
+		err = ch.Send(batch, g.config.reviewsExchange, "")
 		if err != nil {
-			// ignoring known errors to avoid spam
-			if err != emptyReviewTextError {
-				log.Errorf("Failed to parse review: %v", err)
-			}
-			continue
+			return err
 		}
+		batch.Data = batch.Data[:0]
+		batch.BatchID += 1
+		return nil
+	}
 
-		batch.Data = append(batch.Data, review)
-		sentReviews += 1
-
-		if len(batch.Data) == g.config.BatchSize {
-			err = ch.Send(batch, middleware.ExchangeReviews, "")
-			if err != nil {
-				return err
-			}
-			batch.Data = batch.Data[:0]
-			batch.BatchID += 1
-		}
+	err = dataset.ParseRecords(reader, g.config.ParseWorkers, parse, send, reject)
+	if err != nil {
+		return err
 	}
 
 	batch.EOF = true
-	err := ch.Send(batch, middleware.ExchangeReviews, "")
+	err = ch.Send(batch, g.config.reviewsExchange, "")
 	if err != nil {
 		return err
 	}
@@ -266,74 +356,3 @@ func (g *gateway) queueReviews(r io.Reader, ch middleware.Channel) error {
 
 	return nil
 }
-
-var emptyGameNameError = errors.New("game name should not be empty")
-var emptyGameGenresError = errors.New("game genres should not be empty")
-var emptyReviewTextError = errors.New("review text should not be empty")
-
-func gameFromFullRecord(record []string) (game middleware.Game, err error) {
-	if len(record) < 37 {
-		err = fmt.Errorf("expected 37 fields, got %v", len(record))
-		return
-	}
-	appId, err := strconv.Atoi(record[0])
-	if err != nil {
-		return
-	}
-	var releaseDate time.Time
-	releaseDate, err = time.Parse("Jan 2, 2006", record[2])
-	if err != nil {
-		releaseDate, err = time.Parse("Jan 2006", record[2])
-		if err != nil {
-			return
-		}
-	}
-	averagePlaytimeForever, err := strconv.Atoi(record[29])
-	if err != nil {
-		return
-	}
-
-	game.AppID = uint64(appId)
-	game.Name = record[1]
-	if game.Name == "" {
-		err = emptyGameNameError
-		return
-	}
-	game.ReleaseYear = uint16(releaseDate.Year())
-	game.Windows = record[17] == "True"
-	game.Mac = record[18] == "True"
-	game.Linux = record[19] == "True"
-	game.AveragePlaytimeForever = uint64(averagePlaytimeForever)
-	if record[36] == "" {
-		err = emptyGameGenresError
-		return
-	}
-	game.Genres = strings.Split(record[36], ",")
-
-	return
-}
-
-func reviewFromFullRecord(record []string) (review middleware.Review, err error) {
-	if len(record) < 4 {
-		err = fmt.Errorf("expected 4 fields, got %v", len(record))
-		return
-	}
-	appId, err := strconv.Atoi(record[0])
-	if err != nil {
-		return
-	}
-	score, err := strconv.Atoi(record[3])
-	if err != nil {
-		return
-	}
-
-	review.AppID = uint64(appId)
-	review.Text = record[2]
-	if review.Text == "" {
-		err = emptyReviewTextError
-		return
-	}
-	review.Score = middleware.Score(score)
-
-	return
-}
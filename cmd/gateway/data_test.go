@@ -0,0 +1,53 @@
+package main
+
+import (
+	"distribuidos/tp1/protocol"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// Uploads the data through a connection, finishing the stream if asked to,
+// and returns what the parser reads from the pipe
+func receive(t *testing.T, data string, finish bool) (string, error) {
+	client, server := net.Pipe()
+	go func() {
+		conn := protocol.NewConn(client)
+		defer conn.Close()
+		if err := conn.Send(protocol.Batch{Data: []byte(data)}); err != nil {
+			t.Errorf("Failed to send batch: %v", err)
+		}
+		if finish {
+			if err := conn.Send(protocol.Finish{}); err != nil {
+				t.Errorf("Failed to finish: %v", err)
+			}
+		}
+	}()
+
+	g := &gateway{}
+	recv, send := io.Pipe()
+	go func() {
+		_ = g.receiveStream(protocol.NewConn(server), send, newClientProgress(0, 0))
+	}()
+	read, err := io.ReadAll(recv)
+	return string(read), err
+}
+
+func TestReceiveStream(t *testing.T) {
+	read, err := receive(t, "AppID,Name\n1,Game\n", true)
+	if err != nil {
+		t.Fatalf("Failed to read the stream: %v", err)
+	}
+	if read != "AppID,Name\n1,Game\n" {
+		t.Fatalf("Unexpected data %q", read)
+	}
+}
+
+func TestReceiveTruncatedStream(t *testing.T) {
+	// the client disconnects before finishing the stream
+	read, err := receive(t, "AppID,Name\n1,Ga", false)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected the parser to fail, got %v after reading %q", err, read)
+	}
+}
@@ -7,20 +7,30 @@ import (
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
 	"errors"
+	"maps"
+	"slices"
 	"sync"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 type gateway struct {
-	config        config
-	rabbit        *amqp.Connection
-	rabbitCh      *amqp.Channel
-	mu            *sync.Mutex
-	clients       map[int]chan protocol.Result
+	config   config
+	rabbit   *amqp.Connection
+	rabbitCh *amqp.Channel
+	mu       *sync.Mutex
+	clients  map[int]chan protocol.Result
+	progress map[int]*clientProgress
+	requests map[int]protocol.RequestHello
+	jobs     map[int]*jobRecord
+	// ids of the finished jobs still kept, oldest first
+	finishedJobs  []int
+	counterMu     *sync.Mutex
 	clientCounter uint64
 	db            *database.Database
 	outputs       []middleware.Output
+	// holds a token for each admitted client, nil if unlimited
+	admission chan struct{}
 }
 
 func newGateway(config config) *gateway {
@@ -31,13 +41,23 @@ func newGateway(config config) *gateway {
 		utils.Expect(err, "Could not create database")
 	}
 
+	var admission chan struct{}
+	if config.MaxClients > 0 {
+		admission = make(chan struct{}, config.MaxClients)
+	}
+
 	protocol.Register()
 	return &gateway{
-		config:  config,
-		clients: make(map[int]chan protocol.Result),
-		mu:      &sync.Mutex{},
-		db:      db,
-		outputs: []middleware.Output{},
+		config:    config,
+		clients:   make(map[int]chan protocol.Result),
+		progress:  make(map[int]*clientProgress),
+		requests:  make(map[int]protocol.RequestHello),
+		jobs:      make(map[int]*jobRecord),
+		mu:        &sync.Mutex{},
+		counterMu: &sync.Mutex{},
+		db:        db,
+		outputs:   []middleware.Output{},
+		admission: admission,
 	}
 }
 
@@ -97,30 +117,14 @@ func (g *gateway) start(ctx context.Context) error {
 }
 
 func (g *gateway) declareTopology() error {
-	topology := middleware.Topology{
-		Exchanges: []middleware.ExchangeConfig{
-			{Name: middleware.ExchangeGames, Type: amqp.ExchangeFanout},
-			{Name: middleware.ExchangeReviews, Type: amqp.ExchangeFanout},
-		},
-		Queues: []middleware.QueueConfig{
-			{Name: middleware.GamesQ1,
-				Bindings: map[string][]string{middleware.ExchangeGames: {""}}},
-			{Name: middleware.GamesGenre,
-				Bindings: map[string][]string{middleware.ExchangeGames: {""}}},
-			{Name: middleware.ReviewsScore,
-				Bindings: map[string][]string{middleware.ExchangeReviews: {""}}},
-		},
-	}
-
-	outputs := []middleware.Output{
-		{
-			Exchange: middleware.ExchangeGames,
-			Keys:     []string{""},
-		},
-		{
-			Exchange: middleware.ExchangeReviews,
-			Keys:     []string{""},
-		},
+	topology := g.config.stage.Topology()
+
+	outputs := make([]middleware.Output, 0, len(g.config.stage.Exchanges))
+	for _, exchange := range g.config.stage.Exchanges {
+		outputs = append(outputs, middleware.Output{
+			Exchange: exchange.Name,
+			Keys:     slices.Sorted(maps.Keys(exchange.Routes)),
+		})
 	}
 
 	g.outputs = outputs
@@ -137,10 +141,15 @@ func (g *gateway) declareTopology() error {
 // - if cleanAction == CleanId -> the nodes will clean resources for the particular clientID
 func (g *gateway) notifyFallenNode(clientID int, cleanAction int) error {
 	log.Infof("Sending CleanAll for id %v", clientID)
+	if len(g.outputs) == 0 {
+		// nothing was sent downstream
+		return nil
+	}
 	rawCh, err := g.rabbit.Channel()
 	if err != nil {
 		return err
 	}
+	defer rawCh.Close()
 	err = rawCh.Confirm(false)
 	if err != nil {
 		return err
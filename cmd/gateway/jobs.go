@@ -0,0 +1,144 @@
+package main
+
+import (
+	"distribuidos/tp1/protocol"
+	"slices"
+	"sync"
+)
+
+// Keeps the results of a request, so that they can be fetched after the
+// client disconnects. Jobs are only kept in memory
+type jobRecord struct {
+	mu       *sync.Mutex
+	state    protocol.JobState
+	progress *clientProgress
+	results  []protocol.Result
+}
+
+func newJobRecord(progress *clientProgress) *jobRecord {
+	return &jobRecord{
+		mu:       &sync.Mutex{},
+		state:    protocol.JobRunning,
+		progress: progress,
+		results:  make([]protocol.Result, 0),
+	}
+}
+
+func (j *jobRecord) addResult(result protocol.Result) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.results = append(j.results, result)
+}
+
+func (j *jobRecord) setState(state protocol.JobState) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+}
+
+func (j *jobRecord) getState() protocol.JobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+func (j *jobRecord) getResults() []protocol.Result {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Clone(j.results)
+}
+
+func (j *jobRecord) toStatus(clientID int) protocol.JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	queries := make([]uint8, 0)
+	for _, result := range j.results {
+		// partial results of Q4 are followed by a Q4Finish
+		if _, partial := result.(protocol.Q4Result); !partial {
+			queries = append(queries, uint8(result.Number()))
+		}
+	}
+	slices.Sort(queries)
+
+	return protocol.JobStatus{
+		ClientID:        uint64(clientID),
+		State:           j.state,
+		FinishedQueries: queries,
+		Progress:        j.progress.toMessage(),
+	}
+}
+
+func (g *gateway) getJob(clientID int) (*jobRecord, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	job, ok := g.jobs[clientID]
+	return job, ok
+}
+
+// Marks the job as no longer running. Only the latest finished jobs are
+// kept, as configured by RetainedJobs
+func (g *gateway) finishJob(clientID int, state protocol.JobState) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	job, ok := g.jobs[clientID]
+	if !ok || job.getState() != protocol.JobRunning {
+		return
+	}
+	job.setState(state)
+
+	g.finishedJobs = append(g.finishedJobs, clientID)
+	for len(g.finishedJobs) > g.config.RetainedJobs {
+		delete(g.jobs, g.finishedJobs[0])
+		g.finishedJobs = g.finishedJobs[1:]
+	}
+}
+
+func (g *gateway) handleStatusRequest(conn *protocol.Conn, request protocol.StatusRequest) error {
+	clientID := int(request.ClientID)
+
+	job, ok := g.getJob(clientID)
+	if !ok {
+		return conn.Send(protocol.JobStatus{
+			ClientID:        request.ClientID,
+			State:           protocol.JobUnknown,
+			FinishedQueries: []uint8{},
+			Progress:        protocol.Progress{FinishedStages: []string{}},
+		})
+	}
+
+	return conn.Send(job.toStatus(clientID))
+}
+
+// Sends the results of a finished job, as they were sent to the client
+func (g *gateway) handleFetchRequest(conn *protocol.Conn, request protocol.FetchRequest) error {
+	clientID := int(request.ClientID)
+
+	job, ok := g.getJob(clientID)
+	if !ok {
+		return conn.Send(protocol.Reject{Reason: "unknown request"})
+	}
+	switch job.getState() {
+	case protocol.JobRunning:
+		return conn.Send(protocol.Reject{Reason: "request has not finished yet"})
+	case protocol.JobFailed:
+		return conn.Send(protocol.Reject{Reason: "request failed"})
+	}
+
+	log.Infof("Sending stored results of client %v", clientID)
+
+	for _, result := range job.getResults() {
+		err := conn.SendAny(result)
+		if err != nil {
+			return err
+		}
+	}
+	err := conn.Send(job.progress.report.toMessage())
+	if err != nil {
+		return err
+	}
+
+	var finish protocol.Finish
+	return conn.Recv(&finish)
+}
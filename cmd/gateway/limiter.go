@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Minimum time between queue depth checks while the pipeline is not saturated
+const DEPTH_CHECK_INTERVAL = 500 * time.Millisecond
+
+// Maximum time to wait between depth checks while the pipeline is saturated
+const MAX_DEPTH_BACKOFF = 2 * time.Second
+
+// Inspects the queues fed by the gateway, implemented by *amqp.Channel
+type queueInspector interface {
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Close() error
+}
+
+// Limits the rate at which a single client publishes batches. It is shared
+// by all of the client's streams, so that parallel uploads don't multiply it
+type batchRate struct {
+	mu       *sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Returns a limit of the given batches per second, 0 means unlimited
+func newBatchRate(maxBatchRate int) *batchRate {
+	var interval time.Duration
+	if maxBatchRate > 0 {
+		interval = time.Second / time.Duration(maxBatchRate)
+	}
+	return &batchRate{mu: &sync.Mutex{}, interval: interval}
+}
+
+// Blocks until the client is allowed to publish another batch
+func (r *batchRate) Wait(ctx context.Context) error {
+	// reserves the next slot, so that concurrent callers take turns
+	r.mu.Lock()
+	slot := time.Now()
+	if r.next.After(slot) {
+		slot = r.next
+	}
+	r.next = slot.Add(r.interval)
+	r.mu.Unlock()
+
+	return sleep(ctx, time.Until(slot))
+}
+
+// Limits the rate at which a client's stream publishes batches, and pauses
+// publishing while any of the queues fed by the gateway is too deep
+type ingestLimiter struct {
+	ch        queueInspector
+	queues    []string
+	maxDepth  int
+	rate      *batchRate
+	lastCheck time.Time
+}
+
+func (g *gateway) newIngestLimiter(rate *batchRate, queues ...string) (*ingestLimiter, error) {
+	// queue inspection has its own channel, as a failed passive declare closes it
+	ch, err := g.rabbit.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ingestLimiter{
+		ch:       ch,
+		queues:   queues,
+		maxDepth: g.config.MaxQueueDepth,
+		rate:     rate,
+	}, nil
+}
+
+// Blocks until the client is allowed to publish another batch
+func (l *ingestLimiter) Wait(ctx context.Context) error {
+	err := l.rate.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	backoff := 50 * time.Millisecond
+	for l.maxDepth > 0 && time.Since(l.lastCheck) >= DEPTH_CHECK_INTERVAL {
+		depth, err := l.depth()
+		if err != nil {
+			return err
+		}
+		if depth <= l.maxDepth {
+			l.lastCheck = time.Now()
+			break
+		}
+
+		log.Debugf("Output queues have %v messages, pausing for %v", depth, backoff)
+		err = sleep(ctx, backoff)
+		if err != nil {
+			return err
+		}
+		backoff = min(backoff*2, MAX_DEPTH_BACKOFF)
+	}
+
+	return nil
+}
+
+// Returns the amount of ready messages in the deepest queue
+func (l *ingestLimiter) depth() (int, error) {
+	var depth int
+	for _, queue := range l.queues {
+		q, err := l.ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+		if err != nil {
+			return 0, err
+		}
+		depth = max(depth, q.Messages)
+	}
+	return depth, nil
+}
+
+func (l *ingestLimiter) Close() error {
+	return l.ch.Close()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Reports the given depths in order, repeating the last one
+type fakeInspector struct {
+	depths []int
+	err    error
+	checks int
+}
+
+func (f *fakeInspector) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if f.err != nil {
+		return amqp.Queue{}, f.err
+	}
+	depth := f.depths[min(f.checks, len(f.depths)-1)]
+	f.checks += 1
+	return amqp.Queue{Name: name, Messages: depth}, nil
+}
+
+func (f *fakeInspector) Close() error { return nil }
+
+func TestIngestLimiterRate(t *testing.T) {
+	l := &ingestLimiter{ch: &fakeInspector{}, rate: newBatchRate(50)}
+
+	start := time.Now()
+	for range 5 {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Failed to wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("Expected 5 batches to take at least 80ms, took %v", elapsed)
+	}
+}
+
+func TestIngestLimiterSharedRate(t *testing.T) {
+	rate := newBatchRate(50)
+	games := &ingestLimiter{ch: &fakeInspector{}, rate: rate}
+	reviews := &ingestLimiter{ch: &fakeInspector{}, rate: rate}
+
+	// both streams publish in parallel, but share the client's rate
+	start := time.Now()
+	errs := make(chan error, 2)
+	for _, l := range []*ingestLimiter{games, reviews} {
+		go func() {
+			for range 3 {
+				if err := l.Wait(context.Background()); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("Failed to wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Expected 6 batches to take at least 100ms, took %v", elapsed)
+	}
+}
+
+func TestIngestLimiterDepthBackoff(t *testing.T) {
+	inspector := &fakeInspector{depths: []int{100, 100, 100, 5}}
+	l := &ingestLimiter{ch: inspector, queues: []string{"games"}, maxDepth: 10, rate: newBatchRate(0)}
+
+	// pauses for 50ms, 100ms and 200ms until the queue drains
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Failed to wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Fatalf("Expected to back off for at least 350ms, took %v", elapsed)
+	}
+	if inspector.checks != 4 {
+		t.Fatalf("Expected 4 depth checks, got %v", inspector.checks)
+	}
+
+	// the depth is not checked again until the check interval passes
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Failed to wait: %v", err)
+	}
+	if inspector.checks != 4 {
+		t.Fatalf("Expected no more depth checks, got %v", inspector.checks-4)
+	}
+}
+
+func TestIngestLimiterDeepestQueue(t *testing.T) {
+	// the first queue is shallow, but the second one is too deep
+	inspector := &fakeInspector{depths: []int{0, 100, 0, 0}}
+	l := &ingestLimiter{ch: inspector, queues: []string{"games", "reviews"}, maxDepth: 10, rate: newBatchRate(0)}
+
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Failed to wait: %v", err)
+	}
+	if inspector.checks != 4 {
+		t.Fatalf("Expected both queues to be checked twice, got %v checks", inspector.checks)
+	}
+}
+
+func TestIngestLimiterCancel(t *testing.T) {
+	l := &ingestLimiter{ch: &fakeInspector{depths: []int{100}}, queues: []string{"games"}, maxDepth: 10, rate: newBatchRate(0)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the wait to be cancelled, got %v", err)
+	}
+}
+
+func TestIngestLimiterInspectError(t *testing.T) {
+	inspectErr := errors.New("queue not found")
+	l := &ingestLimiter{ch: &fakeInspector{err: inspectErr}, queues: []string{"games"}, maxDepth: 10, rate: newBatchRate(0)}
+
+	if err := l.Wait(context.Background()); !errors.Is(err, inspectErr) {
+		t.Fatalf("Expected the inspection error, got %v", err)
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"distribuidos/tp1/dataset"
+	"distribuidos/tp1/pipeline"
+	"errors"
+	"fmt"
 	"os/signal"
 	"syscall"
+	"time"
 
 	logging "github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -16,6 +21,38 @@ type config struct {
 	DataEndpointPort       int
 	RabbitIP               string
 	BatchSize              int
+	// Goroutines parsing the records of each file
+	ParseWorkers int
+	// Maximum number of clients being processed at the same time, 0 means unlimited
+	MaxClients int
+	// Time a client waits for a free slot before being told to retry later
+	AdmissionTimeout time.Duration
+	RetryAfter       time.Duration
+	// Maximum batches per second published by each client, 0 means unlimited
+	MaxBatchRate int
+	// Publishing is paused while any output queue has more messages than this, 0 means unlimited
+	MaxQueueDepth int
+	// Time between progress messages sent to the client
+	ProgressInterval time.Duration
+	// Maximum rejected rows reported to each client
+	RejectedSamples int
+	// Finished requests whose results are kept, so that they can be fetched
+	RetainedJobs int
+	// Overrides of the column names, see dataset.ParseSchema
+	GamesColumns   string
+	ReviewsColumns string
+	// Stage run by the gateway, and file describing the pipeline
+	Stage    string
+	Pipeline string
+
+	gamesSchema   dataset.Schema
+	reviewsSchema dataset.Schema
+	stage         pipeline.Stage
+	// exchanges and queues of the stage
+	gamesExchange   string
+	reviewsExchange string
+	results         string
+	resultsQ4       string
 }
 
 func getConfig() (config, error) {
@@ -25,15 +62,64 @@ func getConfig() (config, error) {
 	v.SetDefault("DataEndpointPort", "9002")
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("BatchSize", "100")
+	v.SetDefault("ParseWorkers", "4")
+	v.SetDefault("MaxClients", "5")
+	v.SetDefault("AdmissionTimeout", "10s")
+	v.SetDefault("RetryAfter", "5s")
+	v.SetDefault("MaxBatchRate", "0")
+	v.SetDefault("MaxQueueDepth", "10000")
+	v.SetDefault("ProgressInterval", "2s")
+	v.SetDefault("RejectedSamples", "100")
+	v.SetDefault("RetainedJobs", "10")
+	v.SetDefault("Stage", "gateway")
 
 	_ = v.BindEnv("ConnectionEndpointPort", "CONN_PORT")
 	_ = v.BindEnv("DataEndpointPort", "DATA_PORT")
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("BatchSize", "BATCH_SIZE")
+	_ = v.BindEnv("ParseWorkers", "PARSE_WORKERS")
+	_ = v.BindEnv("MaxClients", "MAX_CLIENTS")
+	_ = v.BindEnv("AdmissionTimeout", "ADMISSION_TIMEOUT")
+	_ = v.BindEnv("RetryAfter", "RETRY_AFTER")
+	_ = v.BindEnv("MaxBatchRate", "MAX_BATCH_RATE")
+	_ = v.BindEnv("MaxQueueDepth", "MAX_QUEUE_DEPTH")
+	_ = v.BindEnv("ProgressInterval", "PROGRESS_INTERVAL")
+	_ = v.BindEnv("RejectedSamples", "REJECTED_SAMPLES")
+	_ = v.BindEnv("RetainedJobs", "RETAINED_JOBS")
+	_ = v.BindEnv("GamesColumns", "GAMES_COLUMNS")
+	_ = v.BindEnv("ReviewsColumns", "REVIEWS_COLUMNS")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
-	return c, err
+	if err != nil {
+		return c, err
+	}
+
+	c.gamesSchema, err = dataset.ParseSchema(c.GamesColumns, dataset.DefaultGamesSchema)
+	if err != nil {
+		return c, fmt.Errorf("invalid games columns: %w", err)
+	}
+	c.reviewsSchema, err = dataset.ParseSchema(c.ReviewsColumns, dataset.DefaultReviewsSchema)
+	if err != nil {
+		return c, fmt.Errorf("invalid reviews columns: %w", err)
+	}
+	_, c.stage, err = pipeline.LoadStage(c.Pipeline, c.Stage)
+	if err != nil {
+		return c, fmt.Errorf("invalid pipeline: %w", err)
+	}
+	var gamesErr, reviewsErr, resultsErr, resultsQ4Err error
+	c.gamesExchange, gamesErr = c.stage.Output("games")
+	c.reviewsExchange, reviewsErr = c.stage.Output("reviews")
+	c.results, resultsErr = c.stage.Input("results")
+	c.resultsQ4, resultsQ4Err = c.stage.Input("results-q4")
+	err = errors.Join(gamesErr, reviewsErr, resultsErr, resultsQ4Err)
+	if err != nil {
+		return c, fmt.Errorf("invalid pipeline: %w", err)
+	}
+
+	return c, nil
 }
 
 func main() {
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/protocol"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Tracks the progress of a single client's request. Counters are updated
+// concurrently by the data handler, and stages by the results handler
+type clientProgress struct {
+	bytes           atomic.Uint64
+	batches         atomic.Uint64
+	gamesParsed     atomic.Uint64
+	gamesRejected   atomic.Uint64
+	reviewsParsed   atomic.Uint64
+	reviewsRejected atomic.Uint64
+
+	mu              *sync.Mutex
+	finishedStages  map[string]bool
+	finishedStreams map[protocol.DataStream]bool
+
+	report *rejectionReport
+	// shared by all of the client's data streams
+	rate *batchRate
+	// closed when all of the client's data has been ingested
+	ingested   chan struct{}
+	ingestOnce *sync.Once
+	// closed if the client falls before receiving all of its results
+	failed chan struct{}
+}
+
+func newClientProgress(maxSamples int, maxBatchRate int) *clientProgress {
+	return &clientProgress{
+		mu:              &sync.Mutex{},
+		finishedStages:  make(map[string]bool),
+		finishedStreams: make(map[protocol.DataStream]bool),
+		report:          newRejectionReport(maxSamples),
+		rate:            newBatchRate(maxBatchRate),
+		ingested:        make(chan struct{}),
+		ingestOnce:      &sync.Once{},
+		failed:          make(chan struct{}),
+	}
+}
+
+func (p *clientProgress) finishIngest() {
+	p.ingestOnce.Do(func() { close(p.ingested) })
+}
+
+// Marks a stream as fully queued. Once both games and reviews are, the
+// ingest is finished
+func (p *clientProgress) finishStream(stream protocol.DataStream) {
+	p.mu.Lock()
+	p.finishedStreams[stream] = true
+	done := p.finishedStreams[protocol.StreamGames] && p.finishedStreams[protocol.StreamReviews]
+	p.mu.Unlock()
+
+	if done {
+		p.finishIngest()
+	}
+}
+
+func (p *clientProgress) finishStage(queue string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finishedStages[queue] = true
+}
+
+func (p *clientProgress) toMessage() protocol.Progress {
+	p.mu.Lock()
+	stages := make([]string, 0, len(p.finishedStages))
+	for stage := range p.finishedStages {
+		stages = append(stages, stage)
+	}
+	p.mu.Unlock()
+	slices.Sort(stages)
+
+	return protocol.Progress{
+		BytesReceived:   p.bytes.Load(),
+		BatchesReceived: p.batches.Load(),
+		GamesParsed:     p.gamesParsed.Load(),
+		GamesRejected:   p.gamesRejected.Load(),
+		ReviewsParsed:   p.reviewsParsed.Load(),
+		ReviewsRejected: p.reviewsRejected.Load(),
+		FinishedStages:  stages,
+	}
+}
+
+// Returns the progress of the given client, or a detached one if it is
+// unknown, so that callers don't need to check for its existence
+func (g *gateway) getProgress(clientID int) *clientProgress {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.progress[clientID]
+	if !ok {
+		return newClientProgress(g.config.RejectedSamples, g.config.MaxBatchRate)
+	}
+	return p
+}
+
+func (h *resultsHandler) handleStatus(ch *middleware.Channel, data []byte) error {
+	status, err := middleware.Deserialize[middleware.Status](data)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Client %v finished stage %v", ch.ClientID, status.Queue)
+	h.progress.finishStage(status.Queue)
+
+	return nil
+}
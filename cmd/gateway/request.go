@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"distribuidos/tp1/dataset"
 	"distribuidos/tp1/middleware"
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
@@ -9,8 +10,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"sync"
+	"time"
 )
 
 func (g *gateway) updateClientCounter(clientCounter uint64) error {
@@ -99,26 +102,21 @@ func (g *gateway) startRequestEndpoint(ctx context.Context) (err error) {
 		if err != nil {
 			return fmt.Errorf("Failed to accept connection: %v", err)
 		}
-		g.clientCounter += 1
-		err = g.updateClientCounter(g.clientCounter)
-		if err != nil {
-			return fmt.Errorf("Failed to update client counter: %v", err)
-		}
 
 		wg.Add(1)
-		clientID := g.clientCounter
-		go func(clientID uint64) {
+		go func() {
 			defer wg.Done()
-			err := g.handleClient(ctx, conn, int(clientID))
+			err := g.handleConnection(ctx, conn)
 			if err != nil {
 				log.Errorf("Error while handling client: %v", err)
 			}
-		}(clientID)
+		}()
 	}
 }
 
-func (g *gateway) handleClient(ctx context.Context, netConn net.Conn, clientID int) error {
-	var err error
+// Handles a connection according to its first message. Either a new
+// request, or a query about a previous one
+func (g *gateway) handleConnection(ctx context.Context, netConn net.Conn) (err error) {
 	conn := protocol.NewConn(netConn)
 
 	closer := utils.SpawnCloser(ctx, conn)
@@ -127,65 +125,212 @@ func (g *gateway) handleClient(ctx context.Context, netConn net.Conn, clientID i
 		err = errors.Join(err, closeErr)
 	}()
 
-	var hello protocol.RequestHello
-	err = conn.Recv(&hello)
+	var anyMsg any
+	err = conn.Recv(&anyMsg)
 	if err != nil {
 		return err
 	}
 
+	switch msg := anyMsg.(type) {
+	case protocol.RequestHello:
+		clientID, err := g.nextClientID()
+		if err != nil {
+			return fmt.Errorf("Failed to update client counter: %v", err)
+		}
+		return g.handleClient(ctx, conn, clientID, msg)
+	case protocol.StatusRequest:
+		return g.handleStatusRequest(conn, msg)
+	case protocol.FetchRequest:
+		return g.handleFetchRequest(conn, msg)
+	default:
+		return fmt.Errorf("unexpected message from client: %T", msg)
+	}
+}
+
+func (g *gateway) nextClientID() (int, error) {
+	g.counterMu.Lock()
+	defer g.counterMu.Unlock()
+
+	g.clientCounter += 1
+	err := g.updateClientCounter(g.clientCounter)
+	if err != nil {
+		return 0, err
+	}
+	return int(g.clientCounter), nil
+}
+
+func (g *gateway) handleClient(ctx context.Context, conn *protocol.Conn, clientID int, hello protocol.RequestHello) error {
 	log.Infof("Received client hello: %v", clientID)
 
+	version, err := protocol.NegotiateVersion(hello.Version)
+	if err == nil {
+		err = errors.Join(dataset.ValidateFormat(hello.GamesFormat), dataset.ValidateFormat(hello.ReviewsFormat))
+	}
+	if err != nil {
+		sendErr := conn.Send(protocol.Reject{Reason: err.Error()})
+		return errors.Join(err, sendErr)
+	}
+
+	if !g.admitClient(ctx) {
+		log.Warningf("Rejecting client %v, too many clients being processed", clientID)
+		return conn.Send(protocol.Busy{
+			RetryAfter: retryAfterSeconds(g.config.RetryAfter),
+		})
+	}
+	defer g.releaseClient()
+
 	ch := make(chan protocol.Result)
 
+	progress := newClientProgress(g.config.RejectedSamples, g.config.MaxBatchRate)
+	job := newJobRecord(progress)
+
 	g.mu.Lock()
 	g.clients[clientID] = ch
+	g.progress[clientID] = progress
+	g.requests[clientID] = hello
+	g.jobs[clientID] = job
 	g.mu.Unlock()
 
 	err = conn.Send(protocol.AcceptRequest{
 		ClientID: uint64(clientID),
+		Version:  version,
 	})
 	if err != nil {
 		return err
 	}
 
+	// closed if the client stops listening for results before they are
+	// complete, they are kept so that it can fetch them later
+	detached := make(chan struct{})
+
 	wg := &sync.WaitGroup{}
 	defer wg.Wait()
 
 	wg.Add(1)
 	go func(clientID int) {
 		defer wg.Done()
-		err = g.detectFallenClient(conn, clientID)
+		err := g.detectFallenClient(conn, clientID, detached, progress.failed)
 		if err != nil {
 			log.Errorf("Failed monitoring client %v", err)
 		}
 	}(clientID)
 
+	// results are only sent while the client is attached
+	send := func(msg any) {
+		if detached != nil {
+			err := conn.SendAny(msg)
+			if err != nil {
+				log.Infof("Failed to send %T to client", msg)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(g.config.ProgressInterval)
+	defer ticker.Stop()
+
+	// set to nil after sending the report, so that it is sent only once.
+	// Clients that predate it never receive it
+	ingested := progress.ingested
+	if version < protocol.ValidationVersion {
+		ingested = nil
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-detached:
+			log.Infof("Client %v detached, keeping its results", clientID)
+			detached = nil
+		case <-progress.failed:
+			// its request was aborted, so no more results will arrive
+			log.Infof("Client %v fell, releasing its slot", clientID)
+			g.forgetClient(clientID)
+			return nil
+		case <-ticker.C:
+			if version >= protocol.ProgressVersion {
+				send(progress.toMessage())
+			}
+		case <-ingested:
+			ingested = nil
+			send(progress.report.toMessage())
 		case result, more := <-ch:
 			if !more {
+				if ingested != nil {
+					// results can't be complete without all data, but the
+					// report may not have been sent yet
+					select {
+					case <-ingested:
+					case <-ctx.Done():
+						return nil
+					}
+					send(progress.report.toMessage())
+				}
 				log.Infof("Sent all results to client %v, closing connection", clientID)
-				g.mu.Lock()
-				delete(g.clients, clientID)
-				g.mu.Unlock()
+				g.finishJob(clientID, protocol.JobFinished)
+				g.forgetClient(clientID)
 				return nil
 			}
-			err := conn.SendAny(result)
-			if err != nil {
-				log.Infof("Failed to send result to client")
-			}
+			job.addResult(result)
+			send(result)
 		}
 	}
 }
 
-// waits until the client finishes receiving all results to stop monitoring it
-func (g *gateway) detectFallenClient(conn *protocol.Conn, clientID int) error {
+// forgets the client once its request is no longer processed, keeping only
+// its job
+func (g *gateway) forgetClient(clientID int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.clients, clientID)
+	delete(g.progress, clientID)
+	delete(g.requests, clientID)
+}
+
+// waits for a free client slot, for at most the configured admission timeout
+func (g *gateway) admitClient(ctx context.Context) bool {
+	if g.admission == nil {
+		return true
+	}
+
+	select {
+	case g.admission <- struct{}{}:
+		return true
+	default:
+	}
+
+	log.Infof("Gateway is full, queueing client")
+	select {
+	case g.admission <- struct{}{}:
+		return true
+	case <-time.After(g.config.AdmissionTimeout):
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (g *gateway) releaseClient() {
+	if g.admission != nil {
+		<-g.admission
+	}
+}
+
+// rounded up, so that busy clients never retry earlier than configured
+func retryAfterSeconds(d time.Duration) uint32 {
+	return uint32(math.Ceil(d.Seconds()))
+}
+
+// waits until the client finishes receiving all results to stop monitoring
+// it. The client may also finish early, to detach from the request
+func (g *gateway) detectFallenClient(conn *protocol.Conn, clientID int, detached chan struct{}, failed chan struct{}) error {
 	var finish protocol.Finish
 	err := conn.Recv(&finish)
 	if err != nil {
+		g.finishJob(clientID, protocol.JobFailed)
+		close(failed)
 		return g.notifyFallenNode(clientID, middleware.CleanId)
 	}
+	close(detached)
 	return nil
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"distribuidos/tp1/protocol"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newAdmissionGateway(maxClients int, timeout time.Duration) *gateway {
+	return &gateway{
+		config:    config{MaxClients: maxClients, AdmissionTimeout: timeout},
+		admission: make(chan struct{}, maxClients),
+	}
+}
+
+func TestAdmitClientUnlimited(t *testing.T) {
+	g := &gateway{}
+	for range 10 {
+		if !g.admitClient(context.Background()) {
+			t.Fatalf("Expected every client to be admitted")
+		}
+	}
+	g.releaseClient()
+}
+
+func TestAdmitClientQueues(t *testing.T) {
+	g := newAdmissionGateway(1, time.Second)
+	if !g.admitClient(context.Background()) {
+		t.Fatalf("Expected the first client to be admitted")
+	}
+
+	admitted := make(chan bool)
+	go func() { admitted <- g.admitClient(context.Background()) }()
+
+	// the second client waits for the first one to finish
+	select {
+	case <-admitted:
+		t.Fatalf("Expected the second client to be queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+	g.releaseClient()
+	if !<-admitted {
+		t.Fatalf("Expected the second client to be admitted once the first one finished")
+	}
+}
+
+func TestAdmitClientBusy(t *testing.T) {
+	g := newAdmissionGateway(1, 50*time.Millisecond)
+	if !g.admitClient(context.Background()) {
+		t.Fatalf("Expected the first client to be admitted")
+	}
+
+	start := time.Now()
+	if g.admitClient(context.Background()) {
+		t.Fatalf("Expected the second client to be rejected")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Expected the client to wait for the admission timeout, waited %v", elapsed)
+	}
+
+	// rejected clients don't hold a slot
+	g.releaseClient()
+	if !g.admitClient(context.Background()) {
+		t.Fatalf("Expected a client to be admitted after the slot is released")
+	}
+}
+
+func TestAdmitClientCancel(t *testing.T) {
+	g := newAdmissionGateway(1, time.Minute)
+	if !g.admitClient(context.Background()) {
+		t.Fatalf("Expected the first client to be admitted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if g.admitClient(ctx) {
+		t.Fatalf("Expected the client not to be admitted after the gateway stops")
+	}
+}
+
+// Gateway that handles clients without a pipeline behind it
+func newClientGateway(maxClients int) *gateway {
+	return &gateway{
+		config: config{
+			MaxClients:       maxClients,
+			AdmissionTimeout: 50 * time.Millisecond,
+			ProgressInterval: time.Minute,
+			RetainedJobs:     10,
+		},
+		clients:   make(map[int]chan protocol.Result),
+		progress:  make(map[int]*clientProgress),
+		requests:  make(map[int]protocol.RequestHello),
+		jobs:      make(map[int]*jobRecord),
+		mu:        &sync.Mutex{},
+		admission: make(chan struct{}, maxClients),
+	}
+}
+
+// Starts handling a client, returning the first message it receives
+func connectClient(t *testing.T, g *gateway, clientID int) (net.Conn, any, chan error) {
+	client, server := net.Pipe()
+	handled := make(chan error, 1)
+	go func() {
+		hello := protocol.RequestHello{Version: protocol.ProtocolVersion}
+		handled <- g.handleClient(context.Background(), protocol.NewConn(server), clientID, hello)
+	}()
+
+	var msg any
+	if err := protocol.NewConn(client).Recv(&msg); err != nil {
+		t.Fatalf("Failed to receive answer: %v", err)
+	}
+	return client, msg, handled
+}
+
+func TestDroppedClientsReleaseSlots(t *testing.T) {
+	g := newClientGateway(2)
+
+	// clients fall before receiving their results
+	for id := range 3 {
+		client, msg, handled := connectClient(t, g, id)
+		if _, ok := msg.(protocol.AcceptRequest); !ok {
+			t.Fatalf("Expected client %v to be accepted, got %T", id, msg)
+		}
+		client.Close()
+		select {
+		case err := <-handled:
+			if err != nil {
+				t.Fatalf("Failed handling client %v: %v", id, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the handler of client %v to return once it fell", id)
+		}
+
+		job, ok := g.getJob(id)
+		if !ok || job.getState() != protocol.JobFailed {
+			t.Fatalf("Expected the job of client %v to fail", id)
+		}
+	}
+
+	client, msg, _ := connectClient(t, g, 3)
+	defer client.Close()
+	if _, ok := msg.(protocol.AcceptRequest); !ok {
+		t.Fatalf("Expected a new client to be admitted, got %T", msg)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	cases := map[time.Duration]uint32{
+		0:                       0,
+		100 * time.Millisecond:  1,
+		1500 * time.Millisecond: 2,
+		5 * time.Second:         5,
+	}
+	for retryAfter, expected := range cases {
+		if seconds := retryAfterSeconds(retryAfter); seconds != expected {
+			t.Fatalf("Expected %v to be rounded up to %vs, got %vs", retryAfter, expected, seconds)
+		}
+	}
+}
@@ -13,6 +13,7 @@ type resultsHandler struct {
 	ch        chan protocol.Result
 	results   map[int]bool
 	sequencer *middleware.Sequencer
+	progress  *clientProgress
 }
 
 func (h *resultsHandler) handle(ch *middleware.Channel, data []byte) error {
@@ -26,7 +27,9 @@ func (h *resultsHandler) handle(ch *middleware.Channel, data []byte) error {
 
 	log.Infof("Sending Q%v results", result.Number())
 
-	h.ch <- result
+	if !h.forward(result) {
+		return nil
+	}
 	h.results[result.Number()] = true
 
 	if len(h.results) == MAX_RESULTS {
@@ -51,13 +54,16 @@ func (h *resultsHandler) handleQ4(ch *middleware.Channel, data []byte) error {
 
 	if len(batch.Data) > 0 {
 		log.Infof("Sending Q4 results")
-		r := protocol.Q4Result{Games: batch.Data}
-		h.ch <- r
+		if !h.forward(protocol.Q4Result{Games: batch.Data}) {
+			return nil
+		}
 	}
 
 	if h.sequencer.EOF() {
 		r := protocol.Q4Finish{}
-		h.ch <- r
+		if !h.forward(r) {
+			return nil
+		}
 		h.results[r.Number()] = true
 	}
 
@@ -69,6 +75,17 @@ func (h *resultsHandler) handleQ4(ch *middleware.Channel, data []byte) error {
 	return nil
 }
 
+// Hands the result to the client handler, unless the client fell, in which
+// case its results are discarded until its request is cleaned
+func (h *resultsHandler) forward(result protocol.Result) bool {
+	select {
+	case h.ch <- result:
+		return true
+	case <-h.progress.failed:
+		return false
+	}
+}
+
 func (h *resultsHandler) Free() error {
 	return nil
 }
@@ -77,6 +94,7 @@ func (g *gateway) startResultsEndpoint(ctx context.Context) error {
 	newResultsHandler := func(clientID int) (*resultsHandler, error) {
 		g.mu.Lock()
 		chanResults, exists := g.clients[clientID]
+		progress := g.progress[clientID]
 		g.mu.Unlock()
 		if !exists {
 			return nil, errors.New("client does not exist")
@@ -86,11 +104,16 @@ func (g *gateway) startResultsEndpoint(ctx context.Context) error {
 			ch:        chanResults,
 			results:   make(map[int]bool),
 			sequencer: middleware.NewSequencer(),
+			progress:  progress,
 		}, nil
 	}
 
 	topology := middleware.Topology{
-		Queues: []middleware.QueueConfig{{Name: middleware.Results}, {Name: middleware.ResultsQ4}},
+		Queues: []middleware.QueueConfig{
+			{Name: g.config.results},
+			{Name: g.config.resultsQ4},
+			{Name: middleware.StatusQueue},
+		},
 	}
 	err := topology.Declare(g.rabbitCh)
 	if err != nil {
@@ -100,8 +123,9 @@ func (g *gateway) startResultsEndpoint(ctx context.Context) error {
 	cfg := middleware.Config[*resultsHandler]{
 		Builder: newResultsHandler,
 		Endpoints: map[string]middleware.HandlerFunc[*resultsHandler]{
-			middleware.Results:   (*resultsHandler).handle,
-			middleware.ResultsQ4: (*resultsHandler).handleQ4,
+			g.config.results:       (*resultsHandler).handle,
+			g.config.resultsQ4:     (*resultsHandler).handleQ4,
+			middleware.StatusQueue: (*resultsHandler).handleStatus,
 		},
 	}
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"distribuidos/tp1/dataset"
+	"distribuidos/tp1/protocol"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const GAMES_FILE = "games"
+const REVIEWS_FILE = "reviews"
+
+// Rejected records are truncated to this length in the report samples
+const MAX_SAMPLE_RECORD_SIZE = 512
+
+var malformedRowError = errors.New("malformed row")
+
+// Known rejection reasons, used to aggregate rejections by cause
+var rejectionReasons = []error{
+	dataset.ErrFieldCount,
+	dataset.ErrInvalidAppID,
+	dataset.ErrInvalidReleaseDate,
+	dataset.ErrInvalidPlaytime,
+	dataset.ErrInvalidScore,
+	malformedRowError,
+	dataset.ErrEmptyGameName,
+	dataset.ErrEmptyGameGenres,
+	dataset.ErrEmptyReviewText,
+}
+
+type rejectionKey struct {
+	file   string
+	reason string
+}
+
+// Collects the rows discarded while ingesting a client's data
+type rejectionReport struct {
+	mu         *sync.Mutex
+	maxSamples int
+	counts     map[rejectionKey]uint64
+	samples    []protocol.RejectedRow
+}
+
+func newRejectionReport(maxSamples int) *rejectionReport {
+	return &rejectionReport{
+		mu:         &sync.Mutex{},
+		maxSamples: maxSamples,
+		counts:     make(map[rejectionKey]uint64),
+		samples:    make([]protocol.RejectedRow, 0),
+	}
+}
+
+func (r *rejectionReport) reject(file string, line int, record []string, err error) {
+	reason := rejectionReason(err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counts[rejectionKey{file: file, reason: reason}] += 1
+	if len(r.samples) < r.maxSamples {
+		r.samples = append(r.samples, protocol.RejectedRow{
+			File:   file,
+			Line:   uint64(line),
+			Reason: err.Error(),
+			Record: truncate(formatRecord(record), MAX_SAMPLE_RECORD_SIZE),
+		})
+	}
+}
+
+// Records a row that the record reader failed to parse
+func (r *rejectionReport) rejectMalformed(file string, err *dataset.MalformedRecordError) {
+	r.reject(file, err.Line, nil, fmt.Errorf("%w: %w", malformedRowError, err.Err))
+}
+
+func (r *rejectionReport) toMessage() protocol.ValidationReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rejections := make([]protocol.RejectionCount, 0, len(r.counts))
+	for key, count := range r.counts {
+		rejections = append(rejections, protocol.RejectionCount{
+			File:   key.file,
+			Reason: key.reason,
+			Count:  count,
+		})
+	}
+	slices.SortFunc(rejections, func(a, b protocol.RejectionCount) int {
+		return cmp.Or(cmp.Compare(a.File, b.File), cmp.Compare(a.Reason, b.Reason))
+	})
+
+	return protocol.ValidationReport{
+		Rejections: rejections,
+		Samples:    slices.Clone(r.samples),
+	}
+}
+
+func rejectionReason(err error) string {
+	for _, reason := range rejectionReasons {
+		if errors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+	return "other"
+}
+
+func formatRecord(record []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	_ = w.Write(record)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
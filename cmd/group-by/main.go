@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"os/signal"
 	"slices"
@@ -20,10 +21,10 @@ var log = logging.MustGetLogger("log")
 type config struct {
 	RabbitIP    string
 	PartitionID int
-	GameInput   string
-	ReviewInput string
-	Output      string
 	BatchSize   int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -35,10 +36,9 @@ func getConfig() (config, error) {
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("PartitionID", "PARTITION_ID")
-	_ = v.BindEnv("GameInput", "GAME_INPUT")
-	_ = v.BindEnv("ReviewInput", "REVIEW_INPUT")
-	_ = v.BindEnv("Output", "OUTPUT")
 	_ = v.BindEnv("BatchSize", "BATCH_SIZE")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -232,9 +232,18 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
-	qOutput := middleware.Cat(cfg.Output, cfg.PartitionID)
-	gameInput := middleware.Cat(cfg.GameInput, "x", cfg.PartitionID)
-	reviewInput := middleware.Cat(cfg.ReviewInput, "x", cfg.PartitionID)
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	games, err := stage.Input("games")
+	utils.Expect(err, "Failed to load pipeline")
+	reviews, err := stage.Input("reviews")
+	utils.Expect(err, "Failed to load pipeline")
+	output, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+
+	qOutput := middleware.Cat(output, cfg.PartitionID)
+	gameInput := middleware.Cat(games, cfg.PartitionID)
+	reviewInput := middleware.Cat(reviews, cfg.PartitionID)
 	err = middleware.Topology{
 		Queues: []middleware.QueueConfig{
 			{Name: gameInput},
@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"encoding/binary"
 	"errors"
@@ -19,22 +20,20 @@ import (
 var log = logging.MustGetLogger("log")
 
 type config struct {
-	RabbitIP   string
-	Partitions int
-	Input      string
-	Output     string
+	RabbitIP string
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
 	v := viper.New()
 
 	v.SetDefault("RabbitIP", "localhost")
-	v.SetDefault("Partitions", "1")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
-	_ = v.BindEnv("Partitions", "PARTITIONS")
-	_ = v.BindEnv("Input", "INPUT")
-	_ = v.BindEnv("Output", "OUTPUT")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -163,11 +162,20 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
+	spec, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	input, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	output, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+	partitions, err := spec.Partitions(stage)
+	utils.Expect(err, "Failed to load pipeline")
+
 	queues := make([]middleware.QueueConfig, 0)
 	endpoints := make(map[string]middleware.HandlerFunc[*handler], 0)
 
-	for i := 1; i <= cfg.Partitions; i++ {
-		qName := middleware.Cat(cfg.Input, i)
+	for i := 1; i <= partitions; i++ {
+		qName := middleware.Cat(input, i)
 		qcfg := middleware.QueueConfig{
 			Name: qName,
 		}
@@ -175,9 +183,7 @@ func main() {
 		endpoints[qName] = buildHandler(i)
 	}
 
-	output := middleware.QueueConfig{Name: cfg.Output}
-
-	queues = append(queues, output)
+	queues = append(queues, middleware.QueueConfig{Name: output})
 
 	err = middleware.Topology{
 		Queues: queues,
@@ -191,14 +197,14 @@ func main() {
 			utils.Expect(err, "unrecoverable error")
 
 			sequencers := make(map[int]*middleware.SequencerDisk)
-			for i := 1; i <= cfg.Partitions; i++ {
+			for i := 1; i <= partitions; i++ {
 				sequencers[i] = middleware.NewSequencerDisk(fmt.Sprintf("sequencer-%v", i))
 				err = sequencers[i].LoadDisk(db)
 				utils.Expect(err, "unrecoverable error")
 			}
 			return &handler{
 				db:          db,
-				output:      cfg.Output,
+				output:      output,
 				lastBatchId: 0,
 				sequencers:  sequencers,
 			}, nil
@@ -206,7 +212,7 @@ func main() {
 		Endpoints: endpoints,
 		OutputConfig: middleware.Output{
 			Exchange: "",
-			Keys:     []string{cfg.Output},
+			Keys:     []string{output},
 		},
 	}
 
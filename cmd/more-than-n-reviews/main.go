@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
 	"encoding/gob"
@@ -15,6 +16,9 @@ import (
 type config struct {
 	RabbitIP string
 	N        int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -22,9 +26,12 @@ func getConfig() (config, error) {
 
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("N", 5000)
+	v.SetDefault("Stage", "q4-filter")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("N", "N_REVIEWS")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -51,16 +58,11 @@ func main() {
 		N: cfg.N,
 	}
 
-	filterCfg := middleware.FilterConfig{
-		RabbitIP: cfg.RabbitIP,
-		Exchange: middleware.ExchangeQ4,
-		Queue:    middleware.GroupedQ4Filter,
-		QueuesByKey: map[string][]string{
-			middleware.KeyQ4: {
-				middleware.ResultsQ4,
-			},
-		},
-	}
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	filterCfg, err := stage.Filter(cfg.RabbitIP, middleware.KeyQ4)
+	utils.Expect(err, "Failed to configure filter")
+
 	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 	p, err := middleware.NewFilter(filterCfg, h.Filter)
 	utils.Expect(err, "Failed to create filter")
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"errors"
 	"fmt"
@@ -14,11 +15,11 @@ import (
 )
 
 type config struct {
-	RabbitIP   string
-	Input      string
-	Output     string
-	Partitions int
-	Type       DataType
+	RabbitIP string
+	Type     DataType
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 type DataType string
@@ -32,26 +33,21 @@ func getConfig() (config, error) {
 	v := viper.New()
 
 	v.SetDefault("RabbitIP", "localhost")
-	v.SetDefault("Partitions", "1")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
-	_ = v.BindEnv("Partitions", "PARTITIONS")
-	_ = v.BindEnv("Input", "INPUT")
-	_ = v.BindEnv("Output", "OUTPUT")
 	_ = v.BindEnv("Type", "TYPE")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
 
-	if c.Input == "" {
-		return c, errors.New("InputQueue should not be empty")
+	if c.Stage == "" {
+		return c, errors.New("Stage should not be empty")
 	}
 	if c.Type != GameDataType && c.Type != ReviewDataType {
 		return c, fmt.Errorf("Type should be one of: [%v, %v]", string(GameDataType), string(ReviewDataType))
 	}
-	if c.Output == "" {
-		c.Output = middleware.Cat(c.Input, "x")
-	}
 
 	return c, err
 }
@@ -76,15 +72,24 @@ func main() {
 	cfg, err := getConfig()
 	utils.Expect(err, "Failed to read config")
 
+	spec, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	input, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	output, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+	partitions, err := spec.Partitions(stage)
+	utils.Expect(err, "Failed to load pipeline")
+
 	filterCfg := middleware.FilterConfig{
 		RabbitIP:    cfg.RabbitIP,
-		Queue:       cfg.Input,
-		Exchange:    cfg.Output,
+		Queue:       input,
+		Exchange:    output,
 		QueuesByKey: make(map[string][]string),
 	}
 
-	for i := 1; i <= cfg.Partitions; i++ {
-		qName := fmt.Sprintf("%v-%v", cfg.Output, i)
+	for i := 1; i <= partitions; i++ {
+		qName := middleware.Cat(output, i)
 		qKey := strconv.Itoa(i)
 		qNames := filterCfg.QueuesByKey[qKey]
 		qNames = append(qNames, qName)
@@ -95,7 +100,7 @@ func main() {
 	switch cfg.Type {
 	case GameDataType:
 		h := gameHandler{
-			partitionsNumber: cfg.Partitions,
+			partitionsNumber: partitions,
 		}
 		n, err := middleware.NewFilter(filterCfg, h.Filter)
 		utils.Expect(err, "Failed to create partitioner")
@@ -103,7 +108,7 @@ func main() {
 		utils.Expect(err, "Failed to run partitioner")
 	case ReviewDataType:
 		h := reviewHandler{
-			partitionsNumber: cfg.Partitions,
+			partitionsNumber: partitions,
 		}
 		n, err := middleware.NewFilter(filterCfg, h.Filter)
 		utils.Expect(err, "Failed to create partitioner")
@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
 	"encoding/binary"
@@ -21,6 +22,9 @@ import (
 type config struct {
 	RabbitIP   string
 	Percentile int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -28,9 +32,12 @@ func getConfig() (config, error) {
 
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("Percentile", 90)
+	v.SetDefault("Stage", "q5-percentile")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("Percentile", "PERCENTILE")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -195,8 +202,12 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
-	qInput := middleware.GroupedQ5Percentile
-	qOutput := middleware.Results
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	qInput, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	qOutput, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
 
 	err = middleware.Topology{
 		Queues: []middleware.QueueConfig{
@@ -217,14 +228,14 @@ func main() {
 			utils.Expect(err, "unrecoverable error")
 
 			return &handler{
-				output:     middleware.Results,
+				output:     qOutput,
 				percentile: float64(cfg.Percentile),
 				db:         db,
 				sequencer:  sequencer,
 			}, nil
 		},
 		Endpoints: map[string]middleware.HandlerFunc[*handler]{
-			middleware.GroupedQ5Percentile: (*handler).handleBatch,
+			qInput: (*handler).handleBatch,
 		},
 		OutputConfig: middleware.Output{
 			Exchange: "",
@@ -4,8 +4,12 @@ import (
 	"context"
 	"distribuidos/tp1/restarter-protocol"
 	"distribuidos/tp1/utils"
+	"errors"
+	"fmt"
+	"net/http"
 	"os/signal"
 	"syscall"
+	"time"
 
 	logging "github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -13,11 +17,49 @@ import (
 
 var log = logging.MustGetLogger("log")
 
+// Time given to the restarter to leave the cluster once it is stopped
+const LEAVE_TIMEOUT = 5 * time.Second
+
 type config struct {
 	Id       int
 	Address  string
 	Replicas int
-	LogLevel string
+	// Either docker, kubernetes, process or none
+	Mode string
+	// Either ring or bully
+	Election string
+	// Commands of the nodes, and directory with their PID and log files,
+	// when supervising local processes
+	Processes  string
+	ProcessDir string
+	// Failure detection, see restarter.MonitorConfig
+	HeartbeatInterval time.Duration
+	StartupGrace      time.Duration
+	PhiThreshold      float64
+	AcceptablePause   time.Duration
+	// Thresholds by node class, see restarter.ParseThresholds
+	PhiThresholds string
+	// Restart backoff and crash loop detection, see restarter.RestartPolicy
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+	CrashLoopWindow   time.Duration
+	CrashLoopRestarts int
+	// Directory where the restart history is persisted
+	HistoryPath string
+	// Directory where the replicated log is persisted, and entries kept
+	// before compacting it. Restarts are not replicated if the path is
+	// empty
+	LogPath string
+	LogSize int
+	// Secret shared with the nodes to authenticate packets, which are not
+	// authenticated if empty
+	Secret string
+	// Address of the admin HTTP API, disabled if empty. It only listens on
+	// the loopback interface by default
+	AdminAddress string
+	LogLevel     string
+	monitor      restarter.MonitorConfig
+	policy       restarter.RestartPolicy
 }
 
 func getConfig() (config, error) {
@@ -25,17 +67,82 @@ func getConfig() (config, error) {
 
 	v.SetDefault("Id", 0)
 	v.SetDefault("Replicas", 4)
+	v.SetDefault("Mode", restarter.DOCKER_MODE)
+	v.SetDefault("Election", restarter.RING_ELECTION)
+	v.SetDefault("Processes", ".processes.yaml")
+	v.SetDefault("ProcessDir", ".processes")
+	v.SetDefault("HeartbeatInterval", "250ms")
+	v.SetDefault("StartupGrace", "10s")
+	v.SetDefault("PhiThreshold", 8)
+	v.SetDefault("AcceptablePause", "0s")
+	// language detection may pause the filter for several seconds
+	v.SetDefault("PhiThresholds", "language-filter=16")
+	v.SetDefault("RestartBackoff", "1s")
+	v.SetDefault("MaxRestartBackoff", "1m")
+	v.SetDefault("CrashLoopWindow", "5m")
+	v.SetDefault("CrashLoopRestarts", 5)
+	v.SetDefault("HistoryPath", "restart-history")
+	v.SetDefault("LogPath", "restarter-log")
+	v.SetDefault("LogSize", restarter.DEFAULT_LOG_SIZE)
+	v.SetDefault("AdminAddress", "127.0.0.1:8080")
 	v.SetDefault("LogLevel", logging.INFO.String())
 
 	_ = v.BindEnv("Id", "ID")
 
 	_ = v.BindEnv("Replicas", "REPLICAS")
 	_ = v.BindEnv("Address", "ADDRESS")
+	_ = v.BindEnv("Mode", "RESTART_MODE")
+	_ = v.BindEnv("Election", "ELECTION")
+	_ = v.BindEnv("Processes", "PROCESSES")
+	_ = v.BindEnv("ProcessDir", "PROCESS_DIR")
+	_ = v.BindEnv("HeartbeatInterval", "HEARTBEAT_INTERVAL")
+	_ = v.BindEnv("StartupGrace", "STARTUP_GRACE")
+	_ = v.BindEnv("PhiThreshold", "PHI_THRESHOLD")
+	_ = v.BindEnv("AcceptablePause", "ACCEPTABLE_PAUSE")
+	_ = v.BindEnv("PhiThresholds", "PHI_THRESHOLDS")
+	_ = v.BindEnv("RestartBackoff", "RESTART_BACKOFF")
+	_ = v.BindEnv("MaxRestartBackoff", "MAX_RESTART_BACKOFF")
+	_ = v.BindEnv("CrashLoopWindow", "CRASH_LOOP_WINDOW")
+	_ = v.BindEnv("CrashLoopRestarts", "CRASH_LOOP_RESTARTS")
+	_ = v.BindEnv("HistoryPath", "HISTORY_PATH")
+	_ = v.BindEnv("LogPath", "LOG_PATH")
+	_ = v.BindEnv("LogSize", "LOG_SIZE")
+	_ = v.BindEnv("Secret", "RESTARTER_SECRET")
+	_ = v.BindEnv("AdminAddress", "ADMIN_ADDRESS")
 	_ = v.BindEnv("LogLevel", "LOG_LEVEL")
 
 	var c config
 	err := v.Unmarshal(&c)
-	return c, err
+	if err != nil {
+		return c, err
+	}
+
+	c.monitor = restarter.DefaultMonitorConfig()
+	c.monitor.Interval = c.HeartbeatInterval
+	c.monitor.StartupGrace = c.StartupGrace
+	c.monitor.Detector.Threshold = c.PhiThreshold
+	c.monitor.Detector.AcceptablePause = c.AcceptablePause
+	c.monitor.Thresholds, err = restarter.ParseThresholds(c.PhiThresholds)
+	if err != nil {
+		return c, fmt.Errorf("invalid phi thresholds: %w", err)
+	}
+	if c.monitor.Interval <= 0 {
+		return c, fmt.Errorf("invalid heartbeat interval: %v", c.monitor.Interval)
+	}
+
+	c.policy = restarter.RestartPolicy{
+		BaseBackoff: c.RestartBackoff,
+		MaxBackoff:  c.MaxRestartBackoff,
+		Window:      c.CrashLoopWindow,
+		MaxRestarts: c.CrashLoopRestarts,
+	}
+	if c.policy.MaxRestarts <= 0 {
+		return c, fmt.Errorf("invalid crash loop restarts: %v", c.policy.MaxRestarts)
+	}
+	if c.LogSize <= 0 {
+		return c, fmt.Errorf("invalid log size: %v", c.LogSize)
+	}
+	return c, nil
 }
 
 func main() {
@@ -45,16 +152,73 @@ func main() {
 	err = utils.InitLogger(cfg.LogLevel)
 	utils.Expect(err, "Failed to init logger")
 
-	r, err := restarter.NewRestarter(cfg.Address, cfg.Id, cfg.Replicas)
+	backend, err := newBackend(cfg)
+	utils.Expect(err, "Failed to create restart backend")
+
+	history, err := restarter.NewRestartHistory(cfg.policy, cfg.HistoryPath)
+	utils.Expect(err, "Failed to load restart history")
+	if quarantined := history.Quarantined(); len(quarantined) > 0 {
+		log.Warningf("Quarantined nodes, which will not be restarted: %v", quarantined)
+	}
+
+	var replog *restarter.ReplicatedLog
+	if cfg.LogPath != "" {
+		replog, err = restarter.NewReplicatedLog(cfg.LogPath, cfg.LogSize)
+		utils.Expect(err, "Failed to load replicated log")
+	}
+
+	nodes, err := utils.ReadNodes(restarter.CONFIG_PATH)
+	utils.Expect(err, "Failed to read nodes config")
+
+	election := restarter.DefaultElectionConfig()
+	election.Algorithm = cfg.Election
+
+	var auth *restarter.Authenticator
+	if cfg.Secret != "" {
+		auth = restarter.NewAuthenticator([]byte(cfg.Secret), restarter.MAX_PACKET_AGE)
+	} else {
+		log.Warningf("RESTARTER_SECRET is not set, packets are not authenticated")
+	}
+
+	r, err := restarter.NewRestarter(restarter.Config{
+		Address:  cfg.Address,
+		Id:       cfg.Id,
+		Replicas: cfg.Replicas,
+		Nodes:    nodes,
+		Election: election,
+		Backend:  backend,
+		Monitor:  cfg.monitor,
+		History:  history,
+		Log:      replog,
+		Auth:     auth,
+	})
 	utils.Expect(err, "Failed to create restarter")
 
-	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	signalCtx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	// the restarter keeps running after the signal, until it leaves the
+	// cluster
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	go func() {
-		err = r.Start(ctx)
-		utils.Expect(err, "Failed to run restarter")
+		err := r.Start(ctx)
+		if ctx.Err() == nil {
+			utils.Expect(err, "Failed to run restarter")
+		}
 	}()
 
+	if cfg.AdminAddress != "" {
+		admin := &http.Server{Addr: cfg.AdminAddress, Handler: r.AdminHandler(ctx)}
+		defer admin.Close()
+		go func() {
+			log.Infof("Admin API listening at %v", cfg.AdminAddress)
+			err := admin.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("Failed to serve admin API: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		for {
 			r.WaitLeader(true)
@@ -70,5 +234,31 @@ func main() {
 		}
 	}()
 
-	<-ctx.Done()
+	<-signalCtx.Done()
+
+	leaveCtx, cancelLeave := context.WithTimeout(ctx, LEAVE_TIMEOUT)
+	defer cancelLeave()
+	err = r.Leave(leaveCtx)
+	if err != nil {
+		log.Errorf("Failed to leave the cluster: %v", err)
+	}
+}
+
+func newBackend(cfg config) (restarter.RestartBackend, error) {
+	switch cfg.Mode {
+	case restarter.DOCKER_MODE:
+		return restarter.DockerBackend{}, nil
+	case restarter.KUBERNETES_MODE:
+		return restarter.NewKubernetesBackend()
+	case restarter.PROCESS_MODE:
+		processes, err := restarter.LoadProcesses(cfg.Processes)
+		if err != nil {
+			return nil, err
+		}
+		return restarter.NewProcessBackend(processes, cfg.ProcessDir)
+	case restarter.NOOP_MODE:
+		return restarter.NoopBackend{}, nil
+	default:
+		return nil, fmt.Errorf("%w %q", restarter.ErrUnknownMode, cfg.Mode)
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
 	"encoding/gob"
@@ -17,10 +18,11 @@ import (
 var log = logging.MustGetLogger("log")
 
 type config struct {
-	RabbitIP   string
-	TopN       int
-	Partitions int
-	Input      string
+	RabbitIP string
+	TopN     int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -28,12 +30,12 @@ func getConfig() (config, error) {
 
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("TopN", "10")
-	v.SetDefault("Partitions", "1")
+	v.SetDefault("Stage", "q2-joiner")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("TopN", "TOP_N")
-	_ = v.BindEnv("Partitions", "PARTITIONS")
-	_ = v.BindEnv("Input", "INPUT")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -128,19 +130,27 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
-	qOutput := middleware.Results
+	spec, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	input, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	qOutput, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+	partitions, err := spec.Partitions(stage)
+	utils.Expect(err, "Failed to load pipeline")
+
 	queues := make([]middleware.QueueConfig, 0)
 	endpoints := make(map[string]middleware.HandlerFunc[*handler], 0)
 
-	for i := 1; i <= cfg.Partitions; i++ {
-		qName := middleware.Cat(middleware.PartialQ2, i)
+	for i := 1; i <= partitions; i++ {
+		qName := middleware.Cat(input, i)
 		qcfg := middleware.QueueConfig{
 			Name: qName,
 		}
 		queues = append(queues, qcfg)
 		endpoints[qName] = buildHandler(i)
 	}
-	queues = append(queues, middleware.QueueConfig{Name: middleware.Results})
+	queues = append(queues, middleware.QueueConfig{Name: qOutput})
 
 	err = middleware.Topology{
 		Queues: queues,
@@ -156,7 +166,7 @@ func main() {
 			db, err := database.NewDatabase(database_path)
 			utils.Expect(err, "unrecoverable error")
 
-			joiner := middleware.NewJoinerDisk("joiner", cfg.Partitions)
+			joiner := middleware.NewJoinerDisk("joiner", partitions)
 			err = joiner.Load(db)
 			utils.Expect(err, "unrecoverable error")
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/utils"
 	"os/signal"
 	"syscall"
@@ -15,8 +16,10 @@ type config struct {
 	RabbitIP    string
 	TopN        int
 	PartitionId int
-	Input       string
 	Output      string
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -24,11 +27,13 @@ func getConfig() (config, error) {
 
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("TopN", "10")
+	v.SetDefault("Stage", "q2-top")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("TopN", "TOP_N")
 	_ = v.BindEnv("PartitionId", "PARTITION_ID")
-	_ = v.BindEnv("Input", "INPUT")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -125,8 +130,15 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
-	qInput := middleware.Cat(cfg.Input, "x", cfg.PartitionId)
-	qOutput := middleware.Cat(middleware.PartialQ2, cfg.PartitionId)
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	input, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	output, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+
+	qInput := middleware.Cat(input, cfg.PartitionId)
+	qOutput := middleware.Cat(output, cfg.PartitionId)
 	err = middleware.Topology{
 		Queues: []middleware.QueueConfig{
 			{Name: qInput},
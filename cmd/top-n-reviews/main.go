@@ -4,6 +4,7 @@ import (
 	"context"
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
 	"distribuidos/tp1/protocol"
 	"distribuidos/tp1/utils"
 	"encoding/gob"
@@ -17,6 +18,9 @@ type config struct {
 	RabbitIP    string
 	PartitionID int
 	N           int
+	// Stage run by the node, and file describing the pipeline
+	Stage    string
+	Pipeline string
 }
 
 func getConfig() (config, error) {
@@ -25,10 +29,13 @@ func getConfig() (config, error) {
 	v.SetDefault("RabbitIP", "localhost")
 	v.SetDefault("N", "5")
 	v.SetDefault("PartitionID", "1")
+	v.SetDefault("Stage", "q3-top")
 
 	_ = v.BindEnv("RabbitIP", "RABBIT_IP")
 	_ = v.BindEnv("N", "N")
 	_ = v.BindEnv("PartitionID", "PARTITION_ID")
+	_ = v.BindEnv("Stage", "STAGE")
+	_ = v.BindEnv("Pipeline", "PIPELINE")
 
 	var c config
 	err := v.Unmarshal(&c)
@@ -122,12 +129,19 @@ func main() {
 	conn, ch, err := middleware.Dial(cfg.RabbitIP)
 	utils.Expect(err, "Failed to dial rabbit")
 
-	qInput := middleware.Cat(middleware.GroupedQ3, cfg.PartitionID)
-	qOutput := middleware.Cat(middleware.PartialQ3, cfg.PartitionID)
+	_, stage, err := pipeline.LoadStage(cfg.Pipeline, cfg.Stage)
+	utils.Expect(err, "Failed to load pipeline")
+	input, err := stage.Input("input")
+	utils.Expect(err, "Failed to load pipeline")
+	output, err := stage.Output("output")
+	utils.Expect(err, "Failed to load pipeline")
+
+	qInput := middleware.Cat(input, cfg.PartitionID)
+	qOutput := middleware.Cat(output, cfg.PartitionID)
 	err = middleware.Topology{
 		Queues: []middleware.QueueConfig{
 			{Name: qInput},
-			{Name: middleware.PartialQ3},
+			{Name: qOutput},
 		},
 	}.Declare(ch)
 	utils.Expect(err, "Failed to declare queues")
@@ -0,0 +1,183 @@
+package dataset_test
+
+import (
+	"distribuidos/tp1/dataset"
+	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/protocol"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const GAMES = "AppID,Name,Release date,Windows,Mac,Linux,Average playtime forever,Genres\n" +
+	"1,Game,\"Jan 2, 2015\",True,False,True,10,\"Action,Indie\"\n" +
+	"2,,\"Jan 2, 2015\",True,False,True,10,Action\n" +
+	"x,Other,\"Jan 2, 2015\",True,False,True,10,Action\n" +
+	"3,Old,Mar 1999,False,True,False,0,Indie\n"
+
+func TestParseSchema(t *testing.T) {
+	s, err := dataset.ParseSchema("Name=Title; Genres=Tags", dataset.DefaultGamesSchema)
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	if s[dataset.GameNameField] != "Title" || s[dataset.GameGenresField] != "Tags" {
+		t.Fatalf("Unexpected schema: %v", s)
+	}
+	if dataset.DefaultGamesSchema[dataset.GameNameField] != "Name" {
+		t.Fatalf("Default schema was modified")
+	}
+
+	_, err = dataset.ParseSchema("Unknown=Column", dataset.DefaultGamesSchema)
+	if err == nil {
+		t.Fatalf("Expected unknown field to fail")
+	}
+}
+
+func TestColumnMapping(t *testing.T) {
+	header := []string{"\ufeffapp_id", "DiscountDLC count", "review_text", "review_score"}
+	m, err := dataset.NewColumnMapping(header, dataset.DefaultReviewsSchema)
+	if err != nil {
+		t.Fatalf("Failed to map columns: %v", err)
+	}
+
+	review, err := dataset.ReviewFromRecord([]string{"7", "0", "0", "Good", "1"}, m)
+	if err != nil {
+		t.Fatalf("Failed to parse review: %v", err)
+	}
+	expected := middleware.Review{AppID: 7, Text: "Good", Score: middleware.PositiveScore}
+	if review != expected {
+		t.Fatalf("Expected %v, got %v", expected, review)
+	}
+
+	_, err = dataset.ReviewFromRecord([]string{"7", "0"}, m)
+	if !errors.Is(err, dataset.ErrFieldCount) {
+		t.Fatalf("Expected field count error, got %v", err)
+	}
+
+	_, err = dataset.NewColumnMapping([]string{"app_id"}, dataset.DefaultReviewsSchema)
+	if !errors.Is(err, dataset.ErrMissingColumn) {
+		t.Fatalf("Expected missing column error, got %v", err)
+	}
+}
+
+func TestGameFromRecord(t *testing.T) {
+	reader, err := dataset.NewRecordReader(strings.NewReader(GAMES), "", dataset.DefaultGamesSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := reader.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := dataset.NewColumnMapping(header, dataset.DefaultGamesSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		game middleware.Game
+		err  error
+	}{
+		{game: middleware.Game{
+			AppID: 1, Name: "Game", ReleaseYear: 2015, Windows: true, Linux: true,
+			AveragePlaytimeForever: 10, Genres: []string{"Action", "Indie"},
+		}},
+		{err: dataset.ErrEmptyGameName},
+		{err: dataset.ErrInvalidAppID},
+		{game: middleware.Game{
+			AppID: 3, Name: "Old", ReleaseYear: 1999, Mac: true, Genres: []string{"Indie"},
+		}},
+	}
+	for i, e := range expected {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatalf("Failed to read record %v: %v", i, err)
+		}
+		game, err := dataset.GameFromRecord(record, m)
+		if e.err != nil {
+			if !errors.Is(err, e.err) {
+				t.Fatalf("Expected error %v for record %v, got %v", e.err, i, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(game, e.game) {
+			t.Fatalf("Expected game %v for record %v, got %v (%v)", e.game, i, game, err)
+		}
+	}
+}
+
+func TestJSONLRecordReader(t *testing.T) {
+	input := `{"app_id": 1, "review_text": "Good", "review_score": 1}` + "\n\n" +
+		"not json\n" +
+		`{"app_id": 2, "review_text": "Bad", "review_score": -1}`
+	reader, err := dataset.NewRecordReader(strings.NewReader(input), protocol.FormatJSONL, dataset.DefaultReviewsSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := reader.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := dataset.NewColumnMapping(header, dataset.DefaultReviewsSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reviews := make([]middleware.Review, 0)
+	rejected := make([]int, 0)
+	err = dataset.ParseRecords(reader, 2,
+		func(record []string) (middleware.Review, error) { return dataset.ReviewFromRecord(record, m) },
+		func(r middleware.Review) error { reviews = append(reviews, r); return nil },
+		func(line int, _ []string, _ error) { rejected = append(rejected, line) },
+	)
+	if err != nil {
+		t.Fatalf("Failed to parse records: %v", err)
+	}
+
+	expected := []middleware.Review{
+		{AppID: 1, Text: "Good", Score: middleware.PositiveScore},
+		{AppID: 2, Text: "Bad", Score: middleware.NegativeScore},
+	}
+	if !reflect.DeepEqual(reviews, expected) {
+		t.Fatalf("Expected %v, got %v", expected, reviews)
+	}
+	if !reflect.DeepEqual(rejected, []int{3}) {
+		t.Fatalf("Expected line 3 to be rejected, got %v", rejected)
+	}
+}
+
+func TestParseRecordsOrder(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("app_id,review_text,review_score\n")
+	records := dataset.PARSE_CHUNK_SIZE*5 + 3
+	for i := range records {
+		fmt.Fprintf(&input, "%v,Text,1\n", i)
+	}
+
+	reader, err := dataset.NewRecordReader(strings.NewReader(input.String()), "", dataset.DefaultReviewsSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = reader.Header()
+
+	next := 0
+	err = dataset.ParseRecords(reader, 4,
+		func(record []string) (string, error) { return record[0], nil },
+		func(id string) error {
+			if id != fmt.Sprint(next) {
+				return fmt.Errorf("expected record %v, got %v", next, id)
+			}
+			next += 1
+			return nil
+		},
+		func(int, []string, error) {},
+	)
+	if err != nil {
+		t.Fatalf("Failed to parse records: %v", err)
+	}
+	if next != records {
+		t.Fatalf("Expected %v records, got %v", records, next)
+	}
+}
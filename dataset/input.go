@@ -0,0 +1,187 @@
+package dataset
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"distribuidos/tp1/protocol"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported input format")
+
+// Returned by record readers when a single record can't be parsed. The
+// record is skipped, and reading can continue with the next one
+type MalformedRecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *MalformedRecordError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.Line, e.Err)
+}
+
+func (e *MalformedRecordError) Unwrap() error {
+	return e.Err
+}
+
+// Reads records of an input file as a list of fields, independently of its format
+type RecordReader interface {
+	// Returns the column names of the file
+	Header() ([]string, error)
+	// Returns the next record, or io.EOF when there are no more
+	Read() ([]string, error)
+	// Returns the line number of the last record read
+	Line() int
+}
+
+func ValidateFormat(format string) error {
+	switch format {
+	case "", protocol.FormatCSV, protocol.FormatCSVGzip, protocol.FormatJSONL, protocol.FormatJSONLGzip:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+}
+
+// Builds a reader for the given format. JSON Lines files have no header, so
+// the columns of the schema are used to build each record
+func NewRecordReader(r io.Reader, format string, s Schema) (RecordReader, error) {
+	var err error
+	encoding, compression, _ := strings.Cut(format, ".")
+
+	switch compression {
+	case "":
+	case "gz":
+		r, err = gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	switch encoding {
+	case "", protocol.FormatCSV:
+		return newCSVRecordReader(r), nil
+	case protocol.FormatJSONL:
+		return newJSONLRecordReader(r, s), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+}
+
+type csvRecordReader struct {
+	reader *csv.Reader
+}
+
+func newCSVRecordReader(r io.Reader) *csvRecordReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return &csvRecordReader{reader: reader}
+}
+
+func (c *csvRecordReader) Header() ([]string, error) {
+	return c.reader.Read()
+}
+
+func (c *csvRecordReader) Read() ([]string, error) {
+	record, err := c.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, &MalformedRecordError{Line: parseErr.StartLine, Err: parseErr.Err}
+	}
+	return record, err
+}
+
+func (c *csvRecordReader) Line() int {
+	line, _ := c.reader.FieldPos(0)
+	return line
+}
+
+type jsonlRecordReader struct {
+	reader  *bufio.Reader
+	columns []string
+	line    int
+}
+
+func newJSONLRecordReader(r io.Reader, s Schema) *jsonlRecordReader {
+	columns := make([]string, 0, len(s))
+	for _, column := range s {
+		columns = append(columns, column)
+	}
+
+	return &jsonlRecordReader{
+		reader:  bufio.NewReader(r),
+		columns: columns,
+	}
+}
+
+func (j *jsonlRecordReader) Header() ([]string, error) {
+	return j.columns, nil
+}
+
+func (j *jsonlRecordReader) Read() ([]string, error) {
+	for {
+		line, err := j.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		j.line += 1
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var object map[string]any
+		err = decoder.Decode(&object)
+		if err != nil {
+			return nil, &MalformedRecordError{Line: j.line, Err: err}
+		}
+
+		record := make([]string, len(j.columns))
+		for i, column := range j.columns {
+			record[i] = jsonFieldToString(object[column])
+		}
+		return record, nil
+	}
+}
+
+func (j *jsonlRecordReader) Line() int {
+	return j.line
+}
+
+// Converts a JSON value to its representation in the CSV files
+func jsonFieldToString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case json.Number:
+		return v.String()
+	case []any:
+		values := make([]string, len(v))
+		for i, value := range v {
+			values[i] = jsonFieldToString(value)
+		}
+		return strings.Join(values, ",")
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
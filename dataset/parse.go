@@ -0,0 +1,144 @@
+package dataset
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Records are parsed in chunks of this size, each one by a single worker
+const PARSE_CHUNK_SIZE = 256
+
+type parsedRecord[T any] struct {
+	line   int
+	fields []string
+	value  T
+	err    error
+}
+
+type recordChunk[T any] struct {
+	seq     int
+	records []parsedRecord[T]
+}
+
+// Reads all records, parsing them concurrently with the given amount of
+// workers. Records are handled in the same order they were read: with
+// onRecord if they were parsed, or with onReject otherwise
+//
+// If onRecord fails, parsing is stopped and the error is returned
+func ParseRecords[T any](
+	reader RecordReader,
+	workers int,
+	parse func([]string) (T, error),
+	onRecord func(T) error,
+	onReject func(line int, record []string, err error),
+) error {
+	workers = max(workers, 1)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	chunks := make(chan recordChunk[T], workers)
+	parsed := make(chan recordChunk[T], workers)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		readErr <- readChunks(reader, chunks, done)
+	}()
+
+	wg := &sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				for i, record := range chunk.records {
+					if record.err == nil {
+						chunk.records[i].value, chunk.records[i].err = parse(record.fields)
+					}
+				}
+				select {
+				case parsed <- chunk:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(parsed)
+	}()
+
+	// chunks may be parsed out of order, so they are held until all the
+	// previous ones have been handled
+	pending := make(map[int]recordChunk[T])
+	next := 0
+	for chunk := range parsed {
+		pending[chunk.seq] = chunk
+		for {
+			chunk, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next += 1
+
+			for _, record := range chunk.records {
+				if record.err != nil {
+					onReject(record.line, record.fields, record.err)
+					continue
+				}
+				err := onRecord(record.value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return <-readErr
+}
+
+// Groups the records of the reader in sequenced chunks, until EOF. Records
+// that can't be read are kept in the chunk, so that they are rejected in order
+func readChunks[T any](reader RecordReader, chunks chan<- recordChunk[T], done <-chan struct{}) error {
+	for seq := 0; ; seq++ {
+		chunk := recordChunk[T]{
+			seq:     seq,
+			records: make([]parsedRecord[T], 0, PARSE_CHUNK_SIZE),
+		}
+
+		var err error
+		for len(chunk.records) < PARSE_CHUNK_SIZE {
+			var fields []string
+			fields, err = reader.Read()
+			var malformedErr *MalformedRecordError
+			if errors.As(err, &malformedErr) {
+				chunk.records = append(chunk.records, parsedRecord[T]{line: malformedErr.Line, err: err})
+				err = nil
+				continue
+			}
+			if err != nil {
+				break
+			}
+			chunk.records = append(chunk.records, parsedRecord[T]{line: reader.Line(), fields: fields})
+		}
+
+		if len(chunk.records) > 0 {
+			select {
+			case chunks <- chunk:
+			case <-done:
+				return nil
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
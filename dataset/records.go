@@ -0,0 +1,96 @@
+package dataset
+
+import (
+	"distribuidos/tp1/middleware"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrFieldCount = errors.New("wrong field count")
+var ErrInvalidAppID = errors.New("invalid app id")
+var ErrInvalidReleaseDate = errors.New("invalid release date")
+var ErrInvalidPlaytime = errors.New("invalid playtime")
+var ErrInvalidScore = errors.New("invalid score")
+
+var ErrEmptyGameName = errors.New("game name should not be empty")
+var ErrEmptyGameGenres = errors.New("game genres should not be empty")
+var ErrEmptyReviewText = errors.New("review text should not be empty")
+
+// Parses a game, using the mapping to locate its fields in the record
+func GameFromRecord(record []string, m ColumnMapping) (game middleware.Game, err error) {
+	err = m.CheckFields(record)
+	if err != nil {
+		return
+	}
+	appId, err := strconv.Atoi(m.Get(record, GameAppIDField))
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidAppID, err)
+		return
+	}
+	var releaseDate time.Time
+	rawReleaseDate := m.Get(record, GameReleaseDateField)
+	releaseDate, err = time.Parse("Jan 2, 2006", rawReleaseDate)
+	if err != nil {
+		releaseDate, err = time.Parse("Jan 2006", rawReleaseDate)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrInvalidReleaseDate, err)
+			return
+		}
+	}
+	averagePlaytimeForever, err := strconv.Atoi(m.Get(record, GamePlaytimeField))
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidPlaytime, err)
+		return
+	}
+
+	game.AppID = uint64(appId)
+	game.Name = m.Get(record, GameNameField)
+	if game.Name == "" {
+		err = ErrEmptyGameName
+		return
+	}
+	game.ReleaseYear = uint16(releaseDate.Year())
+	game.Windows = m.Get(record, GameWindowsField) == "True"
+	game.Mac = m.Get(record, GameMacField) == "True"
+	game.Linux = m.Get(record, GameLinuxField) == "True"
+	game.AveragePlaytimeForever = uint64(averagePlaytimeForever)
+	genres := m.Get(record, GameGenresField)
+	if genres == "" {
+		err = ErrEmptyGameGenres
+		return
+	}
+	game.Genres = strings.Split(genres, ",")
+
+	return
+}
+
+// Parses a review, using the mapping to locate its fields in the record
+func ReviewFromRecord(record []string, m ColumnMapping) (review middleware.Review, err error) {
+	err = m.CheckFields(record)
+	if err != nil {
+		return
+	}
+	appId, err := strconv.Atoi(m.Get(record, ReviewAppIDField))
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidAppID, err)
+		return
+	}
+	score, err := strconv.Atoi(m.Get(record, ReviewScoreField))
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidScore, err)
+		return
+	}
+
+	review.AppID = uint64(appId)
+	review.Text = m.Get(record, ReviewTextField)
+	if review.Text == "" {
+		err = ErrEmptyReviewText
+		return
+	}
+	review.Score = middleware.Score(score)
+
+	return
+}
@@ -0,0 +1,131 @@
+// Package dataset parses the games and reviews files, in any of the
+// supported input formats
+package dataset
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Fields read from the games file
+const (
+	GameAppIDField       = "AppID"
+	GameNameField        = "Name"
+	GameReleaseDateField = "ReleaseDate"
+	GameWindowsField     = "Windows"
+	GameMacField         = "Mac"
+	GameLinuxField       = "Linux"
+	GamePlaytimeField    = "AveragePlaytimeForever"
+	GameGenresField      = "Genres"
+)
+
+// Fields read from the reviews file
+const (
+	ReviewAppIDField = "AppID"
+	ReviewTextField  = "Text"
+	ReviewScoreField = "Score"
+)
+
+// A schema maps each field to the name of the column that holds it
+type Schema map[string]string
+
+var DefaultGamesSchema = Schema{
+	GameAppIDField:       "AppID",
+	GameNameField:        "Name",
+	GameReleaseDateField: "Release date",
+	GameWindowsField:     "Windows",
+	GameMacField:         "Mac",
+	GameLinuxField:       "Linux",
+	GamePlaytimeField:    "Average playtime forever",
+	GameGenresField:      "Genres",
+}
+
+var DefaultReviewsSchema = Schema{
+	ReviewAppIDField: "app_id",
+	ReviewTextField:  "review_text",
+	ReviewScoreField: "review_score",
+}
+
+// Some datasets have a malformed header, with two columns merged into a
+// single name. The header is fixed by splitting them back
+var headerFixes = map[string][]string{
+	"DiscountDLC count": {"Discount", "DLC count"},
+}
+
+var ErrMissingColumn = errors.New("missing column")
+
+// Overrides the given schema with a specification of the form
+// `Field=Column name;Field=Column name`
+func ParseSchema(spec string, defaults Schema) (Schema, error) {
+	s := maps.Clone(defaults)
+	if strings.TrimSpace(spec) == "" {
+		return s, nil
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		field, column, ok := strings.Cut(entry, "=")
+		field = strings.TrimSpace(field)
+		if !ok || field == "" || column == "" {
+			return nil, fmt.Errorf("invalid schema entry %q, expected Field=Column", entry)
+		}
+		if _, ok := defaults[field]; !ok {
+			return nil, fmt.Errorf("unknown schema field %q, expected one of %v", field, slices.Sorted(maps.Keys(defaults)))
+		}
+		s[field] = column
+	}
+
+	return s, nil
+}
+
+// Resolved position of each field of a schema in a particular file
+type ColumnMapping struct {
+	index map[string]int
+	// Minimum amount of fields a record must have
+	minFields int
+}
+
+func NewColumnMapping(header []string, s Schema) (ColumnMapping, error) {
+	positions := make(map[string]int)
+	i := 0
+	for _, name := range header {
+		// the first column may carry the utf-8 byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		names, ok := headerFixes[name]
+		if !ok {
+			names = []string{name}
+		}
+		for _, name := range names {
+			if _, exists := positions[name]; !exists {
+				positions[name] = i
+			}
+			i += 1
+		}
+	}
+
+	m := ColumnMapping{index: make(map[string]int)}
+	for field, column := range s {
+		position, ok := positions[column]
+		if !ok {
+			return ColumnMapping{}, fmt.Errorf("%w %q for field %v", ErrMissingColumn, column, field)
+		}
+		m.index[field] = position
+		m.minFields = max(m.minFields, position+1)
+	}
+
+	return m, nil
+}
+
+// Must only be called with records already validated with CheckFields
+func (m ColumnMapping) Get(record []string, field string) string {
+	return record[m.index[field]]
+}
+
+func (m ColumnMapping) CheckFields(record []string) error {
+	if len(record) < m.minFields {
+		return fmt.Errorf("%w: expected %v fields, got %v", ErrFieldCount, m.minFields, len(record))
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// Package language holds the language detection configuration shared by
+// the language filter and the reference solutions
+package language
+
+import (
+	lingua "github.com/pemistahl/lingua-go"
+)
+
+// Languages considered by the detector. Restricting them to the ones
+// present in the dataset makes detection faster and more accurate
+var Languages = []lingua.Language{
+	lingua.English,
+	lingua.Spanish,
+}
+
+// Builds a detector for the supported languages. It's safe for concurrent use
+func NewDetector() lingua.LanguageDetector {
+	return lingua.NewLanguageDetectorBuilder().
+		FromLanguages(Languages...).
+		Build()
+}
+
+// Detects if the given text is English or not
+func IsEnglish(detector lingua.LanguageDetector, text string) bool {
+	lang, _ := detector.DetectLanguageOf(text)
+	return lang == lingua.English
+}
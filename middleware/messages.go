@@ -57,6 +57,13 @@ type Batch[T any] struct {
 	EOF     bool
 }
 
+// Published by nodes to the status queue when they finish
+// processing a client, to report its progress through the pipeline
+type Status struct {
+	// Input queue that the node finished consuming
+	Queue string
+}
+
 func Serialize(v any) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -5,16 +5,17 @@ import (
 	"distribuidos/tp1/database"
 	"distribuidos/tp1/restarter-protocol"
 	"distribuidos/tp1/utils"
-	"errors"
 	"fmt"
-	"net"
 	"os"
 	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-const MAX_PACKAGE_SIZE = 1024
+// Time given to the node to requeue its pending deliveries and leave the
+// cluster once it is stopped
+const DRAIN_TIMEOUT = 5 * time.Second
 
 type Config[T Handler] struct {
 	// For each client, the builder is called to initialize a new builder
@@ -32,6 +33,9 @@ type Node[T Handler] struct {
 	clients        map[int]T
 	db             *database.Database
 	doneClientsSet *DiskSet
+	reporter       *restarter.Reporter
+	// forwarders of the deliveries of each queue
+	consumers sync.WaitGroup
 }
 
 func NewNode[T Handler](config Config[T], rabbit *amqp.Connection) (*Node[T], error) {
@@ -49,6 +53,11 @@ func NewNode[T Handler](config Config[T], rabbit *amqp.Connection) (*Node[T], er
 		return nil, err
 	}
 
+	err = QueueConfig{Name: StatusQueue}.Declare(ch)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := database.NewDatabase("node")
 	utils.Expect(err, "unrecoverable error")
 
@@ -65,22 +74,30 @@ func NewNode[T Handler](config Config[T], rabbit *amqp.Connection) (*Node[T], er
 		clients:        make(map[int]T),
 		db:             db,
 		doneClientsSet: doneClientsSet,
+		reporter:       restarter.NewReporter(restarter.NodeName()),
 	}, nil
 }
 
+// Processes deliveries until the context is cancelled, and then drains the
+// node. Processing is not interrupted, so the handlers commit the snapshot
+// of the delivery in progress
 func (n *Node[T]) Run(ctx context.Context) error {
 	defer n.rabbit.Close()
 
+	// the reporter outlives the context, to tell the restarter that the
+	// shutdown is planned
+	reporterCtx, stopReporter := context.WithCancel(context.Background())
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err := n.sendAlive(ctx)
+		err := n.reporter.Run(reporterCtx, utils.NODE_UDP_ADDR)
 		if err != nil {
 			log.Errorf("%v", err)
 		}
 	}()
 	defer wg.Wait()
+	defer stopReporter()
 
 	dch := make(chan Delivery)
 
@@ -98,12 +115,56 @@ func (n *Node[T]) Run(ctx context.Context) error {
 			if err != nil {
 				return err
 			}
+			n.reporter.SetActiveClients(len(n.clients))
+			n.reporter.BatchProcessed(time.Now())
 		case <-ctx.Done():
+			n.drain(dch)
 			return nil
 		}
 	}
 }
 
+// Requeues the deliveries that were received but not processed, once the
+// consumers are cancelled, and leaves the cluster, so that the restarter
+// does not take the shutdown for a failure. Deliveries not requeued within
+// DRAIN_TIMEOUT are requeued by the broker once the connection is closed
+func (n *Node[T]) drain(dch <-chan Delivery) {
+	log.Infof("Draining node")
+	deadline := time.Now().Add(DRAIN_TIMEOUT)
+	timeout := time.After(DRAIN_TIMEOUT)
+
+	drained := make(chan struct{})
+	go func() {
+		n.consumers.Wait()
+		close(drained)
+	}()
+
+	requeued := 0
+	for done := false; !done; {
+		select {
+		case d := <-dch:
+			err := d.Nack(false, true)
+			if err != nil {
+				log.Errorf("Failed to requeue delivery: %v", err)
+			}
+			requeued += 1
+		case <-drained:
+			done = true
+		case <-timeout:
+			log.Warningf("Timed out waiting for the consumers to stop")
+			done = true
+		}
+	}
+	log.Infof("Requeued %v pending deliveries", requeued)
+
+	leaveCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	err := n.reporter.Leave(leaveCtx)
+	if err != nil {
+		log.Errorf("Failed to leave the cluster: %v", err)
+	}
+}
+
 func (n *Node[T]) processDelivery(d Delivery) error {
 	clientID := int(d.Headers["clientID"].(int32))
 	cleanAction := int(d.Headers["cleanAction"].(int32))
@@ -148,6 +209,7 @@ func (n *Node[T]) processDelivery(d Delivery) error {
 			return err
 		}
 		utils.MaybeExit(0.2)
+		n.publishStatus(clientID, d.Queue)
 	}
 
 	if err != nil {
@@ -207,6 +269,20 @@ func (n *Node[T]) propagateFallenNode(clientID int, cleanAction int) {
 	}
 }
 
+// Notifies the gateway that the node has finished processing the client. Status
+// is best effort, so failures are only logged
+func (n *Node[T]) publishStatus(clientID int, queue string) {
+	ch := &Channel{
+		Ch:          n.ch,
+		ClientID:    clientID,
+		CleanAction: NotClean,
+	}
+	err := ch.Send(Status{Queue: queue}, "", StatusQueue)
+	if err != nil {
+		log.Errorf("Failed to publish status: %v", err)
+	}
+}
+
 func (n *Node[T]) freeResources(clientID int, h Handler) error {
 	log.Infof("Freeing resources for client %v", clientID)
 	snapshot, err := n.db.NewSnapshot()
@@ -239,62 +315,6 @@ func cleanAllClients(clientsSet *DiskSet) {
 	}
 }
 
-func (n *Node[T]) sendAlive(ctx context.Context) error {
-	udpAddr, err := net.ResolveUDPAddr("udp", utils.NODE_UDP_ADDR)
-	if err != nil {
-		return fmt.Errorf("Failed to resolve address: %v", err)
-	}
-
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		return fmt.Errorf("failed to start listener on address %v: %v", utils.NODE_UDP_ADDR, err)
-	}
-
-	log.Infof("Listening in %v", udpAddr)
-
-	closer := utils.SpawnCloser(ctx, conn)
-	defer func() {
-		closeErr := closer.Close()
-		err = errors.Join(err, closeErr)
-	}()
-
-	for {
-		buf := make([]byte, MAX_PACKAGE_SIZE)
-		_, rAddr, err := conn.ReadFromUDP(buf)
-
-		if err != nil {
-			return fmt.Errorf("Read error: %v", err)
-		}
-
-		decoded, err := restarter.Decode(buf)
-		if err != nil {
-			return fmt.Errorf("Failed to decode message: %v", err)
-		}
-
-		err = n.send(conn, rAddr, decoded.Id)
-		if err != nil {
-			return fmt.Errorf("Failed to send ACK: %v", err)
-		}
-	}
-}
-
-func (n *Node[T]) send(conn *net.UDPConn, rAddr *net.UDPAddr, msgId uint64) error {
-	packet := restarter.Packet{
-		Id:  msgId,
-		Msg: restarter.Ack{}}
-
-	encoded, err := packet.Encode()
-	if err != nil {
-		return err
-	}
-
-	_, err = conn.WriteToUDP(encoded, rAddr)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 type Delivery struct {
 	Queue string
 	amqp.Delivery
@@ -306,7 +326,11 @@ func (n *Node[T]) Consume(ctx context.Context, queue string, deliveries chan<- D
 		return err
 	}
 
+	// deliveries are forwarded until the consumer is cancelled along with
+	// the context
+	n.consumers.Add(1)
 	go func() {
+		defer n.consumers.Done()
 		for d := range dch {
 			deliveries <- Delivery{
 				Queue:    queue,
@@ -0,0 +1,206 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+type NodeKind int
+
+const (
+	StageNode NodeKind = iota
+	QueueNode
+	ExchangeNode
+)
+
+type GraphNode struct {
+	Kind NodeKind
+	Name string
+	// Lines describing the node
+	Label []string
+}
+
+type GraphEdge struct {
+	// Indexes of the connected nodes
+	From  int
+	To    int
+	Label string
+}
+
+// Graph of the pipeline, connecting stages with the queues they read, and
+// with the queues and exchanges they write
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// Builds the graph of the pipeline. Nodes appear in order of declaration,
+// so that the output is deterministic
+func (s Spec) Graph() Graph {
+	var g Graph
+	index := make(map[NodeKind]map[string]int)
+	node := func(kind NodeKind, name string, label ...string) int {
+		if index[kind] == nil {
+			index[kind] = make(map[string]int)
+		}
+		if i, ok := index[kind][name]; ok {
+			return i
+		}
+		if len(label) == 0 {
+			label = []string{name}
+		}
+		g.Nodes = append(g.Nodes, GraphNode{Kind: kind, Name: name, Label: label})
+		index[kind][name] = len(g.Nodes) - 1
+		return len(g.Nodes) - 1
+	}
+	queue := func(name string) int {
+		return node(QueueNode, name, s.queueLabel(name)...)
+	}
+
+	for _, stage := range s.Stages {
+		label := []string{stage.Name, stage.Binary}
+		if stage.Replicas > 0 {
+			label[1] = fmt.Sprintf("%v ×%v", stage.Binary, stage.Replicas)
+		}
+		from := node(StageNode, stage.Name, label...)
+
+		for _, role := range sortedKeys(stage.Inputs) {
+			g.Edges = append(g.Edges, GraphEdge{From: queue(stage.Inputs[role]), To: from, Label: roleLabel(role)})
+		}
+
+		exchanges := make([]string, 0, len(stage.Exchanges))
+		for _, exchange := range stage.Exchanges {
+			exchanges = append(exchanges, exchange.Name)
+		}
+		for _, role := range sortedKeys(stage.Outputs) {
+			output := stage.Outputs[role]
+			if slices.Contains(exchanges, output) {
+				continue
+			}
+			g.Edges = append(g.Edges, GraphEdge{From: from, To: queue(output), Label: roleLabel(role)})
+		}
+
+		for _, exchange := range stage.Exchanges {
+			to := node(ExchangeNode, exchange.Name, exchange.Name, exchange.kind())
+			g.Edges = append(g.Edges, GraphEdge{From: from, To: to})
+			for _, key := range exchange.keys() {
+				for _, q := range exchange.Routes[key] {
+					g.Edges = append(g.Edges, GraphEdge{From: to, To: queue(q), Label: key})
+				}
+			}
+		}
+	}
+
+	return g
+}
+
+// Partitioned queues are labeled with the range of their partitions
+func (s Spec) queueLabel(queue string) []string {
+	for _, writer := range s.Stages {
+		if !writer.writes(queue) {
+			continue
+		}
+		if partitions := s.partitionsOf(writer, queue, Stage.reads); partitions > 0 {
+			return []string{fmt.Sprintf("%v-{1..%v}", queue, partitions)}
+		}
+	}
+	return []string{queue}
+}
+
+// Roles are only shown when they are not the default ones
+func roleLabel(role string) string {
+	if role == "input" || role == "output" {
+		return ""
+	}
+	return role
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Writes the graph in Graphviz DOT format
+func (g Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, n := range g.Nodes {
+		var shape string
+		switch n.Kind {
+		case StageNode:
+			shape = "box"
+		case QueueNode:
+			shape = "cylinder"
+		case ExchangeNode:
+			shape = "hexagon"
+		}
+		fmt.Fprintf(&b, "  %v [shape=%v, label=%v];\n", n.id(), shape, dotString(strings.Join(n.Label, "\n")))
+	}
+	for _, e := range g.Edges {
+		if e.Label == "" {
+			fmt.Fprintf(&b, "  %v -> %v;\n", g.Nodes[e.From].id(), g.Nodes[e.To].id())
+		} else {
+			fmt.Fprintf(&b, "  %v -> %v [label=%v];\n", g.Nodes[e.From].id(), g.Nodes[e.To].id(), dotString(e.Label))
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the graph as a Mermaid flowchart
+func (g Graph) WriteMermaid(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, n := range g.Nodes {
+		label := mermaidString(strings.Join(n.Label, "<br>"))
+		switch n.Kind {
+		case StageNode:
+			fmt.Fprintf(&b, "  %v[%v]\n", n.id(), label)
+		case QueueNode:
+			fmt.Fprintf(&b, "  %v[(%v)]\n", n.id(), label)
+		case ExchangeNode:
+			fmt.Fprintf(&b, "  %v{{%v}}\n", n.id(), label)
+		}
+	}
+	for _, e := range g.Edges {
+		if e.Label == "" {
+			fmt.Fprintf(&b, "  %v --> %v\n", g.Nodes[e.From].id(), g.Nodes[e.To].id())
+		} else {
+			fmt.Fprintf(&b, "  %v -->|%v| %v\n", g.Nodes[e.From].id(), mermaidString(e.Label), g.Nodes[e.To].id())
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Identifier of the node, valid both in DOT and Mermaid
+func (n GraphNode) id() string {
+	prefix := [...]string{StageNode: "stage_", QueueNode: "queue_", ExchangeNode: "exchange_"}[n.Kind]
+	return prefix + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, n.Name)
+}
+
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func mermaidString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
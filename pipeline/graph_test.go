@@ -0,0 +1,98 @@
+package pipeline_test
+
+import (
+	"distribuidos/tp1/pipeline"
+	"strings"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	spec, err := pipeline.Parse([]byte(SPEC), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := spec.Graph()
+
+	labels := make([]string, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		labels = append(labels, strings.Join(n.Label, " "))
+	}
+	expected := []string{
+		"source gateway", "games-x fanout", "games",
+		"filter filter-genre ×2", "filter-x direct", "out-a", "out-ab",
+		"partitioner partitioner", "partitioned",
+	}
+	if strings.Join(labels, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected nodes %v, got %v", expected, labels)
+	}
+
+	// gateway to its exchange, fanout to games, games to filter, filter to
+	// its exchange, three routes, and the partitioner input and output
+	if len(g.Edges) != 9 {
+		t.Fatalf("Expected 9 edges, got %v", g.Edges)
+	}
+}
+
+func TestGraphFormats(t *testing.T) {
+	spec, err := pipeline.Parse([]byte(SPEC), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := spec.Graph()
+
+	var dot strings.Builder
+	err = g.WriteDOT(&dot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`stage_filter [shape=box, label="filter\nfilter-genre ×2"];`,
+		`exchange_filter_x -> queue_out_ab [label="b"];`,
+		`queue_out_a -> stage_partitioner;`,
+	} {
+		if !strings.Contains(dot.String(), line) {
+			t.Fatalf("Expected DOT output to contain %q, got:\n%v", line, dot.String())
+		}
+	}
+
+	var mermaid strings.Builder
+	err = g.WriteMermaid(&mermaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`queue_games[("games")]`,
+		`exchange_games_x{{"games-x<br>fanout"}}`,
+		`exchange_filter_x -->|"a"| queue_out_a`,
+		`stage_partitioner --> queue_partitioned`,
+	} {
+		if !strings.Contains(mermaid.String(), line) {
+			t.Fatalf("Expected Mermaid output to contain %q, got:\n%v", line, mermaid.String())
+		}
+	}
+}
+
+func TestGraphPartitions(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := make(map[string]string)
+	for _, n := range spec.Graph().Nodes {
+		if n.Kind == pipeline.QueueNode {
+			labels[n.Name] = n.Label[0]
+		}
+	}
+	expected := map[string]string{
+		"games-Q1-x":        "games-Q1-x-{1..3}",
+		"grouped-Q3-top":    "grouped-Q3-top-{1..3}",
+		"partial-Q1-joiner": "partial-Q1-joiner-{1..3}",
+		"results":           "results",
+	}
+	for queue, label := range expected {
+		if labels[queue] != label {
+			t.Fatalf("Expected queue %v to be labeled %v, got %q", queue, label, labels[queue])
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"distribuidos/tp1/middleware"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Builds the topology of the exchanges declared by the stage, along with
+// the queues bound to them
+func (s Stage) Topology() middleware.Topology {
+	var topology middleware.Topology
+	bindings := make(map[string]map[string][]string)
+
+	for _, exchange := range s.Exchanges {
+		topology.Exchanges = append(topology.Exchanges, middleware.ExchangeConfig{
+			Name: exchange.Name,
+			Type: exchange.kind(),
+		})
+		for _, key := range exchange.keys() {
+			for _, queue := range exchange.Routes[key] {
+				if bindings[queue] == nil {
+					bindings[queue] = make(map[string][]string)
+				}
+				bindings[queue][exchange.Name] = append(bindings[queue][exchange.Name], key)
+			}
+		}
+	}
+
+	for _, queue := range s.RoutedQueues() {
+		topology.Queues = append(topology.Queues, middleware.QueueConfig{
+			Name:     queue,
+			Bindings: bindings[queue],
+		})
+	}
+
+	return topology
+}
+
+// Builds the configuration of a filter stage, which reads from its input
+// and routes records through its only exchange. Fails if any of the keys
+// emitted by the filter is not routed anywhere
+func (s Stage) Filter(rabbitIP string, keys ...string) (middleware.FilterConfig, error) {
+	input, err := s.Input("input")
+	if err != nil {
+		return middleware.FilterConfig{}, err
+	}
+	if len(s.Exchanges) != 1 {
+		return middleware.FilterConfig{}, fmt.Errorf("filter stage %v must declare a single exchange", s.Name)
+	}
+	exchange := s.Exchanges[0]
+
+	for _, key := range keys {
+		if _, ok := exchange.Routes[key]; !ok {
+			return middleware.FilterConfig{}, fmt.Errorf(
+				"%w: stage %v does not route key %q, routed keys are %v",
+				ErrMissingQueue, s.Name, key, slices.Sorted(maps.Keys(exchange.Routes)),
+			)
+		}
+	}
+
+	return middleware.FilterConfig{
+		RabbitIP:    rabbitIP,
+		Queue:       input,
+		Exchange:    exchange.Name,
+		QueuesByKey: maps.Clone(exchange.Routes),
+	}, nil
+}
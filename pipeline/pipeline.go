@@ -0,0 +1,294 @@
+// Package pipeline describes the stages of the system, and the queues and
+// exchanges that connect them. Nodes resolve their queues from it, and the
+// deployment files are generated from it
+package pipeline
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/yaml.v3"
+)
+
+// Pipeline used when no other is given
+//
+//go:embed pipeline.yaml
+var defaultSpec []byte
+
+var ErrUnknownStage = errors.New("unknown stage")
+var ErrMissingQueue = errors.New("missing queue")
+var ErrInvalidSpec = errors.New("invalid pipeline")
+
+type Spec struct {
+	Stages []Stage `yaml:"stages" json:"stages"`
+}
+
+type Stage struct {
+	Name string `yaml:"name" json:"name"`
+	// Node binary that runs the stage
+	Binary string `yaml:"binary" json:"binary"`
+	// Amount of instances, numbered from 1. When 0, a single unnumbered instance is run
+	Replicas int `yaml:"replicas" json:"replicas"`
+	// Stage whose instances define the partitions written or read by this stage
+	Partitions string `yaml:"partitions" json:"partitions"`
+	// Whether the stage keeps state on disk that must survive restarts
+	Persistent bool `yaml:"persistent" json:"persistent"`
+	// Extra configuration of the stage, as environment variables
+	Environment map[string]string `yaml:"environment" json:"environment"`
+	// Queues read by the stage, by role
+	Inputs map[string]string `yaml:"inputs" json:"inputs"`
+	// Queues or exchanges written by the stage, by role
+	Outputs map[string]string `yaml:"outputs" json:"outputs"`
+	// Exchanges declared by the stage
+	Exchanges []Exchange `yaml:"exchanges" json:"exchanges"`
+}
+
+type Exchange struct {
+	Name string `yaml:"name" json:"name"`
+	// Either direct or fanout, defaults to direct
+	Type string `yaml:"type" json:"type"`
+	// Queues bound to each routing key
+	Routes map[string][]string `yaml:"routes" json:"routes"`
+}
+
+// Returns the pipeline embedded in the binaries
+func Default() (Spec, error) {
+	return Parse(defaultSpec, ".yaml")
+}
+
+// Loads the pipeline from a YAML or JSON file, chosen by its extension.
+// Without a path, the default pipeline is returned
+func Load(path string) (Spec, error) {
+	if path == "" {
+		return Default()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Spec{}, err
+	}
+	return Parse(data, filepath.Ext(path))
+}
+
+func Parse(data []byte, ext string) (Spec, error) {
+	var s Spec
+	var err error
+	if ext == ".json" {
+		err = json.Unmarshal(data, &s)
+	} else {
+		err = yaml.Unmarshal(data, &s)
+	}
+	if err != nil {
+		return s, fmt.Errorf("%w: %w", ErrInvalidSpec, err)
+	}
+	return s, s.Validate()
+}
+
+// Checks that stages are unique and that their references exist
+func (s Spec) Validate() error {
+	names := make(map[string]bool)
+	for _, stage := range s.Stages {
+		if stage.Name == "" || stage.Binary == "" {
+			return fmt.Errorf("%w: stages must have a name and a binary", ErrInvalidSpec)
+		}
+		if names[stage.Name] {
+			return fmt.Errorf("%w: duplicated stage %v", ErrInvalidSpec, stage.Name)
+		}
+		names[stage.Name] = true
+		if stage.Replicas < 0 {
+			return fmt.Errorf("%w: stage %v has negative replicas", ErrInvalidSpec, stage.Name)
+		}
+		for _, exchange := range stage.Exchanges {
+			if exchange.Name == "" {
+				return fmt.Errorf("%w: stage %v has an exchange without name", ErrInvalidSpec, stage.Name)
+			}
+			if exchange.Type != "" && exchange.Type != amqp.ExchangeDirect && exchange.Type != amqp.ExchangeFanout {
+				return fmt.Errorf("%w: exchange %v has unknown type %q", ErrInvalidSpec, exchange.Name, exchange.Type)
+			}
+		}
+	}
+
+	for _, stage := range s.Stages {
+		if stage.Partitions == "" {
+			continue
+		}
+		if _, err := s.Partitions(stage); err != nil {
+			return fmt.Errorf("%w: stage %v: %w", ErrInvalidSpec, stage.Name, err)
+		}
+	}
+
+	return s.checkPartitions()
+}
+
+// Checks that the stages writing and reading each queue agree on its amount
+// of partitions, so that no partition is left without a consumer
+func (s Spec) checkPartitions() error {
+	for _, writer := range s.Stages {
+		for _, queue := range writer.Outputs {
+			for _, reader := range s.Stages {
+				if !reader.reads(queue) {
+					continue
+				}
+				written := s.partitionsOf(writer, queue, Stage.reads)
+				read := s.partitionsOf(reader, queue, Stage.writes)
+				if written != read {
+					return partitionsMismatch(queue, writer.Name, written, reader.Name, read)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Describes why the ends of a queue disagree. An unreplicated stage uses a
+// single queue without partitions, so the other end can't be replicated
+func partitionsMismatch(queue string, writer string, written int, reader string, read int) error {
+	switch {
+	case read == 0:
+		return fmt.Errorf(
+			"%w: %v is not replicated and reads queue %v as a single queue, so %v can't write it in %v partitions",
+			ErrInvalidSpec, reader, queue, writer, written,
+		)
+	case written == 0:
+		return fmt.Errorf(
+			"%w: %v is not replicated and writes queue %v as a single queue, so %v can't read it in %v partitions",
+			ErrInvalidSpec, writer, queue, reader, read,
+		)
+	default:
+		return fmt.Errorf(
+			"%w: queue %v is written in %v partitions by %v, but read in %v by %v",
+			ErrInvalidSpec, queue, written, writer, read, reader,
+		)
+	}
+}
+
+// Amount of partitions in which the stage uses the queue. When the stage is
+// partitioned by the other end of the queue, they agree by definition.
+// Otherwise, each instance uses its own partition
+func (s Spec) partitionsOf(stage Stage, queue string, otherEnd func(Stage, string) bool) int {
+	if partitioned, err := s.Stage(stage.Partitions); err == nil && otherEnd(partitioned, queue) {
+		return max(partitioned.Replicas, 1)
+	}
+	return stage.Replicas
+}
+
+func (s Stage) reads(queue string) bool {
+	return slices.Contains(slices.Collect(maps.Values(s.Inputs)), queue)
+}
+
+func (s Stage) writes(queue string) bool {
+	return slices.Contains(slices.Collect(maps.Values(s.Outputs)), queue)
+}
+
+// Returns a copy of the pipeline with the replicas of the given stages
+// replaced, failing if the result is not valid
+func (s Spec) WithReplicas(replicas map[string]int) (Spec, error) {
+	stages := slices.Clone(s.Stages)
+	for name, n := range replicas {
+		i := slices.IndexFunc(stages, func(stage Stage) bool { return stage.Name == name })
+		if i < 0 {
+			return s, fmt.Errorf("%w %q", ErrUnknownStage, name)
+		}
+		stages[i].Replicas = n
+	}
+	spec := Spec{Stages: stages}
+	return spec, spec.Validate()
+}
+
+func (s Spec) Stage(name string) (Stage, error) {
+	i := slices.IndexFunc(s.Stages, func(stage Stage) bool { return stage.Name == name })
+	if i < 0 {
+		return Stage{}, fmt.Errorf("%w %q", ErrUnknownStage, name)
+	}
+	return s.Stages[i], nil
+}
+
+// Returns the amount of partitions of the given stage
+func (s Spec) Partitions(stage Stage) (int, error) {
+	partitioned, err := s.Stage(stage.Partitions)
+	if err != nil {
+		return 0, err
+	}
+	return max(partitioned.Replicas, 1), nil
+}
+
+// Names of the instances of the stage, as deployed
+func (s Stage) Instances() []string {
+	if s.Replicas == 0 {
+		return []string{s.Name}
+	}
+	instances := make([]string, 0, s.Replicas)
+	for i := 1; i <= s.Replicas; i++ {
+		instances = append(instances, fmt.Sprintf("%v-%v", s.Name, i))
+	}
+	return instances
+}
+
+func (s Stage) Input(role string) (string, error) {
+	queue, ok := s.Inputs[role]
+	if !ok || queue == "" {
+		return "", fmt.Errorf("%w: stage %v has no input %q", ErrMissingQueue, s.Name, role)
+	}
+	return queue, nil
+}
+
+func (s Stage) Output(role string) (string, error) {
+	queue, ok := s.Outputs[role]
+	if !ok || queue == "" {
+		return "", fmt.Errorf("%w: stage %v has no output %q", ErrMissingQueue, s.Name, role)
+	}
+	return queue, nil
+}
+
+// Queues bound to the given exchanges of the stage, or to all of them if
+// none is given, in order of appearance
+func (s Stage) RoutedQueues(exchanges ...string) []string {
+	queues := make([]string, 0)
+	for _, exchange := range s.Exchanges {
+		if len(exchanges) > 0 && !slices.Contains(exchanges, exchange.Name) {
+			continue
+		}
+		for _, key := range exchange.keys() {
+			for _, queue := range exchange.Routes[key] {
+				if !slices.Contains(queues, queue) {
+					queues = append(queues, queue)
+				}
+			}
+		}
+	}
+	return queues
+}
+
+func (e Exchange) kind() string {
+	if e.Type == "" {
+		return amqp.ExchangeDirect
+	}
+	return e.Type
+}
+
+// Routing keys, sorted to declare bindings deterministically
+func (e Exchange) keys() []string {
+	keys := make([]string, 0, len(e.Routes))
+	for key := range e.Routes {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Loads the pipeline at the given path, or the default one, and returns
+// it along with the requested stage
+func LoadStage(path string, name string) (Spec, Stage, error) {
+	spec, err := Load(path)
+	if err != nil {
+		return spec, Stage{}, err
+	}
+	stage, err := spec.Stage(name)
+	return spec, stage, err
+}
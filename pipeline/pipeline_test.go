@@ -0,0 +1,205 @@
+package pipeline_test
+
+import (
+	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/pipeline"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const SPEC = `{
+	"stages": [
+		{
+			"name": "source",
+			"binary": "gateway",
+			"outputs": {"games": "games-x"},
+			"exchanges": [
+				{"name": "games-x", "type": "fanout", "routes": {"": ["games"]}}
+			]
+		},
+		{
+			"name": "filter",
+			"binary": "filter-genre",
+			"replicas": 2,
+			"inputs": {"input": "games"},
+			"exchanges": [
+				{"name": "filter-x", "routes": {"a": ["out-a", "out-ab"], "b": ["out-ab"]}}
+			]
+		},
+		{
+			"name": "partitioner",
+			"binary": "partitioner",
+			"partitions": "filter",
+			"inputs": {"input": "out-a"},
+			"outputs": {"output": "partitioned"}
+		}
+	]
+}`
+
+func TestDefault(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatalf("Failed to load default pipeline: %v", err)
+	}
+
+	for _, name := range []string{"gateway", "genre-filter", "q1-joiner", "q5-percentile"} {
+		if _, err := spec.Stage(name); err != nil {
+			t.Fatalf("Expected stage %v: %v", name, err)
+		}
+	}
+
+	keys := map[string][]string{
+		"genre-filter":    {middleware.IndieKey, middleware.ActionKey},
+		"review-filter":   {middleware.PositiveKey, middleware.NegativeKey},
+		"language-filter": {middleware.EnglishKey},
+		"q4-filter":       {middleware.KeyQ4},
+	}
+	for name, k := range keys {
+		stage, err := spec.Stage(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stage.Filter("rabbitmq", k...); err != nil {
+			t.Fatalf("Invalid filter %v: %v", name, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	spec, err := pipeline.Parse([]byte(SPEC), ".json")
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+
+	filter, err := spec.Stage("filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instances := filter.Instances(); !reflect.DeepEqual(instances, []string{"filter-1", "filter-2"}) {
+		t.Fatalf("Unexpected instances %v", instances)
+	}
+
+	partitioner, err := spec.Stage("partitioner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitions, err := spec.Partitions(partitioner)
+	if err != nil || partitions != 2 {
+		t.Fatalf("Expected 2 partitions, got %v (%v)", partitions, err)
+	}
+	if _, err := partitioner.Output("missing"); !errors.Is(err, pipeline.ErrMissingQueue) {
+		t.Fatalf("Expected missing queue error, got %v", err)
+	}
+
+	if _, err := spec.Stage("unknown"); !errors.Is(err, pipeline.ErrUnknownStage) {
+		t.Fatalf("Expected unknown stage error, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []string{
+		`stages: [{name: a, binary: x}, {name: a, binary: x}]`,
+		`stages: [{name: a, binary: x, partitions: b}]`,
+		`stages: [{name: a, binary: x, exchanges: [{name: e, type: topic}]}]`,
+		`stages: [{name: a}]`,
+	}
+	for _, data := range invalid {
+		if _, err := pipeline.Parse([]byte(data), ".yaml"); !errors.Is(err, pipeline.ErrInvalidSpec) {
+			t.Fatalf("Expected %q to be invalid, got %v", data, err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	spec, err := pipeline.Parse([]byte(SPEC), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := spec.Stage("filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := filter.Filter("rabbitmq", "a", "b")
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+	if config.Queue != "games" || config.Exchange != "filter-x" {
+		t.Fatalf("Unexpected filter config %v", config)
+	}
+
+	if _, err := filter.Filter("rabbitmq", "c"); !errors.Is(err, pipeline.ErrMissingQueue) {
+		t.Fatalf("Expected unrouted key to fail, got %v", err)
+	}
+}
+
+func TestTopology(t *testing.T) {
+	spec, err := pipeline.Parse([]byte(SPEC), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := spec.Stage("filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := middleware.Topology{
+		Exchanges: []middleware.ExchangeConfig{{Name: "filter-x", Type: "direct"}},
+		Queues: []middleware.QueueConfig{
+			{Name: "out-a", Bindings: map[string][]string{"filter-x": {"a"}}},
+			{Name: "out-ab", Bindings: map[string][]string{"filter-x": {"a", "b"}}},
+		},
+	}
+	if topology := filter.Topology(); !reflect.DeepEqual(topology, expected) {
+		t.Fatalf("Expected %v, got %v", expected, topology)
+	}
+	if queues := filter.RoutedQueues("other-x"); len(queues) != 0 {
+		t.Fatalf("Expected no queues, got %v", queues)
+	}
+}
+
+func TestWithReplicas(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scaled, err := spec.WithReplicas(map[string]int{"q3-group": 5, "q3-top": 5})
+	if err != nil {
+		t.Fatalf("Failed to scale pipeline: %v", err)
+	}
+	partitioner, err := scaled.Stage("q3-games-partitioner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partitions, _ := scaled.Partitions(partitioner); partitions != 5 {
+		t.Fatalf("Expected 5 partitions, got %v", partitions)
+	}
+	if group, _ := spec.Stage("q3-group"); group.Replicas != 3 {
+		t.Fatalf("Original pipeline was modified")
+	}
+
+	_, err = spec.WithReplicas(map[string]int{"q3-group": 5})
+	if !errors.Is(err, pipeline.ErrInvalidSpec) {
+		t.Fatalf("Expected mismatched partitions to fail, got %v", err)
+	}
+
+	// the joiner can't be replicated, as the gateway reads a single queue
+	_, err = spec.WithReplicas(map[string]int{"q1-count": 5, "q1-joiner": 5})
+	if !errors.Is(err, pipeline.ErrInvalidSpec) {
+		t.Fatalf("Expected replicated joiner to fail, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "gateway is not replicated") {
+		t.Fatalf("Expected the error to name the unreplicated reader, got %v", err)
+	}
+	if _, err = spec.WithReplicas(map[string]int{"q1-count": 5}); err != nil {
+		t.Fatalf("Failed to scale the stage read by the joiner: %v", err)
+	}
+
+	_, err = spec.WithReplicas(map[string]int{"unknown": 1})
+	if !errors.Is(err, pipeline.ErrUnknownStage) {
+		t.Fatalf("Expected unknown stage to fail, got %v", err)
+	}
+}
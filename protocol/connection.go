@@ -1,19 +1,41 @@
 package protocol
 
 import (
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"io"
+	"reflect"
 )
 
+// Every message exchanged between the client and the gateway is sent as a
+// length prefixed frame, with the following layout (all integers are
+// little endian):
+//
+//	+------------+----------+-------------------+
+//	| length u32 | type u8  | payload           |
+//	+------------+----------+-------------------+
+//
+// The length counts the type byte and the payload, but not itself. The
+// payload layout of each message is documented in its Encode method.
+const FRAME_HEADER_SIZE = 5
+
+// Frames bigger than this are rejected, to avoid allocating arbitrary
+// amounts of memory on corrupted or malicious input.
+const MAX_FRAME_SIZE = 64 << 20
+
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+var ErrUnknownMessage = errors.New("unknown message type")
+var ErrUnexpectedMessage = errors.New("unexpected message type")
+
 type Conn struct {
 	conn io.ReadWriteCloser
-	enco *gob.Encoder
-	deco *gob.Decoder
 }
 
+// Registers result types to be sent through the middleware, which
+// still uses gob serialization internally.
 func Register() {
-	gob.Register(Batch{})
-	gob.Register(Finish{})
 	gob.Register(Q1Result{})
 	gob.Register(Q2Result{})
 	gob.Register(Q3Result{})
@@ -25,28 +47,85 @@ func Register() {
 func NewConn(conn io.ReadWriteCloser) *Conn {
 	return &Conn{
 		conn: conn,
-		enco: gob.NewEncoder(conn),
-		deco: gob.NewDecoder(conn),
 	}
 }
 
 // Send as interface, can be decoded as interface
 //
-// Used when receiver expects different type of messages
+// Kept for compatibility, as every frame carries its type it is equivalent to Send
 func (c *Conn) SendAny(msg any) error {
-	return c.enco.Encode(&msg)
+	return c.Send(msg)
 }
 
-// Send as concrete, can be decoded as concrete
-//
-// Used when receiver expects only this specific type
+// Sends a message, either by value or by reference. It can be decoded
+// both as its concrete type or as an interface it implements
 func (c *Conn) Send(msg any) error {
-	return c.enco.Encode(msg)
+	m, ok := reflect.Indirect(reflect.ValueOf(msg)).Interface().(Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUnknownMessage, msg)
+	}
+
+	buf := make([]byte, FRAME_HEADER_SIZE, FRAME_HEADER_SIZE+64)
+	buf[4] = byte(m.Type())
+	buf, err := m.Encode(buf)
+	if err != nil {
+		return err
+	}
+	if len(buf)-4 > MAX_FRAME_SIZE {
+		return ErrFrameTooLarge
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)-4))
+
+	_, err = c.conn.Write(buf)
+	return err
 }
 
 // Must receive pointer to concrete or interface
+//
+// Fails with ErrUnexpectedMessage if the received message can't be
+// assigned to the given pointer
 func (c *Conn) Recv(msg any) error {
-	return c.deco.Decode(msg)
+	dst := reflect.ValueOf(msg)
+	if dst.Kind() != reflect.Pointer || dst.IsNil() {
+		return fmt.Errorf("expected non nil pointer, got %T", msg)
+	}
+
+	m, err := c.recvMessage()
+	if err != nil {
+		return err
+	}
+
+	src := reflect.ValueOf(m)
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return fmt.Errorf("%w: expected %v, received %v", ErrUnexpectedMessage, dst.Elem().Type(), src.Type())
+	}
+	dst.Elem().Set(src)
+
+	return nil
+}
+
+func (c *Conn) recvMessage() (Message, error) {
+	var header [FRAME_HEADER_SIZE]byte
+	_, err := io.ReadFull(c.conn, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length == 0 {
+		return nil, fmt.Errorf("%w: empty frame", ErrUnknownMessage)
+	}
+	if length > MAX_FRAME_SIZE {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, length-1)
+	_, err = io.ReadFull(c.conn, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(MsgType(header[4]), payload)
 }
 
 func (c *Conn) Close() error {
@@ -2,8 +2,10 @@ package protocol_test
 
 import (
 	"bytes"
+	"distribuidos/tp1/middleware"
 	"distribuidos/tp1/protocol"
-	"encoding/gob"
+	"encoding/binary"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -17,17 +19,13 @@ func (b *BufferCloser) Close() error {
 }
 
 func TestConnAny(t *testing.T) {
-	gob.Register(protocol.RequestHello{})
-	gob.Register(protocol.AcceptRequest{})
-	gob.Register(protocol.DataHello{})
-	gob.Register(protocol.DataAccept{})
-	gob.Register(protocol.Batch{})
-	gob.Register(protocol.Finish{})
-
 	messages := []any{
 		protocol.RequestHello{
-			GameSize:   1,
-			ReviewSize: 2,
+			Version:       protocol.ProtocolVersion,
+			GameSize:      1,
+			ReviewSize:    2,
+			GamesFormat:   protocol.FormatCSVGzip,
+			ReviewsFormat: protocol.FormatJSONL,
 		},
 		protocol.RequestHello{
 			GameSize:   3,
@@ -35,13 +33,71 @@ func TestConnAny(t *testing.T) {
 		},
 		protocol.AcceptRequest{
 			ClientID: 5,
+			Version:  protocol.ProtocolVersion,
 		},
 		protocol.AcceptRequest{
 			ClientID: 6,
 		},
+		protocol.Reject{
+			Reason: "unsupported",
+		},
+		protocol.Busy{
+			RetryAfter: 10,
+		},
+		protocol.Progress{
+			BytesReceived:   1 << 40,
+			BatchesReceived: 2,
+			GamesParsed:     3,
+			GamesRejected:   4,
+			ReviewsParsed:   5,
+			ReviewsRejected: 6,
+			FinishedStages:  []string{"games-genre", "reviews-Q4"},
+		},
+		protocol.Progress{
+			FinishedStages: []string{},
+		},
+		protocol.ValidationReport{
+			Rejections: []protocol.RejectionCount{
+				{File: "games", Reason: "empty name", Count: 10},
+				{File: "reviews", Reason: "empty text", Count: 20},
+			},
+			Samples: []protocol.RejectedRow{
+				{File: "games", Line: 3, Reason: "empty name", Record: "1,,Jan 1, 2000"},
+			},
+		},
+		protocol.ValidationReport{
+			Rejections: []protocol.RejectionCount{},
+			Samples:    []protocol.RejectedRow{},
+		},
+		protocol.StatusRequest{
+			ClientID: 3,
+		},
+		protocol.JobStatus{
+			ClientID:        3,
+			State:           protocol.JobRunning,
+			FinishedQueries: []uint8{1, 4},
+			Progress: protocol.Progress{
+				BytesReceived:  10,
+				FinishedStages: []string{"games-Q1"},
+			},
+		},
+		protocol.JobStatus{
+			State:           protocol.JobUnknown,
+			FinishedQueries: []uint8{},
+			Progress: protocol.Progress{
+				FinishedStages: []string{},
+			},
+		},
+		protocol.FetchRequest{
+			ClientID: 3,
+		},
 		protocol.DataHello{
 			ClientID: 7,
 		},
+		protocol.DataHello{
+			ClientID: 8,
+			Stream:   protocol.StreamReviews,
+		},
 		protocol.DataAccept{},
 		protocol.Batch{Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
 		protocol.Finish{},
@@ -93,3 +149,131 @@ func TestConn(t *testing.T) {
 		t.Fatalf("expected %v, but received %v", msg, recv_msg)
 	}
 }
+
+func TestConnResults(t *testing.T) {
+	stats := []middleware.GameStat{
+		{AppID: 1, Name: "game1", Stat: 10},
+		{AppID: 2, Name: "", Stat: 20},
+		{AppID: 3, Name: "juego ñ", Stat: 30},
+	}
+
+	results := []protocol.Result{
+		protocol.Q1Result{Windows: 1, Linux: 2, Mac: 3},
+		protocol.Q2Result{TopN: stats},
+		protocol.Q3Result{TopN: stats[:1]},
+		protocol.Q4Result{Games: stats},
+		protocol.Q4Result{Games: []middleware.GameStat{}},
+		protocol.Q5Result{Percentile90: stats},
+		protocol.Q4Finish{},
+	}
+
+	var b BufferCloser
+	conn := protocol.NewConn(&b)
+
+	for _, result := range results {
+		err := conn.SendAny(&result)
+		if err != nil {
+			t.Fatalf("failed to send result %v", err)
+		}
+
+		var recv_result protocol.Result
+		err = conn.Recv(&recv_result)
+		if err != nil {
+			t.Fatalf("failed to receive result %v", err)
+		}
+
+		if !reflect.DeepEqual(result, recv_result) {
+			t.Fatalf("expected %v, but received %v", result, recv_result)
+		}
+	}
+}
+
+func TestConnRequestHelloV1(t *testing.T) {
+	// version 1 clients don't send formats
+	frame := []byte{19, 0, 0, 0, byte(protocol.RequestHelloMsg), 1, 0}
+	frame = binary.LittleEndian.AppendUint64(frame, 3)
+	frame = binary.LittleEndian.AppendUint64(frame, 4)
+
+	var b BufferCloser
+	b.Write(frame)
+	conn := protocol.NewConn(&b)
+
+	var hello protocol.RequestHello
+	err := conn.Recv(&hello)
+	if err != nil {
+		t.Fatalf("failed to receive message %v", err)
+	}
+
+	expected := protocol.RequestHello{Version: 1, GameSize: 3, ReviewSize: 4}
+	if !reflect.DeepEqual(expected, hello) {
+		t.Fatalf("expected %v, but received %v", expected, hello)
+	}
+}
+
+func TestConnFrameLayout(t *testing.T) {
+	var b BufferCloser
+	conn := protocol.NewConn(&b)
+
+	err := conn.Send(protocol.Batch{Data: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatalf("failed to send message %v", err)
+	}
+
+	expected := []byte{4, 0, 0, 0, byte(protocol.BatchMsg), 1, 2, 3}
+	if !bytes.Equal(b.Bytes(), expected) {
+		t.Fatalf("expected %v, but got %v", expected, b.Bytes())
+	}
+}
+
+func TestConnUnexpectedMessage(t *testing.T) {
+	var b BufferCloser
+	conn := protocol.NewConn(&b)
+
+	err := conn.Send(protocol.Finish{})
+	if err != nil {
+		t.Fatalf("failed to send message %v", err)
+	}
+
+	var hello protocol.RequestHello
+	err = conn.Recv(&hello)
+	if !errors.Is(err, protocol.ErrUnexpectedMessage) {
+		t.Fatalf("expected unexpected message error, but got %v", err)
+	}
+}
+
+func TestConnInvalidFrames(t *testing.T) {
+	frames := map[string][]byte{
+		"unknown type": {1, 0, 0, 0, '?'},
+		"short hello":  {3, 0, 0, 0, byte(protocol.RequestHelloMsg), 1, 0},
+		"empty frame":  {0, 0, 0, 0, 0},
+		"too large":    binary.LittleEndian.AppendUint32(nil, protocol.MAX_FRAME_SIZE+1),
+	}
+
+	for name, frame := range frames {
+		var b BufferCloser
+		b.Write(frame)
+		b.Write([]byte{0})
+		conn := protocol.NewConn(&b)
+
+		var msg any
+		err := conn.Recv(&msg)
+		if err == nil {
+			t.Fatalf("%v: expected error, but received %v", name, msg)
+		}
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	version, err := protocol.NegotiateVersion(protocol.ProtocolVersion + 1)
+	if err != nil {
+		t.Fatalf("failed to negotiate version %v", err)
+	}
+	if version != protocol.ProtocolVersion {
+		t.Fatalf("expected version %v, but got %v", protocol.ProtocolVersion, version)
+	}
+
+	_, err = protocol.NegotiateVersion(protocol.MinProtocolVersion - 1)
+	if !errors.Is(err, protocol.ErrUnsupportedVersion) {
+		t.Fatalf("expected unsupported version error, but got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"distribuidos/tp1/middleware"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrShortPayload = errors.New("payload is shorter than expected")
+
+func appendUint16(buf []byte, v uint16) []byte { return binary.LittleEndian.AppendUint16(buf, v) }
+func appendUint32(buf []byte, v uint32) []byte { return binary.LittleEndian.AppendUint32(buf, v) }
+func appendUint64(buf []byte, v uint64) []byte { return binary.LittleEndian.AppendUint64(buf, v) }
+
+// Strings are encoded as: length u32, utf-8 bytes
+func appendString(buf []byte, s string) []byte {
+	buf = appendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// Game stats are encoded as: count u32, followed by each stat
+//
+// Each stat is encoded as: app id u64, stat u64, name string
+func encodeGameStats(buf []byte, stats []middleware.GameStat) []byte {
+	buf = appendUint32(buf, uint32(len(stats)))
+	for _, s := range stats {
+		buf = appendUint64(buf, s.AppID)
+		buf = appendUint64(buf, s.Stat)
+		buf = appendString(buf, s.Name)
+	}
+	return buf
+}
+
+func decodeGameStats(buf []byte) ([]middleware.GameStat, error) {
+	d := decoder{buf: buf}
+	count := d.uint32()
+
+	stats := make([]middleware.GameStat, 0)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		var s middleware.GameStat
+		s.AppID = d.uint64()
+		s.Stat = d.uint64()
+		s.Name = d.string()
+		stats = append(stats, s)
+	}
+
+	return stats, d.err
+}
+
+// Reads consecutive fields from a payload. After the first failure,
+// every read returns the zero value and the error is kept in err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = ErrShortPayload
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (d *decoder) string() string {
+	n := d.uint32()
+	b := d.take(int(n))
+	if b == nil {
+		return ""
+	}
+	return string(b)
+}
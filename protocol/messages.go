@@ -2,25 +2,237 @@ package protocol
 
 import (
 	"distribuidos/tp1/middleware"
+	"errors"
+	"fmt"
 	"strconv"
 )
 
+// Version of the protocol implemented by this package. The client sends it
+// in the RequestHello, and the gateway answers with the negotiated version
+const ProtocolVersion = ValidationVersion
+
+// Version history, each version is named after the feature it introduced
+const (
+	// initial version
+	InitialVersion uint16 = 1
+	// input formats in RequestHello
+	FormatsVersion uint16 = 2
+	// stream in DataHello, to upload each file through its own connection
+	StreamsVersion uint16 = 3
+	// status and fetch requests, to query requests by id
+	QueryVersion uint16 = 4
+	// progress reports while the request is processed
+	ProgressVersion uint16 = 5
+	// validation report, with the rows rejected while ingesting
+	ValidationVersion uint16 = 6
+)
+
+// Oldest version of the protocol still supported
+const MinProtocolVersion = InitialVersion
+
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// Returns the version to use with a peer that supports up to the given version
+func NegotiateVersion(version uint16) (uint16, error) {
+	if version < MinProtocolVersion {
+		return 0, fmt.Errorf("%w: %v, minimum is %v", ErrUnsupportedVersion, version, MinProtocolVersion)
+	}
+	return min(version, ProtocolVersion), nil
+}
+
+type Message interface {
+	Encode(buf []byte) ([]byte, error)
+	Type() MsgType
+}
+
+type MsgType uint8
+
+const (
+	RequestHelloMsg  MsgType = 'H'
+	AcceptRequestMsg MsgType = 'A'
+	RejectMsg        MsgType = 'X'
+	BusyMsg          MsgType = 'Y'
+	ProgressMsg      MsgType = 'P'
+	ValidationMsg    MsgType = 'V'
+	StatusRequestMsg MsgType = 'S'
+	JobStatusMsg     MsgType = 'J'
+	FetchRequestMsg  MsgType = 'G'
+	DataHelloMsg     MsgType = 'D'
+	DataAcceptMsg    MsgType = 'O'
+	BatchMsg         MsgType = 'B'
+	FinishMsg        MsgType = 'F'
+	Q1ResultMsg      MsgType = '1'
+	Q2ResultMsg      MsgType = '2'
+	Q3ResultMsg      MsgType = '3'
+	Q4ResultMsg      MsgType = '4'
+	Q5ResultMsg      MsgType = '5'
+	Q4FinishMsg      MsgType = 'f'
+)
+
+// Decodes the payload of a frame of the given type
+func Decode(ty MsgType, buf []byte) (Message, error) {
+	switch ty {
+	case RequestHelloMsg:
+		return DecodeRequestHello(buf)
+	case AcceptRequestMsg:
+		return DecodeAcceptRequest(buf)
+	case RejectMsg:
+		return DecodeReject(buf)
+	case BusyMsg:
+		return DecodeBusy(buf)
+	case ProgressMsg:
+		return DecodeProgress(buf)
+	case ValidationMsg:
+		return DecodeValidationReport(buf)
+	case StatusRequestMsg:
+		return DecodeStatusRequest(buf)
+	case JobStatusMsg:
+		return DecodeJobStatus(buf)
+	case FetchRequestMsg:
+		return DecodeFetchRequest(buf)
+	case DataHelloMsg:
+		return DecodeDataHello(buf)
+	case DataAcceptMsg:
+		return DataAccept{}, nil
+	case BatchMsg:
+		return Batch{Data: buf}, nil
+	case FinishMsg:
+		return Finish{}, nil
+	case Q1ResultMsg:
+		return DecodeQ1Result(buf)
+	case Q2ResultMsg:
+		stats, err := decodeGameStats(buf)
+		return Q2Result{TopN: stats}, err
+	case Q3ResultMsg:
+		stats, err := decodeGameStats(buf)
+		return Q3Result{TopN: stats}, err
+	case Q4ResultMsg:
+		stats, err := decodeGameStats(buf)
+		return Q4Result{Games: stats}, err
+	case Q5ResultMsg:
+		stats, err := decodeGameStats(buf)
+		return Q5Result{Percentile90: stats}, err
+	case Q4FinishMsg:
+		return Q4Finish{}, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownMessage, ty)
+}
+
+// Formats of the input files. An empty format is equivalent to FormatCSV
+const (
+	FormatCSV       = "csv"
+	FormatCSVGzip   = "csv.gz"
+	FormatJSONL     = "jsonl"
+	FormatJSONLGzip = "jsonl.gz"
+)
+
 // Sent by the client to initiate a request
 type RequestHello struct {
-	GameSize   uint64
-	ReviewSize uint64
+	Version       uint16
+	GameSize      uint64
+	ReviewSize    uint64
+	GamesFormat   string
+	ReviewsFormat string
 }
 
 // Sent by the connection handler to accept a client's request
 type AcceptRequest struct {
 	ClientID uint64
+	Version  uint16
+}
+
+// Sent by the connection handler to reject a client's request
+type Reject struct {
+	Reason string
+}
+
+// Sent by the connection handler when it can't admit more clients. The
+// client should try again after the given amount of seconds
+type Busy struct {
+	RetryAfter uint32
+}
+
+// Sent periodically by the connection handler, while processing a client's request
+type Progress struct {
+	BytesReceived   uint64
+	BatchesReceived uint64
+	GamesParsed     uint64
+	GamesRejected   uint64
+	ReviewsParsed   uint64
+	ReviewsRejected uint64
+	// Input queues of the stages that have already reached EOF
+	FinishedStages []string
+}
+
+// Sent by the connection handler once all data has been ingested, with the
+// rows that had to be discarded
+type ValidationReport struct {
+	Rejections []RejectionCount
+	// Only a sample of the rejected rows is sent, see Rejections for the totals
+	Samples []RejectedRow
+}
+
+type RejectionCount struct {
+	File   string
+	Reason string
+	Count  uint64
+}
+
+type RejectedRow struct {
+	File   string
+	Line   uint64
+	Reason string
+	Record string
+}
+
+// Sent by the client instead of a RequestHello, to ask for the status of a
+// previous request
+type StatusRequest struct {
+	ClientID uint64
+}
+
+// State of a request, as known by the connection handler
+type JobState uint8
+
+const (
+	JobUnknown  JobState = 0
+	JobRunning  JobState = 1
+	JobFinished JobState = 2
+	JobFailed   JobState = 3
+)
+
+// Sent by the connection handler in response to a StatusRequest
+type JobStatus struct {
+	ClientID uint64
+	State    JobState
+	// Queries whose results are complete
+	FinishedQueries []uint8
+	Progress        Progress
+}
+
+// Sent by the client instead of a RequestHello, to receive the results of a
+// finished request. Results and report are sent as in the original request,
+// and the client must answer with Finish
+type FetchRequest struct {
+	ClientID uint64
 }
 
 // Data Handler Messages
 
+// Data sent through a data connection
+type DataStream uint8
+
+const (
+	// Games followed by reviews, through the same connection
+	StreamAll     DataStream = 0
+	StreamGames   DataStream = 1
+	StreamReviews DataStream = 2
+)
+
 // Sent by the client to present itself to the data handler
 type DataHello struct {
 	ClientID uint64
+	Stream   DataStream
 }
 
 // Sent by the data handler to accept a client
@@ -34,9 +246,228 @@ type Batch struct {
 // Sent by the client to indicate that it has finished sending data
 type Finish struct{}
 
+// Encode messages
+
+// Payload: version u16, game size u64, review size u64, games format string,
+// reviews format string. Formats are omitted by version 1 clients
+func (h RequestHello) Encode(buf []byte) ([]byte, error) {
+	buf = appendUint16(buf, h.Version)
+	buf = appendUint64(buf, h.GameSize)
+	buf = appendUint64(buf, h.ReviewSize)
+	buf = appendString(buf, h.GamesFormat)
+	return appendString(buf, h.ReviewsFormat), nil
+}
+
+// Payload: client id u64, version u16
+func (a AcceptRequest) Encode(buf []byte) ([]byte, error) {
+	buf = appendUint64(buf, a.ClientID)
+	return appendUint16(buf, a.Version), nil
+}
+
+// Payload: reason string
+func (r Reject) Encode(buf []byte) ([]byte, error) { return appendString(buf, r.Reason), nil }
+
+// Payload: retry after u32 (seconds)
+func (b Busy) Encode(buf []byte) ([]byte, error) { return appendUint32(buf, b.RetryAfter), nil }
+
+// Payload: bytes received u64, batches received u64, games parsed u64,
+// games rejected u64, reviews parsed u64, reviews rejected u64,
+// finished stages count u32, followed by each stage as a string
+func (p Progress) Encode(buf []byte) ([]byte, error) {
+	buf = appendUint64(buf, p.BytesReceived)
+	buf = appendUint64(buf, p.BatchesReceived)
+	buf = appendUint64(buf, p.GamesParsed)
+	buf = appendUint64(buf, p.GamesRejected)
+	buf = appendUint64(buf, p.ReviewsParsed)
+	buf = appendUint64(buf, p.ReviewsRejected)
+	buf = appendUint32(buf, uint32(len(p.FinishedStages)))
+	for _, stage := range p.FinishedStages {
+		buf = appendString(buf, stage)
+	}
+	return buf, nil
+}
+
+// Payload: client id u64
+func (r StatusRequest) Encode(buf []byte) ([]byte, error) { return appendUint64(buf, r.ClientID), nil }
+
+// Payload: client id u64, state u8, finished queries count u32, followed by
+// each query u8, progress (see Progress)
+func (s JobStatus) Encode(buf []byte) ([]byte, error) {
+	buf = appendUint64(buf, s.ClientID)
+	buf = append(buf, byte(s.State))
+	buf = appendUint32(buf, uint32(len(s.FinishedQueries)))
+	buf = append(buf, s.FinishedQueries...)
+	return s.Progress.Encode(buf)
+}
+
+// Payload: client id u64
+func (r FetchRequest) Encode(buf []byte) ([]byte, error) { return appendUint64(buf, r.ClientID), nil }
+
+// Payload: rejections count u32, followed by each rejection (file string,
+// reason string, count u64), samples count u32, followed by each sample
+// (file string, line u64, reason string, record string)
+func (v ValidationReport) Encode(buf []byte) ([]byte, error) {
+	buf = appendUint32(buf, uint32(len(v.Rejections)))
+	for _, r := range v.Rejections {
+		buf = appendString(buf, r.File)
+		buf = appendString(buf, r.Reason)
+		buf = appendUint64(buf, r.Count)
+	}
+	buf = appendUint32(buf, uint32(len(v.Samples)))
+	for _, s := range v.Samples {
+		buf = appendString(buf, s.File)
+		buf = appendUint64(buf, s.Line)
+		buf = appendString(buf, s.Reason)
+		buf = appendString(buf, s.Record)
+	}
+	return buf, nil
+}
+
+// Payload: client id u64, stream u8. The stream is omitted by clients
+// previous to version 3
+func (h DataHello) Encode(buf []byte) ([]byte, error) {
+	buf = appendUint64(buf, h.ClientID)
+	return append(buf, byte(h.Stream)), nil
+}
+
+// Payload: empty
+func (a DataAccept) Encode(buf []byte) ([]byte, error) { return buf, nil }
+
+// Payload: raw file bytes, until the end of the frame
+func (b Batch) Encode(buf []byte) ([]byte, error) { return append(buf, b.Data...), nil }
+
+// Payload: empty
+func (f Finish) Encode(buf []byte) ([]byte, error) { return buf, nil }
+
+// Decode messages
+
+func DecodeRequestHello(buf []byte) (h RequestHello, err error) {
+	d := decoder{buf: buf}
+	h.Version = d.uint16()
+	h.GameSize = d.uint64()
+	h.ReviewSize = d.uint64()
+	if d.err == nil && len(d.buf) > 0 {
+		h.GamesFormat = d.string()
+		h.ReviewsFormat = d.string()
+	}
+	return h, d.err
+}
+
+func DecodeAcceptRequest(buf []byte) (a AcceptRequest, err error) {
+	d := decoder{buf: buf}
+	a.ClientID = d.uint64()
+	a.Version = d.uint16()
+	return a, d.err
+}
+
+func DecodeReject(buf []byte) (r Reject, err error) {
+	d := decoder{buf: buf}
+	r.Reason = d.string()
+	return r, d.err
+}
+
+func DecodeBusy(buf []byte) (b Busy, err error) {
+	d := decoder{buf: buf}
+	b.RetryAfter = d.uint32()
+	return b, d.err
+}
+
+func DecodeProgress(buf []byte) (p Progress, err error) {
+	d := decoder{buf: buf}
+	p.BytesReceived = d.uint64()
+	p.BatchesReceived = d.uint64()
+	p.GamesParsed = d.uint64()
+	p.GamesRejected = d.uint64()
+	p.ReviewsParsed = d.uint64()
+	p.ReviewsRejected = d.uint64()
+	count := d.uint32()
+	p.FinishedStages = make([]string, 0)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		p.FinishedStages = append(p.FinishedStages, d.string())
+	}
+	return p, d.err
+}
+
+func DecodeValidationReport(buf []byte) (v ValidationReport, err error) {
+	d := decoder{buf: buf}
+	count := d.uint32()
+	v.Rejections = make([]RejectionCount, 0)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		var r RejectionCount
+		r.File = d.string()
+		r.Reason = d.string()
+		r.Count = d.uint64()
+		v.Rejections = append(v.Rejections, r)
+	}
+	count = d.uint32()
+	v.Samples = make([]RejectedRow, 0)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		var s RejectedRow
+		s.File = d.string()
+		s.Line = d.uint64()
+		s.Reason = d.string()
+		s.Record = d.string()
+		v.Samples = append(v.Samples, s)
+	}
+	return v, d.err
+}
+
+func DecodeStatusRequest(buf []byte) (r StatusRequest, err error) {
+	d := decoder{buf: buf}
+	r.ClientID = d.uint64()
+	return r, d.err
+}
+
+func DecodeJobStatus(buf []byte) (s JobStatus, err error) {
+	d := decoder{buf: buf}
+	s.ClientID = d.uint64()
+	s.State = JobState(d.uint8())
+	count := d.uint32()
+	s.FinishedQueries = make([]uint8, 0)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		s.FinishedQueries = append(s.FinishedQueries, d.uint8())
+	}
+	if d.err != nil {
+		return s, d.err
+	}
+	s.Progress, err = DecodeProgress(d.buf)
+	return s, err
+}
+
+func DecodeFetchRequest(buf []byte) (r FetchRequest, err error) {
+	d := decoder{buf: buf}
+	r.ClientID = d.uint64()
+	return r, d.err
+}
+
+func DecodeDataHello(buf []byte) (h DataHello, err error) {
+	d := decoder{buf: buf}
+	h.ClientID = d.uint64()
+	if d.err == nil && len(d.buf) > 0 {
+		h.Stream = DataStream(d.uint8())
+	}
+	return h, d.err
+}
+
+// Return message type
+func (h RequestHello) Type() MsgType     { return RequestHelloMsg }
+func (a AcceptRequest) Type() MsgType    { return AcceptRequestMsg }
+func (r Reject) Type() MsgType           { return RejectMsg }
+func (b Busy) Type() MsgType             { return BusyMsg }
+func (p Progress) Type() MsgType         { return ProgressMsg }
+func (v ValidationReport) Type() MsgType { return ValidationMsg }
+func (r StatusRequest) Type() MsgType    { return StatusRequestMsg }
+func (s JobStatus) Type() MsgType        { return JobStatusMsg }
+func (r FetchRequest) Type() MsgType     { return FetchRequestMsg }
+func (h DataHello) Type() MsgType        { return DataHelloMsg }
+func (a DataAccept) Type() MsgType       { return DataAcceptMsg }
+func (b Batch) Type() MsgType            { return BatchMsg }
+func (f Finish) Type() MsgType           { return FinishMsg }
+
 // Results Messages
 
 type Result interface {
+	Message
 	Header() []string
 	ToCSV() [][]string
 	Number() int
@@ -97,6 +528,39 @@ func (q Q5Result) ToCSV() [][]string { return GameStatsToCSV(q.Percentile90) }
 
 func (q Q4Finish) ToCSV() [][]string { return [][]string{} }
 
+// Payload: windows u64, linux u64, mac u64
+func (q Q1Result) Encode(buf []byte) ([]byte, error) {
+	buf = appendUint64(buf, uint64(q.Windows))
+	buf = appendUint64(buf, uint64(q.Linux))
+	return appendUint64(buf, uint64(q.Mac)), nil
+}
+
+// Payload: list of game stats, see encodeGameStats
+func (q Q2Result) Encode(buf []byte) ([]byte, error) { return encodeGameStats(buf, q.TopN), nil }
+func (q Q3Result) Encode(buf []byte) ([]byte, error) { return encodeGameStats(buf, q.TopN), nil }
+func (q Q4Result) Encode(buf []byte) ([]byte, error) { return encodeGameStats(buf, q.Games), nil }
+func (q Q5Result) Encode(buf []byte) ([]byte, error) {
+	return encodeGameStats(buf, q.Percentile90), nil
+}
+
+// Payload: empty
+func (q Q4Finish) Encode(buf []byte) ([]byte, error) { return buf, nil }
+
+func DecodeQ1Result(buf []byte) (q Q1Result, err error) {
+	d := decoder{buf: buf}
+	q.Windows = int(d.uint64())
+	q.Linux = int(d.uint64())
+	q.Mac = int(d.uint64())
+	return q, d.err
+}
+
+func (q Q1Result) Type() MsgType { return Q1ResultMsg }
+func (q Q2Result) Type() MsgType { return Q2ResultMsg }
+func (q Q3Result) Type() MsgType { return Q3ResultMsg }
+func (q Q4Result) Type() MsgType { return Q4ResultMsg }
+func (q Q5Result) Type() MsgType { return Q5ResultMsg }
+func (q Q4Finish) Type() MsgType { return Q4FinishMsg }
+
 func GameStatsToCSV(s []middleware.GameStat) [][]string {
 	res := make([][]string, 0)
 	for _, s := range s {
@@ -0,0 +1,267 @@
+// Package reference solves all queries in a single process, without the
+// middleware. Its results are used as ground truth for the distributed pipeline
+package reference
+
+import (
+	"cmp"
+	"distribuidos/tp1/dataset"
+	"distribuidos/tp1/language"
+	"distribuidos/tp1/middleware"
+	"distribuidos/tp1/results"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const (
+	// Q2: top games by average playtime, among indie games of the decade
+	TOP_PLAYTIME = 10
+	DECADE       = 2010
+	// Q3: top indie games by positive reviews
+	TOP_REVIEWS = 5
+	// Q4: action games with more English negative reviews than this
+	MIN_ENGLISH_REVIEWS = 5000
+	// Q5: action games whose negative reviews reach this percentile
+	PERCENTILE = 90
+)
+
+type Options struct {
+	// Formats of the input files, empty for CSV
+	GamesFormat   string
+	ReviewsFormat string
+	// Workers used to parse records and detect their language. Defaults to the number of CPUs
+	Workers int
+	// Detects if a review is written in English. Defaults to the detector of the language filter
+	IsEnglish func(text string) bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.IsEnglish == nil {
+		detector := language.NewDetector()
+		o.IsEnglish = func(text string) bool {
+			return language.IsEnglish(detector, text)
+		}
+	}
+	return o
+}
+
+// Games involved in the queries that join them with their reviews
+type gameIndex struct {
+	indie  map[uint64]string
+	action map[uint64]string
+}
+
+// Review counts of each game, by query
+type reviewCounts struct {
+	positiveIndie   map[uint64]uint64
+	negativeAction  map[uint64]uint64
+	englishNegative map[uint64]uint64
+}
+
+// A parsed review, with its language already detected when relevant
+type reviewRecord struct {
+	review  middleware.Review
+	english bool
+}
+
+// Solves all queries. Records are parsed as the gateway does, and invalid
+// ones are skipped
+func Solve(games io.Reader, reviews io.Reader, opts Options) (results.Results, error) {
+	opts = opts.withDefaults()
+
+	r, index, err := readGames(games, opts)
+	if err != nil {
+		return r, fmt.Errorf("failed to read games: %w", err)
+	}
+	counts, err := readReviews(reviews, index, opts)
+	if err != nil {
+		return r, fmt.Errorf("failed to read reviews: %w", err)
+	}
+
+	r.Q3 = top(toStats(counts.positiveIndie, index.indie, nil), TOP_REVIEWS)
+	r.Q4 = toStats(counts.englishNegative, index.action, func(count uint64) bool {
+		return count > MIN_ENGLISH_REVIEWS
+	})
+	threshold := percentile(counts.negativeAction, PERCENTILE)
+	r.Q5 = toStats(counts.negativeAction, index.action, func(count uint64) bool {
+		return float64(count) >= threshold
+	})
+
+	return r, nil
+}
+
+// Solves Q1 and Q2, and indexes the games needed by the other queries
+func readGames(r io.Reader, opts Options) (results.Results, gameIndex, error) {
+	res := results.Results{Q1: map[string]float64{"Linux": 0, "Mac": 0, "Windows": 0}}
+	index := gameIndex{
+		indie:  make(map[uint64]string),
+		action: make(map[uint64]string),
+	}
+	decade := make([]results.Stat, 0)
+
+	reader, mapping, err := openFile(r, opts.GamesFormat, dataset.DefaultGamesSchema)
+	if err != nil {
+		return res, index, err
+	}
+
+	parse := func(record []string) (middleware.Game, error) {
+		return dataset.GameFromRecord(record, mapping)
+	}
+	handle := func(g middleware.Game) error {
+		if g.Linux {
+			res.Q1["Linux"] += 1
+		}
+		if g.Mac {
+			res.Q1["Mac"] += 1
+		}
+		if g.Windows {
+			res.Q1["Windows"] += 1
+		}
+
+		if hasGenre(g, middleware.IndieGenre) {
+			if _, ok := index.indie[g.AppID]; !ok {
+				index.indie[g.AppID] = g.Name
+			}
+			if int(g.ReleaseYear)/10 == DECADE/10 {
+				decade = append(decade, results.Stat{
+					AppID: g.AppID,
+					Name:  g.Name,
+					Stat:  float64(g.AveragePlaytimeForever),
+				})
+			}
+		}
+		if hasGenre(g, middleware.ActionGenre) {
+			if _, ok := index.action[g.AppID]; !ok {
+				index.action[g.AppID] = g.Name
+			}
+		}
+		return nil
+	}
+	skip := func(int, []string, error) {}
+
+	err = dataset.ParseRecords(reader, opts.Workers, parse, handle, skip)
+	if err != nil {
+		return res, index, err
+	}
+
+	res.Q2 = top(decade, TOP_PLAYTIME)
+	return res, index, nil
+}
+
+// Counts the reviews of each game involved in Q3, Q4 and Q5
+func readReviews(r io.Reader, index gameIndex, opts Options) (reviewCounts, error) {
+	counts := reviewCounts{
+		positiveIndie:   make(map[uint64]uint64),
+		negativeAction:  make(map[uint64]uint64),
+		englishNegative: make(map[uint64]uint64),
+	}
+
+	reader, mapping, err := openFile(r, opts.ReviewsFormat, dataset.DefaultReviewsSchema)
+	if err != nil {
+		return counts, err
+	}
+
+	// language detection is the most expensive step, so it's done by the
+	// parsing workers, and only for the reviews that need it
+	parse := func(record []string) (reviewRecord, error) {
+		review, err := dataset.ReviewFromRecord(record, mapping)
+		if err != nil {
+			return reviewRecord{}, err
+		}
+		_, action := index.action[review.AppID]
+		english := action && review.Score == middleware.NegativeScore && opts.IsEnglish(review.Text)
+		review.Text = ""
+		return reviewRecord{review: review, english: english}, nil
+	}
+	handle := func(r reviewRecord) error {
+		appID := r.review.AppID
+		switch r.review.Score {
+		case middleware.PositiveScore:
+			if _, ok := index.indie[appID]; ok {
+				counts.positiveIndie[appID] += 1
+			}
+		case middleware.NegativeScore:
+			if _, ok := index.action[appID]; ok {
+				counts.negativeAction[appID] += 1
+			}
+			if r.english {
+				counts.englishNegative[appID] += 1
+			}
+		}
+		return nil
+	}
+	skip := func(int, []string, error) {}
+
+	err = dataset.ParseRecords(reader, opts.Workers, parse, handle, skip)
+	return counts, err
+}
+
+func openFile(r io.Reader, format string, schema dataset.Schema) (dataset.RecordReader, dataset.ColumnMapping, error) {
+	reader, err := dataset.NewRecordReader(r, format, schema)
+	if err != nil {
+		return nil, dataset.ColumnMapping{}, err
+	}
+	header, err := reader.Header()
+	if err != nil {
+		return nil, dataset.ColumnMapping{}, err
+	}
+	mapping, err := dataset.NewColumnMapping(header, schema)
+	if err != nil {
+		return nil, dataset.ColumnMapping{}, fmt.Errorf("invalid header: %w", err)
+	}
+	return reader, mapping, nil
+}
+
+// Genres are matched as in the reference python solution, ignoring case
+// and accepting partial matches
+func hasGenre(g middleware.Game, genre string) bool {
+	genre = strings.ToLower(genre)
+	return slices.ContainsFunc(g.Genres, func(s string) bool {
+		return strings.Contains(strings.ToLower(s), genre)
+	})
+}
+
+// Builds the stats of the games whose count is accepted, sorted by app id
+func toStats(counts map[uint64]uint64, names map[uint64]string, accept func(uint64) bool) []results.Stat {
+	stats := make([]results.Stat, 0)
+	for appID, count := range counts {
+		if accept != nil && !accept(count) {
+			continue
+		}
+		stats = append(stats, results.Stat{AppID: appID, Name: names[appID], Stat: float64(count)})
+	}
+	slices.SortFunc(stats, func(a, b results.Stat) int { return cmp.Compare(a.AppID, b.AppID) })
+	return stats
+}
+
+// Returns the n stats with the highest value. Ties are broken by app id
+func top(stats []results.Stat, n int) []results.Stat {
+	slices.SortStableFunc(stats, func(a, b results.Stat) int {
+		return cmp.Or(cmp.Compare(b.Stat, a.Stat), cmp.Compare(a.AppID, b.AppID))
+	})
+	return stats[:min(n, len(stats))]
+}
+
+// Computes the given percentile of the counts, interpolating linearly
+// between the closest ranks, as pandas does
+func percentile(counts map[uint64]uint64, p float64) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	values := make([]float64, 0, len(counts))
+	for _, count := range counts {
+		values = append(values, float64(count))
+	}
+	slices.Sort(values)
+
+	position := p / 100 * float64(len(values)-1)
+	lower := math.Floor(position)
+	upper := math.Ceil(position)
+	return values[int(lower)] + (values[int(upper)]-values[int(lower)])*(position-lower)
+}
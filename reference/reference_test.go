@@ -0,0 +1,111 @@
+package reference_test
+
+import (
+	"distribuidos/tp1/reference"
+	"distribuidos/tp1/results"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const GAMES_HEADER = "AppID,Name,Release date,Windows,Mac,Linux,Average playtime forever,Genres\n"
+const REVIEWS_HEADER = "app_id,review_text,review_score\n"
+
+func buildGames() string {
+	var b strings.Builder
+	b.WriteString(GAMES_HEADER)
+	// indie games of the decade, with increasing playtime
+	for id := 1; id <= 12; id++ {
+		fmt.Fprintf(&b, "%v,indie-%v,\"Jan 2, 2015\",True,False,False,%v,Indie\n", id, id, id*10)
+	}
+	// out of the decade, and with both genres
+	b.WriteString("13,both,\"Mar 3, 2005\",False,False,True,999,\"Action,indie\"\n")
+	for id := 20; id <= 29; id++ {
+		fmt.Fprintf(&b, "%v,action-%v,Jan 2010,False,True,True,0,Action\n", id, id)
+	}
+	// rejected, as it has no genres
+	b.WriteString("30,invalid,Jan 2010,True,True,True,0,\n")
+	return b.String()
+}
+
+func buildReviews() string {
+	var b strings.Builder
+	b.WriteString(REVIEWS_HEADER)
+	write := func(id int, text string, score int, count int) {
+		for range count {
+			fmt.Fprintf(&b, "%v,%v,%v\n", id, text, score)
+		}
+	}
+	for id := 1; id <= 6; id++ {
+		write(id, "good", 1, id)
+	}
+	write(13, "good", 1, 10)
+	write(20, "english", -1, 5001)
+	write(21, "english", -1, 5000)
+	for id := 22; id <= 29; id++ {
+		write(id, "spanish", -1, 1)
+	}
+	// not an action game
+	write(1, "english", -1, 10)
+	// rejected, as it has no text
+	write(20, "", -1, 10)
+	return b.String()
+}
+
+func TestSolve(t *testing.T) {
+	opts := reference.Options{
+		Workers:   4,
+		IsEnglish: func(text string) bool { return text == "english" },
+	}
+	r, err := reference.Solve(strings.NewReader(buildGames()), strings.NewReader(buildReviews()), opts)
+	if err != nil {
+		t.Fatalf("Failed to solve: %v", err)
+	}
+
+	q2 := make([]results.Stat, 0)
+	for id := 12; id > 2; id-- {
+		q2 = append(q2, results.Stat{AppID: uint64(id), Name: fmt.Sprintf("indie-%v", id), Stat: float64(id * 10)})
+	}
+	expected := results.Results{
+		Q1: map[string]float64{"Linux": 11, "Mac": 10, "Windows": 12},
+		Q2: q2,
+		Q3: []results.Stat{
+			{AppID: 13, Name: "both", Stat: 10},
+			{AppID: 6, Name: "indie-6", Stat: 6},
+			{AppID: 5, Name: "indie-5", Stat: 5},
+			{AppID: 4, Name: "indie-4", Stat: 4},
+			{AppID: 3, Name: "indie-3", Stat: 3},
+		},
+		Q4: []results.Stat{{AppID: 20, Name: "action-20", Stat: 5001}},
+		Q5: []results.Stat{{AppID: 20, Name: "action-20", Stat: 5001}},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("Expected %v, got %v", expected, r)
+	}
+}
+
+func TestSolvePercentile(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(REVIEWS_HEADER)
+	// counts 1 to 10, the 90th percentile interpolates to 9.1
+	for id := 20; id <= 29; id++ {
+		for range id - 19 {
+			fmt.Fprintf(&b, "%v,text,-1\n", id)
+		}
+	}
+
+	opts := reference.Options{IsEnglish: func(string) bool { return false }}
+	r, err := reference.Solve(strings.NewReader(buildGames()), strings.NewReader(b.String()), opts)
+	if err != nil {
+		t.Fatalf("Failed to solve: %v", err)
+	}
+
+	expected := []results.Stat{{AppID: 29, Name: "action-29", Stat: 10}}
+	if !reflect.DeepEqual(r.Q5, expected) {
+		t.Fatalf("Expected %v, got %v", expected, r.Q5)
+	}
+	if len(r.Q4) != 0 {
+		t.Fatalf("Expected no Q4 results, got %v", r.Q4)
+	}
+}
@@ -0,0 +1,214 @@
+package restarter
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// State of the cluster as seen by a restarter. Only the leader monitors the
+// nodes, so the rest do not know when they were last heard
+type ClusterStatus struct {
+	Id         int        `json:"id"`
+	Leader     int        `json:"leader"`
+	Membership Membership `json:"membership"`
+	// Term of the replicated log and index of its last committed entry,
+	// zero if restarts are not replicated
+	Term   uint64       `json:"term"`
+	Commit uint64       `json:"commit"`
+	Nodes  []NodeStatus `json:"nodes"`
+}
+
+type NodeStatus struct {
+	Name string `json:"name"`
+	// Whether this restarter is monitoring the node
+	Monitored bool `json:"monitored"`
+	// Last heartbeat received from the node, and the health it reported
+	LastHeard     *time.Time `json:"last_heard,omitempty"`
+	Uptime        string     `json:"uptime,omitempty"`
+	ActiveClients uint32     `json:"active_clients"`
+	LastBatch     *time.Time `json:"last_batch,omitempty"`
+	// Times the node was suspected since this restarter became leader
+	Failures    int  `json:"failures"`
+	Paused      bool `json:"paused"`
+	Quarantined bool `json:"quarantined"`
+	// Whether a restart of the node committed to the log is in progress
+	Restarting bool        `json:"restarting"`
+	Restarts   []time.Time `json:"restarts"`
+}
+
+func (r *Restarter) Status() ClusterStatus {
+	members := r.Members()
+	status := ClusterStatus{
+		Id:         r.id,
+		Leader:     r.Leader(),
+		Membership: members,
+		Nodes:      make([]NodeStatus, 0, len(members.Nodes)),
+	}
+	if r.replog != nil {
+		status.Term, status.Commit = r.replog.Commit()
+	}
+	now := time.Now()
+
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	for _, name := range members.Nodes {
+		history := r.history.Node(name)
+		node := NodeStatus{
+			Name:        name,
+			Paused:      history.Paused,
+			Quarantined: history.Quarantined,
+			Restarting:  r.restartInProgress(name, now),
+			Restarts:    history.Restarts,
+		}
+		if node.Restarts == nil {
+			node.Restarts = []time.Time{}
+		}
+
+		if monitored, ok := r.statuses[name]; ok {
+			node.Monitored = true
+			node.Failures = monitored.failures
+			// copied, as the status keeps changing
+			lastHeard, health := monitored.lastHeard, monitored.health
+			if !lastHeard.IsZero() {
+				node.LastHeard = &lastHeard
+				node.Uptime = health.Uptime.String()
+				node.ActiveClients = health.ActiveClients
+			}
+			if !health.LastBatch.IsZero() {
+				node.LastBatch = &health.LastBatch
+			}
+		}
+		status.Nodes = append(status.Nodes, node)
+	}
+	return status
+}
+
+// Stops restarting the node until monitoring is resumed. Its heartbeats are
+// still received, so it is only restarted if it is down once resumed. If
+// restarts are replicated, the pause is too, and only the leader can pause
+func (r *Restarter) PauseMonitoring(ctx context.Context, name string) error {
+	return r.setPaused(ctx, name, true)
+}
+
+func (r *Restarter) ResumeMonitoring(ctx context.Context, name string) error {
+	return r.setPaused(ctx, name, false)
+}
+
+func (r *Restarter) setPaused(ctx context.Context, name string, paused bool) error {
+	members := r.Members()
+	id, isRestarter := restarterId(name)
+	if !slices.Contains(members.Nodes, name) && !(isRestarter && slices.Contains(members.Restarters, uint64(id))) {
+		return ErrUnknownNode
+	}
+
+	var err error
+	if r.replicated(name) {
+		kind := ResumeEntry
+		if paused {
+			kind = PauseEntry
+		}
+		_, err = r.propose(ctx, LogEntry{Kind: kind, Node: name})
+	} else {
+		err = r.history.SetPaused(name, paused)
+	}
+	if err != nil {
+		return err
+	}
+
+	if paused {
+		log.Infof("Monitoring of %v paused", name)
+	} else {
+		log.Infof("Monitoring of %v resumed", name)
+	}
+	return nil
+}
+
+var ErrNoSecret = errors.New("acting on the cluster requires a secret")
+
+// HTTP API to inspect the cluster and act on it. If packets are
+// authenticated, requests must carry the same secret as a bearer token.
+// Otherwise, the cluster can only be inspected
+//
+//	GET  /status                 state of the cluster, see ClusterStatus
+//	POST /nodes/{name}/pause     stops restarting the node, only on the
+//	                             leader if restarts are replicated
+//	POST /nodes/{name}/resume    restarts the node again when it falls,
+//	                             only on the leader if restarts are replicated
+//	POST /nodes/{name}/release   lifts the quarantine of the node, only
+//	                             on the leader if restarts are replicated
+//	POST /election               starts an election
+func (r *Restarter) AdminHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.Status())
+	})
+	mux.HandleFunc("POST /nodes/{name}/pause", func(w http.ResponseWriter, req *http.Request) {
+		writeResult(w, r.PauseMonitoring(req.Context(), req.PathValue("name")))
+	})
+	mux.HandleFunc("POST /nodes/{name}/resume", func(w http.ResponseWriter, req *http.Request) {
+		writeResult(w, r.ResumeMonitoring(req.Context(), req.PathValue("name")))
+	})
+	mux.HandleFunc("POST /nodes/{name}/release", func(w http.ResponseWriter, req *http.Request) {
+		log.Infof("Quarantine of %v released by an operator", req.PathValue("name"))
+		writeResult(w, r.release(req.Context(), req.PathValue("name")))
+	})
+	mux.HandleFunc("POST /election", func(w http.ResponseWriter, req *http.Request) {
+		log.Infof("Election forced by an operator")
+		go func() {
+			err := r.startElection(ctx)
+			if err != nil {
+				log.Errorf("Failed to start election: %v", err)
+			}
+		}()
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "election started"})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.auth == nil && req.Method != http.MethodGet {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": ErrNoSecret.Error()})
+			return
+		}
+		if !r.authorized(req) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": ErrUnauthenticated.Error()})
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func (r *Restarter) authorized(req *http.Request) bool {
+	if r.auth == nil {
+		return true
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && hmac.Equal([]byte(token), r.auth.secret)
+}
+
+func writeResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case errors.Is(err, ErrUnknownNode):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrNotLeader):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
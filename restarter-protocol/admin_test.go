@@ -0,0 +1,177 @@
+package restarter_test
+
+import (
+	"context"
+	"distribuidos/tp1/restarter-protocol"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Starts a single restarter monitoring a node that never sends heartbeats,
+// and serves its admin API
+func startAdmin(t *testing.T, auth *restarter.Authenticator) (*restarter.FakeBackend, *httptest.Server) {
+	history, err := restarter.NewRestartHistory(restarter.DefaultRestartPolicy(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor := restarter.DefaultMonitorConfig()
+	monitor.Interval = 20 * time.Millisecond
+	monitor.StartupGrace = 200 * time.Millisecond
+	backend := &restarter.FakeBackend{}
+	election := restarter.DefaultElectionConfig()
+	election.Delay = 10 * time.Millisecond
+
+	r, err := restarter.NewRestarter(restarter.Config{
+		Address:  restarter.RESTARTER_NAME + "0:14300",
+		Replicas: 1,
+		Nodes:    []string{"q1-count-1"},
+		Election: election,
+		Backend:  backend,
+		Monitor:  monitor,
+		History:  history,
+		Network:  newSimNetwork(1, faults{}),
+		Auth:     auth,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = r.Start(ctx) }()
+	go r.StartMonitoring(ctx)
+
+	server := httptest.NewServer(r.AdminHandler(ctx))
+	t.Cleanup(server.Close)
+	return backend, server
+}
+
+const ADMIN_SECRET = "secret"
+
+func adminAuth() *restarter.Authenticator {
+	return restarter.NewAuthenticator([]byte(ADMIN_SECRET), restarter.MAX_PACKET_AGE)
+}
+
+// Sends a request with the given secret, or without one if it is empty
+func request(t *testing.T, method string, url string, secret string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func post(t *testing.T, url string, secret string) int {
+	resp := request(t, http.MethodPost, url, secret)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func getStatus(t *testing.T, server *httptest.Server, secret string) restarter.ClusterStatus {
+	resp := request(t, http.MethodGet, server.URL+"/status", secret)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", resp.StatusCode)
+	}
+
+	var status restarter.ClusterStatus
+	err := json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	return status
+}
+
+func TestAdminPause(t *testing.T) {
+	backend, server := startAdmin(t, adminAuth())
+
+	if code := post(t, server.URL+"/nodes/q1-count-1/pause", ADMIN_SECRET); code != http.StatusOK {
+		t.Fatalf("Expected pause to succeed, got %v", code)
+	}
+	if code := post(t, server.URL+"/nodes/q9-count/pause", ADMIN_SECRET); code != http.StatusNotFound {
+		t.Fatalf("Expected unknown node to be rejected, got %v", code)
+	}
+
+	// the node is down, but it is not restarted while paused
+	time.Sleep(500 * time.Millisecond)
+	if restarts := backend.Restarts(); len(restarts) != 0 {
+		t.Fatalf("Expected paused node not to be restarted, got %v", restarts)
+	}
+	status := getStatus(t, server, ADMIN_SECRET)
+	if len(status.Nodes) != 1 || !status.Nodes[0].Paused || status.Nodes[0].Failures != 0 {
+		t.Fatalf("Expected paused node without failures, got %+v", status.Nodes)
+	}
+	if status.Membership.Nodes[0] != "q1-count-1" || len(status.Membership.Restarters) != 1 {
+		t.Fatalf("Unexpected membership %+v", status.Membership)
+	}
+
+	if code := post(t, server.URL+"/nodes/q1-count-1/resume", ADMIN_SECRET); code != http.StatusOK {
+		t.Fatalf("Expected resume to succeed, got %v", code)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.Restarts()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected resumed node to be restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		status = getStatus(t, server, ADMIN_SECRET)
+		node := status.Nodes[0]
+		if status.Leader == 0 && node.Monitored && !node.Paused && node.Failures > 0 && len(node.Restarts) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected restarted node in status, got %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := post(t, server.URL+"/election", ADMIN_SECRET); code != http.StatusAccepted {
+		t.Fatalf("Expected election to start, got %v", code)
+	}
+}
+
+func TestAdminSecret(t *testing.T) {
+	_, server := startAdmin(t, adminAuth())
+
+	if code := post(t, server.URL+"/nodes/q1-count-1/pause", ""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected request without secret to be rejected, got %v", code)
+	}
+	if code := post(t, server.URL+"/nodes/q1-count-1/pause", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected request with a wrong secret to be rejected, got %v", code)
+	}
+	if code := post(t, server.URL+"/nodes/q1-count-1/pause", ADMIN_SECRET); code != http.StatusOK {
+		t.Fatalf("Expected request with secret to succeed, got %v", code)
+	}
+}
+
+func TestAdminWithoutSecret(t *testing.T) {
+	_, server := startAdmin(t, nil)
+
+	// the cluster can be inspected, but not acted on
+	status := getStatus(t, server, "")
+	if len(status.Nodes) != 1 || status.Nodes[0].Name != "q1-count-1" {
+		t.Fatalf("Unexpected nodes %+v", status.Nodes)
+	}
+	for _, path := range []string{"/nodes/q1-count-1/pause", "/nodes/q1-count-1/resume", "/nodes/q1-count-1/release", "/election"} {
+		if code := post(t, server.URL+path, ""); code != http.StatusForbidden {
+			t.Fatalf("Expected %v to be forbidden without a secret, got %v", path, code)
+		}
+	}
+	if status := getStatus(t, server, ""); status.Nodes[0].Paused {
+		t.Fatalf("Expected node not to be paused")
+	}
+}
@@ -0,0 +1,121 @@
+package restarter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Packets older or newer than this are rejected, so it must cover the clock
+// skew between hosts and the retries of a message, which keep its id
+const MAX_PACKET_AGE = 30 * time.Second
+
+const MAC_SIZE = sha256.Size
+
+var ErrUnauthenticated = errors.New("packet is not authenticated")
+var ErrExpired = errors.New("packet is too old")
+var ErrReplayed = errors.New("packet was already received")
+
+// Authenticates packets with an HMAC over a secret shared by restarters and
+// nodes. Packet ids are the time at which they are sent, so old packets are
+// rejected, and the ones received within MAX_PACKET_AGE are remembered to
+// reject them if replayed. A nil authenticator accepts every packet
+type Authenticator struct {
+	secret []byte
+	maxAge time.Duration
+	mu     sync.Mutex
+	// expiration of each received packet, by its MAC
+	seen   map[[MAC_SIZE]byte]time.Time
+	pruned time.Time
+}
+
+func NewAuthenticator(secret []byte, maxAge time.Duration) *Authenticator {
+	return &Authenticator{
+		secret: secret,
+		maxAge: maxAge,
+		seen:   make(map[[MAC_SIZE]byte]time.Time),
+	}
+}
+
+// Authenticator with the secret of RESTARTER_SECRET, or nil if it is not set
+func SecretAuthenticator() *Authenticator {
+	secret := os.Getenv("RESTARTER_SECRET")
+	if secret == "" {
+		return nil
+	}
+	return NewAuthenticator([]byte(secret), MAX_PACKET_AGE)
+}
+
+// Appends the MAC to the encoded packet
+func (a *Authenticator) Seal(packet []byte) []byte {
+	if a == nil {
+		return packet
+	}
+	return append(packet, a.mac(packet)...)
+}
+
+// Verifies the MAC of the packet and strips it. Retransmissions of a packet
+// are indistinguishable from replays, so ErrReplayed is returned along with
+// the packet, to acknowledge it again without handling it twice
+func (a *Authenticator) Open(buf []byte, now time.Time) ([]byte, error) {
+	if a == nil {
+		return buf, nil
+	}
+	if len(buf) < MAC_SIZE {
+		return nil, ErrUnauthenticated
+	}
+	packet, tag := buf[:len(buf)-MAC_SIZE], buf[len(buf)-MAC_SIZE:]
+	if !hmac.Equal(tag, a.mac(packet)) {
+		return nil, ErrUnauthenticated
+	}
+
+	// the type of the message is followed by the id
+	if len(packet) < 9 {
+		return nil, ErrInvalidMessage
+	}
+	sent := time.Unix(0, int64(binary.LittleEndian.Uint64(packet[1:9])))
+	if now.Sub(sent) > a.maxAge || sent.Sub(now) > a.maxAge {
+		return nil, ErrExpired
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(now)
+
+	key := [MAC_SIZE]byte(tag)
+	if _, ok := a.seen[key]; ok {
+		return packet, ErrReplayed
+	}
+	a.seen[key] = sent.Add(a.maxAge)
+	return packet, nil
+}
+
+func (a *Authenticator) mac(packet []byte) []byte {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write(packet)
+	return h.Sum(nil)
+}
+
+// Forgets the packets that would be rejected anyway for being too old
+// requires lock
+func (a *Authenticator) prune(now time.Time) {
+	if now.Sub(a.pruned) < a.maxAge {
+		return
+	}
+	a.pruned = now
+	for key, expires := range a.seen {
+		if now.After(expires) {
+			delete(a.seen, key)
+		}
+	}
+}
+
+// Id of the next packet, which is the current time unless packets were sent
+// faster than the clock advances
+func nextMsgId(last uint64, now time.Time) uint64 {
+	return max(last+1, uint64(now.UnixNano()))
+}
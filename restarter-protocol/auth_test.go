@@ -0,0 +1,75 @@
+package restarter_test
+
+import (
+	"distribuidos/tp1/restarter-protocol"
+	"errors"
+	"testing"
+	"time"
+)
+
+func sealedPacket(t *testing.T, auth *restarter.Authenticator, sent time.Time) []byte {
+	buf, err := restarter.Packet{Id: uint64(sent.UnixNano()), Msg: restarter.Coordinator{Leader: 3}}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.Seal(buf)
+}
+
+func TestAuthenticator(t *testing.T) {
+	now := time.Now()
+	sender := restarter.NewAuthenticator([]byte("secret"), time.Minute)
+	receiver := restarter.NewAuthenticator([]byte("secret"), time.Minute)
+
+	sealed := sealedPacket(t, sender, now)
+	data, err := receiver.Open(sealed, now)
+	if err != nil {
+		t.Fatalf("Failed to open packet: %v", err)
+	}
+	packet, err := restarter.Decode(data)
+	if err != nil {
+		t.Fatalf("Failed to decode opened packet: %v", err)
+	}
+	if msg, ok := packet.Msg.(restarter.Coordinator); !ok || msg.Leader != 3 {
+		t.Fatalf("Expected coordinator 3, got %v", packet.Msg)
+	}
+
+	// retransmissions are returned, but flagged
+	_, err = receiver.Open(sealed, now.Add(time.Second))
+	if !errors.Is(err, restarter.ErrReplayed) {
+		t.Fatalf("Expected replayed packet, got %v", err)
+	}
+
+	old := sealedPacket(t, sender, now.Add(-2*time.Minute))
+	_, err = receiver.Open(old, now)
+	if !errors.Is(err, restarter.ErrExpired) {
+		t.Fatalf("Expected expired packet, got %v", err)
+	}
+
+	tampered := sealedPacket(t, sender, now)
+	tampered[len(tampered)-restarter.MAC_SIZE-1] = 4
+	_, err = receiver.Open(tampered, now)
+	if !errors.Is(err, restarter.ErrUnauthenticated) {
+		t.Fatalf("Expected tampered packet to be rejected, got %v", err)
+	}
+
+	forged := sealedPacket(t, restarter.NewAuthenticator([]byte("guess"), time.Minute), now)
+	_, err = receiver.Open(forged, now)
+	if !errors.Is(err, restarter.ErrUnauthenticated) {
+		t.Fatalf("Expected forged packet to be rejected, got %v", err)
+	}
+
+	unsealed := sealedPacket(t, nil, now)
+	_, err = receiver.Open(unsealed, now)
+	if !errors.Is(err, restarter.ErrUnauthenticated) {
+		t.Fatalf("Expected unauthenticated packet to be rejected, got %v", err)
+	}
+}
+
+func TestNilAuthenticator(t *testing.T) {
+	var auth *restarter.Authenticator
+	sealed := sealedPacket(t, auth, time.Now())
+	data, err := auth.Open(sealed, time.Now())
+	if err != nil || len(data) != len(sealed) {
+		t.Fatalf("Expected packet to be accepted as is, got %v", err)
+	}
+}
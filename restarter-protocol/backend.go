@@ -0,0 +1,72 @@
+package restarter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+var ErrUnknownMode = errors.New("unknown restart mode")
+
+// How fallen nodes are restarted
+const (
+	// Restarts the container with the Docker CLI
+	DOCKER_MODE = "docker"
+	// Deletes the pods of the node through the Kubernetes API
+	KUBERNETES_MODE = "kubernetes"
+	// Supervises nodes launched as local processes
+	PROCESS_MODE = "process"
+	// Only logs fallen nodes, leaving restarts to the orchestrator
+	NOOP_MODE = "none"
+)
+
+// Restarts fallen nodes, identified by their name
+type RestartBackend interface {
+	Restart(ctx context.Context, name string) error
+}
+
+// Restarts containers with the Docker CLI, nodes are named after their
+// container
+type DockerBackend struct{}
+
+func (DockerBackend) Restart(ctx context.Context, name string) error {
+	output, err := exec.CommandContext(ctx, "docker", "restart", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to restart container %v: %w: %s", name, err, output)
+	}
+	return nil
+}
+
+type NoopBackend struct{}
+
+func (NoopBackend) Restart(ctx context.Context, name string) error {
+	log.Infof("Leaving restart of %v to the orchestrator", name)
+	return nil
+}
+
+// Records restarts instead of performing them, to assert restart decisions
+// in tests. Safe for concurrent use
+type FakeBackend struct {
+	mu       sync.Mutex
+	restarts []string
+	// Error returned by every restart, if any
+	Err error
+}
+
+func (f *FakeBackend) Restart(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.restarts = append(f.restarts, name)
+	return f.Err
+}
+
+// Nodes restarted so far, in order
+func (f *FakeBackend) Restarts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	restarts := make([]string, len(f.restarts))
+	copy(restarts, f.restarts)
+	return restarts
+}
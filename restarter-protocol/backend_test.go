@@ -0,0 +1,100 @@
+package restarter_test
+
+import (
+	"context"
+	"distribuidos/tp1/restarter-protocol"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFakeBackend(t *testing.T) {
+	var backend restarter.RestartBackend = &restarter.FakeBackend{}
+	_ = backend.Restart(context.Background(), "a")
+	_ = backend.Restart(context.Background(), "b")
+
+	restarts := backend.(*restarter.FakeBackend).Restarts()
+	if !reflect.DeepEqual(restarts, []string{"a", "b"}) {
+		t.Fatalf("Expected restarts of a and b, got %v", restarts)
+	}
+}
+
+func TestLoadProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes.yaml")
+	data := "node-1:\n  command: [sleep, \"30\"]\n  env:\n    STAGE: q1-count\n"
+	err := os.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processes, err := restarter.LoadProcesses(path)
+	if err != nil {
+		t.Fatalf("Failed to load processes: %v", err)
+	}
+	expected := map[string]restarter.ProcessConfig{
+		"node-1": {Command: []string{"sleep", "30"}, Env: map[string]string{"STAGE": "q1-count"}},
+	}
+	if !reflect.DeepEqual(processes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, processes)
+	}
+
+	err = os.WriteFile(path, []byte("node-1:\n  env: {}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarter.LoadProcesses(path); err == nil {
+		t.Fatalf("Expected node without command to fail")
+	}
+}
+
+func TestProcessBackend(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := restarter.NewProcessBackend(map[string]restarter.ProcessConfig{
+		"node-1": {Command: []string{"sleep", "30"}},
+	}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, running := backend.Pid("node-1"); running {
+		t.Fatalf("Expected node not to be running")
+	}
+
+	err = backend.Restart(ctx, "node-1")
+	if err != nil {
+		t.Fatalf("Failed to launch node: %v", err)
+	}
+	first, running := backend.Pid("node-1")
+	if !running {
+		t.Fatalf("Expected node to be running")
+	}
+
+	err = backend.Restart(ctx, "node-1")
+	if err != nil {
+		t.Fatalf("Failed to restart node: %v", err)
+	}
+	second, running := backend.Pid("node-1")
+	if !running || second == first {
+		t.Fatalf("Expected a new process, got %v (previous %v)", second, first)
+	}
+	t.Cleanup(func() { _ = syscall.Kill(second, syscall.SIGKILL) })
+
+	// the previous process is reaped asynchronously
+	deadline := time.Now().Add(time.Second)
+	for syscall.Kill(first, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected process %v to be stopped", first)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = backend.Restart(ctx, "unknown")
+	if !errors.Is(err, restarter.ErrUnknownNode) {
+		t.Fatalf("Expected unknown node error, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package restarter
+
+import (
+	"context"
+	"distribuidos/tp1/utils"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// In the Bully algorithm, a restarter starts an election by sending it to
+// the restarters with higher ids. The ones that answer take over the
+// election, and if none answers, the restarter is the leader and announces
+// itself to the rest
+func (r *Restarter) startBullyElection(ctx context.Context) error {
+	r.mu.Lock()
+	if r.electing {
+		r.mu.Unlock()
+		return nil
+	}
+	r.electing = true
+	round := r.coordinatorRound
+	r.mu.Unlock()
+
+	log.Infof("Starting election")
+
+	higher := slices.DeleteFunc(r.otherRestarters(), func(id int) bool { return id < r.id })
+	if !r.sendToAll(ctx, Election{Ids: []uint64{uint64(r.id)}}, higher) {
+		return r.announceLeader(ctx)
+	}
+
+	// if the higher restarters fall before announcing themselves, the
+	// election is started again
+	go func() {
+		select {
+		case <-time.After(2 * MAX_ATTEMPTS * r.election.AckTimeout):
+		case <-ctx.Done():
+			return
+		}
+
+		r.mu.Lock()
+		timedOut := r.coordinatorRound == round
+		if timedOut {
+			r.electing = false
+		}
+		r.mu.Unlock()
+
+		if timedOut {
+			log.Warningf("No coordinator was announced, starting election again")
+			err := r.startBullyElection(ctx)
+			if err != nil {
+				log.Errorf("Failed to start election: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// The election was already answered by the ack, so the restarter takes it
+// over
+func (r *Restarter) handleBullyElection(ctx context.Context, msg Election) error {
+	log.Infof("Received Election message with ids: %v", idsToString(msg.Ids))
+	return r.startBullyElection(ctx)
+}
+
+func (r *Restarter) handleBullyCoordinator(ctx context.Context, msg Coordinator) error {
+	if int(msg.Leader) < r.id && r.isMember() {
+		log.Infof("Coordinator %v has a lower id, starting election", msg.Leader)
+		return r.startBullyElection(ctx)
+	}
+
+	r.endElection()
+	r.setLeader(int(msg.Leader))
+	return nil
+}
+
+func (r *Restarter) announceLeader(ctx context.Context) error {
+	r.endElection()
+	r.setLeader(r.id)
+
+	r.sendToAll(ctx, Coordinator{Leader: uint64(r.id), Ids: []uint64{uint64(r.id)}}, r.otherRestarters())
+	return nil
+}
+
+func (r *Restarter) endElection() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.electing = false
+	r.coordinatorRound += 1
+}
+
+// Sends the message to the given restarters concurrently, returning whether
+// any of them answered
+func (r *Restarter) sendToAll(ctx context.Context, msg Message, ids []int) bool {
+	var answered atomic.Bool
+	wg := &sync.WaitGroup{}
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := r.safeSend(ctx, msg, restarterName(id), utils.RESTARTER_PORT)
+			if err == nil {
+				answered.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	return answered.Load()
+}
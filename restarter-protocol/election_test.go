@@ -0,0 +1,206 @@
+package restarter_test
+
+import (
+	"context"
+	"distribuidos/tp1/restarter-protocol"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const REPLICAS = 4
+
+type cluster struct {
+	algorithm string
+	network   *simNetwork
+	// packets are not authenticated if nil
+	secret []byte
+	// nodes monitored, and how they are restarted
+	nodes  []string
+	policy restarter.RestartPolicy
+	// size of the replicated log, restarts are not replicated if zero
+	logSize    int
+	restarters []*restarter.Restarter
+	backends   []*restarter.FakeBackend
+	cancels    []context.CancelFunc
+	alive      []bool
+}
+
+func startCluster(t *testing.T, algorithm string, network *simNetwork) *cluster {
+	return startSecureCluster(t, algorithm, network, nil)
+}
+
+func startSecureCluster(t *testing.T, algorithm string, network *simNetwork, secret []byte) *cluster {
+	c := &cluster{algorithm: algorithm, network: network, secret: secret, policy: restarter.DefaultRestartPolicy()}
+	c.startAll(t)
+	return c
+}
+
+func (c *cluster) startAll(t *testing.T) {
+	for id := 0; id < REPLICAS; id++ {
+		c.start(t, id)
+	}
+	t.Cleanup(func() {
+		for _, cancel := range c.cancels {
+			cancel()
+		}
+	})
+}
+
+// Starts a restarter with the next id. Restarters with ids from REPLICAS on
+// are not in the initial membership, and have to join the cluster
+func (c *cluster) start(t *testing.T, id int) {
+	history, err := restarter.NewRestartHistory(c.policy, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replog *restarter.ReplicatedLog
+	if c.logSize > 0 {
+		replog, err = restarter.NewReplicatedLog(t.TempDir(), c.logSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	monitor := restarter.DefaultMonitorConfig()
+	monitor.Interval = 20 * time.Millisecond
+	monitor.StartupGrace = 200 * time.Millisecond
+	monitor.Detector.MinStdDev = 20 * time.Millisecond
+	monitor.RestartLease = time.Second
+
+	backend := &restarter.FakeBackend{}
+	var auth *restarter.Authenticator
+	if c.secret != nil {
+		auth = restarter.NewAuthenticator(c.secret, restarter.MAX_PACKET_AGE)
+	}
+
+	r, err := restarter.NewRestarter(restarter.Config{
+		Address:  fmt.Sprintf("%v%v:14300", restarter.RESTARTER_NAME, id),
+		Id:       id,
+		Replicas: REPLICAS,
+		Nodes:    c.nodes,
+		Election: restarter.ElectionConfig{
+			Algorithm:  c.algorithm,
+			Delay:      10 * time.Millisecond,
+			AckTimeout: 30 * time.Millisecond,
+			Refresh:    100 * time.Millisecond,
+		},
+		Backend: backend,
+		Monitor: monitor,
+		History: history,
+		Log:     replog,
+		Network: c.network,
+		Auth:    auth,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _ = r.Start(ctx) }()
+	if replog != nil {
+		go monitorWhileLeader(ctx, r, id)
+	}
+
+	c.restarters = append(c.restarters, r)
+	c.backends = append(c.backends, backend)
+	c.cancels = append(c.cancels, cancel)
+	c.alive = append(c.alive, true)
+}
+
+func (c *cluster) kill(id int) {
+	c.cancels[id]()
+	c.alive[id] = false
+}
+
+// Waits until every alive restarter agrees on the expected leader, which
+// means that it is the only one that believes to be the leader
+func (c *cluster) waitLeader(t *testing.T, expected int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		leaders := make([]int, 0)
+		agreed := true
+		for id, r := range c.restarters {
+			if !c.alive[id] {
+				continue
+			}
+			leaders = append(leaders, r.Leader())
+			agreed = agreed && r.Leader() == expected
+		}
+		if agreed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected leader %v, restarters believe %v", expected, leaders)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var electionFaults = map[string]faults{
+	"reliable": {},
+	"lossy":    {Drop: 0.2, Duplicate: 0.2, MaxDelay: 20 * time.Millisecond},
+}
+
+func TestElection(t *testing.T) {
+	for _, algorithm := range []string{restarter.RING_ELECTION, restarter.BULLY_ELECTION} {
+		for name, f := range electionFaults {
+			t.Run(algorithm+"/"+name, func(t *testing.T) {
+				c := startCluster(t, algorithm, newSimNetwork(1, f))
+				c.waitLeader(t, REPLICAS-1, 10*time.Second)
+
+				// the leader falls, and the highest remaining one replaces it
+				c.kill(REPLICAS - 1)
+				c.waitLeader(t, REPLICAS-2, 10*time.Second)
+			})
+		}
+	}
+}
+
+func TestAuthenticatedElection(t *testing.T) {
+	network := newSimNetwork(1, electionFaults["lossy"])
+	c := startSecureCluster(t, restarter.BULLY_ELECTION, network, []byte("secret"))
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+
+	// a forged join, which would otherwise add the node to the cluster, is
+	// rejected
+	attacker, err := network.Listen(fmt.Sprintf("%v200:14300", restarter.RESTARTER_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attacker.Close()
+	forged, err := restarter.Packet{Id: uint64(time.Now().UnixNano()), Msg: restarter.Join{Name: "forged"}}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 0; id < REPLICAS; id++ {
+		addr, err := network.Resolve(fmt.Sprintf("%v%v", restarter.RESTARTER_NAME, id), 14300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = attacker.WriteToUDP(forged, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	for id, r := range c.restarters {
+		if nodes := r.Members().Nodes; len(nodes) != 0 {
+			t.Fatalf("Restarter %v accepted forged nodes %v", id, nodes)
+		}
+	}
+
+	c.kill(REPLICAS - 1)
+	c.waitLeader(t, REPLICAS-2, 10*time.Second)
+}
+
+func TestUnknownElection(t *testing.T) {
+	_, err := restarter.NewRestarter(restarter.Config{
+		Address:  "restarter-0:14300",
+		Election: restarter.ElectionConfig{Algorithm: "raffle"},
+		Network:  newSimNetwork(1, faults{}),
+	})
+	if err == nil {
+		t.Fatalf("Expected unknown election algorithm to fail")
+	}
+}
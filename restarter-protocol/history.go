@@ -0,0 +1,304 @@
+package restarter
+
+import (
+	"distribuidos/tp1/database"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Restarts kept in the history of each node
+const HISTORY_SIZE = 50
+
+const HISTORY_KEY = "restart-history"
+
+var ErrQuarantined = errors.New("node is quarantined")
+var ErrCrashLooping = errors.New("node is crash looping")
+
+type RestartPolicy struct {
+	// Time waited before restarting a node that was already restarted
+	// within the window, doubled with every restart
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// A node restarted MaxRestarts times within the window is crash
+	// looping, and is quarantined
+	Window      time.Duration
+	MaxRestarts int
+}
+
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Minute,
+		Window:      5 * time.Minute,
+		MaxRestarts: 5,
+	}
+}
+
+type NodeHistory struct {
+	Restarts []time.Time `json:"restarts"`
+	// Quarantined nodes are not restarted until an operator releases them
+	Quarantined bool `json:"quarantined"`
+	// Paused nodes are not restarted until an operator resumes them
+	Paused bool `json:"paused"`
+}
+
+// Restarts of each node, persisted so that crash loops are detected even if
+// the restarter itself is restarted
+type RestartHistory struct {
+	policy RestartPolicy
+	db     *database.Database
+	mu     sync.Mutex
+	nodes  map[string]*NodeHistory
+}
+
+// Opens the history stored at the given path, creating it if it does not
+// exist
+func NewRestartHistory(policy RestartPolicy, path string) (*RestartHistory, error) {
+	db, err := database.NewDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &RestartHistory{
+		policy: policy,
+		db:     db,
+		nodes:  make(map[string]*NodeHistory),
+	}
+
+	exists, err := db.Exists(HISTORY_KEY)
+	if err != nil || !exists {
+		return h, err
+	}
+	file, err := db.Get(HISTORY_KEY)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&h.nodes)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Time to wait before restarting the node, which grows exponentially with
+// its recent restarts. A node restarted MaxRestarts times within the window
+// is quarantined instead, and ErrQuarantined is returned
+func (h *RestartHistory) Backoff(name string, now time.Time) (time.Duration, error) {
+	backoff, err := h.NextRestart(name, now)
+	if errors.Is(err, ErrCrashLooping) {
+		return 0, errors.Join(ErrQuarantined, h.Quarantine(name))
+	}
+	return backoff, err
+}
+
+// Same as Backoff, but returns ErrCrashLooping instead of quarantining the
+// node, so that the quarantine can be replicated first
+func (h *RestartHistory) NextRestart(name string, now time.Time) (time.Duration, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node, ok := h.nodes[name]
+	if !ok {
+		return 0, nil
+	}
+	if node.Quarantined {
+		return 0, ErrQuarantined
+	}
+
+	recent := h.recentRestarts(node, now)
+	if recent >= h.policy.MaxRestarts {
+		return 0, ErrCrashLooping
+	}
+	if recent == 0 {
+		return 0, nil
+	}
+	backoff := h.policy.BaseBackoff << (recent - 1)
+	if backoff > h.policy.MaxBackoff || backoff <= 0 {
+		backoff = h.policy.MaxBackoff
+	}
+	return backoff, nil
+}
+
+// Records a restart of the node. Restarts are kept sorted, and recording the
+// same one twice has no effect, as replicated restarts may be applied again
+func (h *RestartHistory) Record(name string, at time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node := h.node(name)
+	i, found := slices.BinarySearchFunc(node.Restarts, at, time.Time.Compare)
+	if found {
+		return nil
+	}
+	node.Restarts = slices.Insert(node.Restarts, i, at)
+	if len(node.Restarts) > HISTORY_SIZE {
+		node.Restarts = node.Restarts[len(node.Restarts)-HISTORY_SIZE:]
+	}
+	return h.save()
+}
+
+// Stops restarting the node until it is released
+func (h *RestartHistory) Quarantine(name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node := h.node(name)
+	if node.Quarantined {
+		return nil
+	}
+	node.Quarantined = true
+	return h.save()
+}
+
+// Lifts the quarantine of the node, forgetting its restarts
+func (h *RestartHistory) Release(name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node, ok := h.nodes[name]
+	if !ok || !node.Quarantined {
+		return nil
+	}
+	node.Quarantined = false
+	node.Restarts = nil
+	return h.save()
+}
+
+// Stops or resumes restarting the node, keeping its restarts
+func (h *RestartHistory) SetPaused(name string, paused bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node := h.node(name)
+	if node.Paused == paused {
+		return nil
+	}
+	node.Paused = paused
+	return h.save()
+}
+
+func (h *RestartHistory) IsPaused(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node, ok := h.nodes[name]
+	return ok && node.Paused
+}
+
+func (h *RestartHistory) IsQuarantined(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node, ok := h.nodes[name]
+	return ok && node.Quarantined
+}
+
+// Names of the quarantined nodes, sorted
+func (h *RestartHistory) Quarantined() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	quarantined := []string{}
+	for _, name := range slices.Sorted(maps.Keys(h.nodes)) {
+		if h.nodes[name].Quarantined {
+			quarantined = append(quarantined, name)
+		}
+	}
+	return quarantined
+}
+
+// Copy of the history of the node
+func (h *RestartHistory) Node(name string) NodeHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node, ok := h.nodes[name]
+	if !ok {
+		return NodeHistory{}
+	}
+	return NodeHistory{Restarts: slices.Clone(node.Restarts), Quarantined: node.Quarantined, Paused: node.Paused}
+}
+
+// Copy of the history of the nodes monitored by the leader, keeping only
+// their restarts within the window. Restarters are restarted by their
+// neighbors instead, so their history is not replicated
+func (h *RestartHistory) replicated(now time.Time) map[string]NodeHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	nodes := make(map[string]NodeHistory)
+	for name, node := range h.nodes {
+		if _, ok := restarterId(name); ok {
+			continue
+		}
+		recent := slices.DeleteFunc(slices.Clone(node.Restarts), func(restart time.Time) bool {
+			return now.Sub(restart) >= h.policy.Window
+		})
+		nodes[name] = NodeHistory{Restarts: recent, Quarantined: node.Quarantined, Paused: node.Paused}
+	}
+	return nodes
+}
+
+// Replaces the history of the nodes monitored by the leader
+func (h *RestartHistory) restoreReplicated(nodes map[string]NodeHistory) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name := range h.nodes {
+		if _, ok := restarterId(name); !ok {
+			delete(h.nodes, name)
+		}
+	}
+	for name, node := range nodes {
+		h.nodes[name] = &NodeHistory{Restarts: slices.Clone(node.Restarts), Quarantined: node.Quarantined, Paused: node.Paused}
+	}
+	return h.save()
+}
+
+// requires lock
+func (h *RestartHistory) node(name string) *NodeHistory {
+	node, ok := h.nodes[name]
+	if !ok {
+		node = &NodeHistory{}
+		h.nodes[name] = node
+	}
+	return node
+}
+
+// requires lock
+func (h *RestartHistory) recentRestarts(node *NodeHistory, now time.Time) int {
+	recent := 0
+	for _, restart := range node.Restarts {
+		if now.Sub(restart) < h.policy.Window {
+			recent += 1
+		}
+	}
+	return recent
+}
+
+// requires lock
+func (h *RestartHistory) save() (err error) {
+	snapshot, err := h.db.NewSnapshot()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, snapshot.Abort())
+			return
+		}
+		err = snapshot.Commit()
+	}()
+
+	file, err := snapshot.Create(HISTORY_KEY)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(file).Encode(h.nodes)
+}
@@ -0,0 +1,99 @@
+package restarter_test
+
+import (
+	"distribuidos/tp1/restarter-protocol"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var policy = restarter.RestartPolicy{
+	BaseBackoff: time.Second,
+	MaxBackoff:  4 * time.Second,
+	Window:      time.Minute,
+	MaxRestarts: 4,
+}
+
+func TestRestartBackoff(t *testing.T) {
+	history, err := restarter.NewRestartHistory(policy, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	expected := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, backoff := range expected {
+		got, err := history.Backoff("q1-count-1", now)
+		if err != nil {
+			t.Fatalf("Unexpected error on restart %v: %v", i, err)
+		}
+		if got != backoff {
+			t.Fatalf("Expected backoff %v on restart %v, got %v", backoff, i, got)
+		}
+		err = history.Record("q1-count-1", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	// restarts outside of the window are not counted
+	backoff, err := history.Backoff("q1-count-1", now.Add(time.Minute))
+	if err != nil || backoff != 0 {
+		t.Fatalf("Expected no backoff after the window, got %v (%v)", backoff, err)
+	}
+	backoff, err = history.Backoff("q1-count-2", now)
+	if err != nil || backoff != 0 {
+		t.Fatalf("Expected no backoff for other nodes, got %v (%v)", backoff, err)
+	}
+}
+
+func TestCrashLoopQuarantine(t *testing.T) {
+	path := t.TempDir()
+	history, err := restarter.NewRestartHistory(policy, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	for i := 0; i < policy.MaxRestarts; i++ {
+		err = history.Record("gateway", now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = history.Backoff("gateway", now.Add(10*time.Second))
+	if !errors.Is(err, restarter.ErrQuarantined) {
+		t.Fatalf("Expected crash looping node to be quarantined, got %v", err)
+	}
+
+	// the history survives restarts of the restarter
+	history, err = restarter.NewRestartHistory(policy, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history.Quarantined(), []string{"gateway"}) {
+		t.Fatalf("Expected gateway to be quarantined, got %v", history.Quarantined())
+	}
+	if restarts := history.Node("gateway").Restarts; len(restarts) != policy.MaxRestarts {
+		t.Fatalf("Expected %v restarts, got %v", policy.MaxRestarts, restarts)
+	}
+	_, err = history.Backoff("gateway", now.Add(time.Hour))
+	if !errors.Is(err, restarter.ErrQuarantined) {
+		t.Fatalf("Expected quarantine to last until released, got %v", err)
+	}
+
+	err = history.Release("gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backoff, err := history.Backoff("gateway", now.Add(10*time.Second))
+	if err != nil || backoff != 0 {
+		t.Fatalf("Expected released node to be restarted right away, got %v (%v)", backoff, err)
+	}
+	if history.IsQuarantined("gateway") {
+		t.Fatalf("Expected gateway to be released")
+	}
+}
@@ -0,0 +1,91 @@
+package restarter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory where Kubernetes mounts the credentials of the service account
+const SERVICE_ACCOUNT_DIR = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Label that identifies the pods of each node
+const INSTANCE_LABEL = "app.kubernetes.io/instance"
+
+var ErrNotInCluster = errors.New("not running inside a Kubernetes cluster")
+
+// Restarts nodes through the Kubernetes API, by deleting their pods so that
+// their controller creates them again. Pods are selected by their instance
+// label, which must match the name of the node
+type KubernetesBackend struct {
+	host      string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+// Builds the backend from the service account of the pod
+func NewKubernetesBackend() (*KubernetesBackend, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+
+	token, err := os.ReadFile(filepath.Join(SERVICE_ACCOUNT_DIR, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	namespace, err := os.ReadFile(filepath.Join(SERVICE_ACCOUNT_DIR, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read namespace: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(SERVICE_ACCOUNT_DIR, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid cluster certificate")
+	}
+
+	return &KubernetesBackend{
+		host:      "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		token:     strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+func (k *KubernetesBackend) Restart(ctx context.Context, name string) error {
+	query := url.Values{"labelSelector": {INSTANCE_LABEL + "=" + name}}
+	endpoint := fmt.Sprintf("%v/api/v1/namespaces/%v/pods?%v", k.host, url.PathEscape(k.namespace), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, MAX_PACKAGE_SIZE))
+		return fmt.Errorf("failed to delete pods of %v: %v: %s", name, resp.Status, body)
+	}
+	return nil
+}
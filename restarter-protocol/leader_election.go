@@ -4,12 +4,43 @@ import (
 	"context"
 	"distribuidos/tp1/utils"
 	"errors"
-	"fmt"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Election algorithms
+const (
+	RING_ELECTION  = "ring"
+	BULLY_ELECTION = "bully"
+)
+
+var ErrUnknownElection = errors.New("unknown election algorithm")
+
+type ElectionConfig struct {
+	// Either RING_ELECTION or BULLY_ELECTION
+	Algorithm string
+	// Time waited before the first election, so that the other restarters
+	// are started
+	Delay time.Duration
+	// Time waited for the ack of each message, which is sent up to
+	// MAX_ATTEMPTS times
+	AckTimeout time.Duration
+	// Interval at which the leader reasserts its leadership, and restarters
+	// without a leader start an election
+	Refresh time.Duration
+}
+
+func DefaultElectionConfig() ElectionConfig {
+	return ElectionConfig{
+		Algorithm:  RING_ELECTION,
+		Delay:      3 * time.Second,
+		AckTimeout: ACK_TIMEOUT,
+		Refresh:    5 * time.Second,
+	}
+}
+
 func (r *Restarter) WaitLeader(amILeader bool) {
 	r.condLeaderId.L.Lock()
 	defer r.condLeaderId.L.Unlock()
@@ -18,12 +49,97 @@ func (r *Restarter) WaitLeader(amILeader bool) {
 	}
 }
 
+// Id of the current leader, or -1 if it is unknown
+func (r *Restarter) Leader() int {
+	r.condLeaderId.L.Lock()
+	defer r.condLeaderId.L.Unlock()
+	return r.leaderId
+}
+
 // requires lock
 func (r *Restarter) amILeader() bool {
 	return (r.id == r.leaderId)
 }
 
+func (r *Restarter) setLeader(leader int) {
+	r.condLeaderId.L.Lock()
+	changed := r.leaderId != leader
+	r.leaderId = leader
+	r.condLeaderId.L.Unlock()
+
+	r.condLeaderId.Signal()
+
+	if changed {
+		log.Infof("Leader is %v", leader)
+	}
+}
+
+// Starts the first election, and then periodically reasserts the leadership
+// if this restarter is the leader, or starts an election if there is no
+// leader. Otherwise, restarters that lost the messages of an election could
+// be left without a leader, or believing to be the leader
+func (r *Restarter) keepLeader(ctx context.Context) {
+	select {
+	case <-time.After(r.election.Delay):
+	case <-ctx.Done():
+		return
+	}
+
+	if r.isMember() {
+		err := r.startElection(ctx)
+		if err != nil {
+			log.Errorf("Failed to start election: %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(r.election.Refresh)
+	defer ticker.Stop()
+	for {
+		if !r.isMember() {
+			// restarters outside the cluster take no part in elections
+			// until they join it
+			r.join(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.isMember() {
+			continue
+		}
+
+		var err error
+		switch r.Leader() {
+		case r.id:
+			err = r.reassertLeadership(ctx)
+		case -1:
+			err = r.startElection(ctx)
+		default:
+			continue
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("Failed to keep leader: %v", err)
+		}
+	}
+}
+
+// The leader announces itself and the membership periodically, so that
+// restarters that missed them catch up
+func (r *Restarter) reassertLeadership(ctx context.Context) error {
+	r.propagateMembership(ctx)
+	if r.election.Algorithm == BULLY_ELECTION {
+		return r.announceLeader(ctx)
+	}
+	return r.startCoordinator(ctx, []uint64{uint64(r.id)})
+}
+
 func (r *Restarter) startElection(ctx context.Context) error {
+	if r.election.Algorithm == BULLY_ELECTION {
+		return r.startBullyElection(ctx)
+	}
+
 	log.Infof("Starting election")
 
 	e := &Election{Ids: []uint64{uint64(r.id)}}
@@ -31,6 +147,14 @@ func (r *Restarter) startElection(ctx context.Context) error {
 }
 
 func (r *Restarter) handleElection(ctx context.Context, msg Election) error {
+	if !r.isMember() {
+		log.Debugf("Ignoring election, not part of the cluster")
+		return nil
+	}
+	if r.election.Algorithm == BULLY_ELECTION {
+		return r.handleBullyElection(ctx, msg)
+	}
+
 	log.Infof("Received Election message with ids: %v", idsToString(msg.Ids))
 
 	if slices.Contains(msg.Ids, uint64(r.id)) {
@@ -42,16 +166,8 @@ func (r *Restarter) handleElection(ctx context.Context, msg Election) error {
 }
 
 func (r *Restarter) startCoordinator(ctx context.Context, ids []uint64) error {
-	log.Infof("Starting coordinator")
-
 	leader := slices.Max(ids)
-	r.condLeaderId.L.Lock()
-	r.leaderId = int(leader)
-	r.condLeaderId.L.Unlock()
-
-	r.condLeaderId.Signal()
-
-	log.Infof("Leader is %v", leader)
+	r.setLeader(int(leader))
 
 	coor := Coordinator{
 		Leader: leader,
@@ -62,37 +178,47 @@ func (r *Restarter) startCoordinator(ctx context.Context, ids []uint64) error {
 }
 
 func (r *Restarter) handleCoordinator(ctx context.Context, msg Coordinator) error {
-	log.Infof("Received Coordinator message with ids %v", idsToString(msg.Ids))
+	if !r.hasRestarter(int(msg.Leader)) {
+		// the coordinator left the cluster after announcing itself
+		log.Debugf("Ignoring coordinator %v, not part of the cluster", msg.Leader)
+		return nil
+	}
+	if r.election.Algorithm == BULLY_ELECTION {
+		return r.handleBullyCoordinator(ctx, msg)
+	}
+
+	log.Debugf("Received Coordinator message with ids %v", idsToString(msg.Ids))
 
 	if slices.Contains(msg.Ids, uint64(r.id)) {
 		return nil
 	}
 
-	r.condLeaderId.L.Lock()
-	r.leaderId = int(msg.Leader)
-	r.condLeaderId.L.Unlock()
-
-	r.condLeaderId.Signal()
+	// the election missed this restarter, which should have won it
+	if int(msg.Leader) < r.id && r.isMember() {
+		log.Infof("Coordinator %v has a lower id, starting election", msg.Leader)
+		return r.startElection(ctx)
+	}
 
-	log.Infof("Leader is %v", msg.Leader)
+	r.setLeader(int(msg.Leader))
 
 	msg.Ids = append(msg.Ids, uint64(r.id))
 	return r.sendToRing(ctx, msg)
 }
 
+// Sends the message to the next restarter of the ring, skipping the ones that
+// do not answer. The ring includes this restarter, so the message is only
+// lost if not even this one answers
 func (r *Restarter) sendToRing(ctx context.Context, msg Message) error {
-	next := r.id + 1
-	for {
-		host := fmt.Sprintf("%v%v", RESTARTER_NAME, next%r.replicas)
-
-		err := r.safeSend(ctx, msg, host, utils.RESTARTER_PORT)
+	var err error
+	for _, next := range r.ring() {
+		err = r.safeSend(ctx, msg, restarterName(next), utils.RESTARTER_PORT)
 		if !errors.Is(err, ErrFallenNode) {
 			return err
 		}
 
-		log.Errorf("Neighbor %v is not answering, sending message to next one", next%r.replicas)
-		next += 1
+		log.Errorf("Neighbor %v is not answering, sending message to next one", next)
 	}
+	return err
 }
 
 func idsToString(ids []uint64) string {
@@ -0,0 +1,272 @@
+package restarter
+
+import (
+	"context"
+	"distribuidos/tp1/utils"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ErrNoLeader = errors.New("there is no leader")
+
+// Nodes and restarters join and leave the cluster by notifying the leader,
+// either directly or through any other restarter, which relays it. The
+// leader applies the change and propagates the membership to the other
+// restarters, so that any of them can take over the monitoring
+
+// Copy of the current membership
+func (r *Restarter) Members() Membership {
+	r.membersMu.Lock()
+	defer r.membersMu.Unlock()
+	return Membership{
+		Version:    r.members.Version,
+		Restarters: slices.Clone(r.members.Restarters),
+		Nodes:      slices.Clone(r.members.Nodes),
+	}
+}
+
+// Leaves the cluster, so that the restarter is not restarted once it stops
+func (r *Restarter) Leave(ctx context.Context) error {
+	r.membersMu.Lock()
+	r.left = true
+	r.membersMu.Unlock()
+
+	msg := Leave{Name: restarterName(r.id)}
+	switch leader := r.Leader(); leader {
+	case r.id:
+		err := r.handleLeave(ctx, msg)
+		if err != nil {
+			return err
+		}
+		// no one propagates the membership once the leader is gone, so it
+		// waits for the acks of the rest
+		if !r.sendToAll(ctx, r.Members(), r.otherRestarters()) {
+			log.Warningf("No restarter acknowledged the membership")
+		}
+		return nil
+	case -1:
+		return ErrNoLeader
+	default:
+		return r.safeSend(ctx, msg, restarterName(leader), utils.RESTARTER_PORT)
+	}
+}
+
+func (r *Restarter) handleJoin(ctx context.Context, msg Join) error {
+	if id, ok := restarterId(msg.Name); ok && r.replog != nil && id > MAX_LOG_RESTARTER_ID {
+		return fmt.Errorf("%w: %v", ErrRestarterId, msg.Name)
+	}
+	if !r.relayToLeader(ctx, msg) {
+		return nil
+	}
+	return r.changeMembership(ctx, func(m *Membership) bool {
+		if id, ok := restarterId(msg.Name); ok {
+			return insertSorted(&m.Restarters, uint64(id))
+		}
+		return insertSorted(&m.Nodes, msg.Name)
+	})
+}
+
+func (r *Restarter) handleLeave(ctx context.Context, msg Leave) error {
+	if !r.relayToLeader(ctx, msg) {
+		return nil
+	}
+	return r.changeMembership(ctx, func(m *Membership) bool {
+		if id, ok := restarterId(msg.Name); ok {
+			return removeSorted(&m.Restarters, uint64(id))
+		}
+		return removeSorted(&m.Nodes, msg.Name)
+	})
+}
+
+// Sends the message to the leader, unless this restarter is the leader, in
+// which case it returns true so that the message is handled here
+func (r *Restarter) relayToLeader(ctx context.Context, msg Message) bool {
+	leader := r.Leader()
+	if leader == r.id {
+		return true
+	}
+	if leader == -1 {
+		log.Warningf("Dropping %T message, %v", msg, ErrNoLeader)
+		return false
+	}
+
+	go func() {
+		err := r.safeSend(ctx, msg, restarterName(leader), utils.RESTARTER_PORT)
+		if err != nil {
+			log.Errorf("Failed to relay %T message to the leader: %v", msg, err)
+		}
+	}()
+	return false
+}
+
+// Applies a change to the membership as leader, and propagates it
+func (r *Restarter) changeMembership(ctx context.Context, change func(*Membership) bool) error {
+	r.membersMu.Lock()
+	changed := change(&r.members)
+	if changed {
+		r.members.Version += 1
+		r.notifyMembersChange()
+	}
+	r.membersMu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	members := r.Members()
+	log.Infof("Membership changed to version %v: restarters %v, nodes %v", members.Version, idsToString(members.Restarters), members.Nodes)
+
+	r.syncStatuses()
+	r.propagateMembership(ctx)
+
+	if !slices.Contains(members.Restarters, uint64(r.id)) {
+		// the leader left the cluster
+		r.setLeader(-1)
+	}
+	return nil
+}
+
+// Sends the membership to the other restarters. It is not retried, as the
+// leader propagates it periodically
+func (r *Restarter) propagateMembership(ctx context.Context) {
+	members := r.Members()
+	for _, id := range members.Restarters {
+		if int(id) == r.id {
+			continue
+		}
+		r.notify(ctx, members, restarterName(int(id)), utils.RESTARTER_PORT)
+	}
+}
+
+func (r *Restarter) handleMembership(ctx context.Context, msg Membership) error {
+	r.membersMu.Lock()
+	if msg.Version < r.members.Version {
+		r.membersMu.Unlock()
+		return nil
+	}
+	changed := msg.Version != r.members.Version ||
+		!slices.Equal(msg.Restarters, r.members.Restarters) ||
+		!slices.Equal(msg.Nodes, r.members.Nodes)
+	r.members = msg
+	if changed {
+		r.notifyMembersChange()
+	}
+	r.membersMu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	log.Infof("Membership updated to version %v", msg.Version)
+
+	leader := r.Leader()
+	if leader != -1 && !slices.Contains(msg.Restarters, uint64(leader)) {
+		log.Infof("Leader %v left the cluster", leader)
+		r.setLeader(-1)
+		return r.startElection(ctx)
+	}
+	return nil
+}
+
+// requires membersMu
+func (r *Restarter) notifyMembersChange() {
+	close(r.membersChanged)
+	r.membersChanged = make(chan struct{})
+}
+
+// Whether the restarter is part of the cluster. A restarter that left is
+// never part of it again
+func (r *Restarter) isMember() bool {
+	r.membersMu.Lock()
+	defer r.membersMu.Unlock()
+	return !r.left && slices.Contains(r.members.Restarters, uint64(r.id))
+}
+
+func (r *Restarter) hasRestarter(id int) bool {
+	r.membersMu.Lock()
+	defer r.membersMu.Unlock()
+	return slices.Contains(r.members.Restarters, uint64(id))
+}
+
+// Asks the known restarters to join the cluster
+func (r *Restarter) join(ctx context.Context) {
+	r.membersMu.Lock()
+	left := r.left
+	r.membersMu.Unlock()
+	if left {
+		return
+	}
+
+	for _, id := range r.Members().Restarters {
+		if int(id) != r.id {
+			r.notify(ctx, Join{Name: restarterName(r.id)}, restarterName(int(id)), utils.RESTARTER_PORT)
+		}
+	}
+}
+
+// Restarters of the cluster other than this one, sorted by id
+func (r *Restarter) otherRestarters() []int {
+	others := make([]int, 0)
+	for _, id := range r.Members().Restarters {
+		if int(id) != r.id {
+			others = append(others, int(id))
+		}
+	}
+	return others
+}
+
+// Restarters of the ring, starting by the one after this restarter and
+// ending with this one
+func (r *Restarter) ring() []int {
+	others := r.otherRestarters()
+	next, _ := slices.BinarySearch(others, r.id)
+	return slices.Concat(others[next:], others[:next], []int{r.id})
+}
+
+// Sends the message without waiting for an ack
+func (r *Restarter) notify(ctx context.Context, msg Message, name string, port int) {
+	addr, err := r.network.Resolve(name, port)
+	if err != nil {
+		log.Debugf("Failed to resolve %v: %v", name, err)
+		return
+	}
+
+	packet := Packet{Id: r.newMsgId(), Msg: msg}
+	r.forgetMsg(packet.Id)
+	err = r.send(ctx, packet, addr, 0)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Warningf("Failed to send %T message to %v: %v", msg, name, err)
+	}
+}
+
+func restarterName(id int) string {
+	return fmt.Sprintf("%v%v", RESTARTER_NAME, id)
+}
+
+func restarterId(name string) (int, bool) {
+	if !strings.HasPrefix(name, RESTARTER_NAME) {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(name, RESTARTER_NAME))
+	return id, err == nil && id >= 0
+}
+
+func insertSorted[T uint64 | string](s *[]T, v T) bool {
+	i, found := slices.BinarySearch(*s, v)
+	if found {
+		return false
+	}
+	*s = slices.Insert(*s, i, v)
+	return true
+}
+
+func removeSorted[T uint64 | string](s *[]T, v T) bool {
+	i, found := slices.BinarySearch(*s, v)
+	if !found {
+		return false
+	}
+	*s = slices.Delete(*s, i, i+1)
+	return true
+}
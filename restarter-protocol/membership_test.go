@@ -0,0 +1,105 @@
+package restarter_test
+
+import (
+	"context"
+	"distribuidos/tp1/restarter-protocol"
+	"distribuidos/tp1/utils"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Waits until every alive restarter has a membership satisfying the condition
+func (c *cluster) waitMembers(t *testing.T, cond func(restarter.Membership) bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		members := make([]restarter.Membership, 0)
+		agreed := true
+		for id, r := range c.restarters {
+			if !c.alive[id] {
+				continue
+			}
+			members = append(members, r.Members())
+			agreed = agreed && cond(r.Members())
+		}
+		if agreed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Restarters did not agree on the membership, they have %+v", members)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func hasRestarters(ids ...uint64) func(restarter.Membership) bool {
+	return func(m restarter.Membership) bool {
+		return slices.Equal(m.Restarters, ids)
+	}
+}
+
+func TestRestarterJoinsAndLeaves(t *testing.T) {
+	for _, algorithm := range []string{restarter.RING_ELECTION, restarter.BULLY_ELECTION} {
+		t.Run(algorithm, func(t *testing.T) {
+			c := startCluster(t, algorithm, newSimNetwork(1, faults{}))
+			c.waitLeader(t, REPLICAS-1, 10*time.Second)
+
+			// the new restarter joins, and wins the election by its id
+			c.start(t, REPLICAS)
+			c.waitMembers(t, hasRestarters(0, 1, 2, 3, 4), 10*time.Second)
+			c.waitLeader(t, REPLICAS, 10*time.Second)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := c.restarters[REPLICAS].Leave(ctx)
+			if err != nil {
+				t.Fatalf("Failed to leave: %v", err)
+			}
+			c.kill(REPLICAS)
+
+			c.waitMembers(t, hasRestarters(0, 1, 2, 3), 10*time.Second)
+			c.waitLeader(t, REPLICAS-1, 10*time.Second)
+		})
+	}
+}
+
+func TestNodeJoinsAndLeaves(t *testing.T) {
+	network := newSimNetwork(1, faults{})
+	c := startCluster(t, restarter.RING_ELECTION, network)
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+
+	// the simulated network only knows restarters, so the node borrows the
+	// address of one that does not exist
+	node, err := network.Listen(fmt.Sprintf("%v200:%v", restarter.RESTARTER_NAME, utils.NODE_PORT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	// any restarter relays the requests to the leader
+	follower, err := network.Resolve(restarter.RESTARTER_NAME+"0", utils.RESTARTER_PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(msg restarter.Message) {
+		buf, err := restarter.Packet{Id: 1, Msg: msg}.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = node.WriteToUDP(buf, follower)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(restarter.Join{Name: "q1-filter-1"})
+	c.waitMembers(t, func(m restarter.Membership) bool {
+		return slices.Contains(m.Nodes, "q1-filter-1")
+	}, 10*time.Second)
+
+	send(restarter.Leave{Name: "q1-filter-1"})
+	c.waitMembers(t, func(m restarter.Membership) bool {
+		return !slices.Contains(m.Nodes, "q1-filter-1")
+	}, 10*time.Second)
+}
@@ -1,6 +1,11 @@
 package restarter
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"time"
+)
 
 type Message interface {
 	Encode(buf []byte) ([]byte, error)
@@ -19,8 +24,18 @@ const (
 	CoordinatorMsg MsgType = 'C'
 	ElectionMsg    MsgType = 'E'
 	KeepAliveMsg   MsgType = 'K'
+	HeartbeatMsg   MsgType = 'H'
+	JoinMsg        MsgType = 'J'
+	LeaveMsg       MsgType = 'L'
+	MembershipMsg  MsgType = 'M'
+	PrepareMsg     MsgType = 'P'
+	PromiseMsg     MsgType = 'O'
+	AppendMsg      MsgType = 'N'
+	AppendReplyMsg MsgType = 'R'
 )
 
+var ErrInvalidMessage = errors.New("invalid message")
+
 func Decode(buf []byte) (Packet, error) {
 	var ty MsgType
 	n, err := binary.Decode(buf, binary.LittleEndian, &ty)
@@ -46,6 +61,24 @@ func Decode(buf []byte) (Packet, error) {
 		msg, err = DecodeElection(buf)
 	case KeepAliveMsg:
 		msg, err = DecodeKeepAlive(buf)
+	case HeartbeatMsg:
+		msg, err = DecodeHeartbeat(buf)
+	case JoinMsg:
+		msg, err = DecodeJoin(buf)
+	case LeaveMsg:
+		msg, err = DecodeLeave(buf)
+	case MembershipMsg:
+		msg, err = DecodeMembership(buf)
+	case PrepareMsg:
+		msg, err = DecodePrepare(buf)
+	case PromiseMsg:
+		msg, err = DecodePromise(buf)
+	case AppendMsg:
+		msg, err = DecodeAppend(buf)
+	case AppendReplyMsg:
+		msg, err = DecodeAppendReply(buf)
+	default:
+		err = ErrInvalidMessage
 	}
 	if err != nil {
 		return Packet{}, err
@@ -71,6 +104,17 @@ func (p Packet) Encode() ([]byte, error) {
 	return p.Msg.Encode(buf)
 }
 
+// Whether the receiver answers the message with an ack. Heartbeats and the
+// messages of the replicated log are not acked, as they are sent periodically
+func needsAck(msg Message) bool {
+	switch msg.(type) {
+	case Ack, Heartbeat, Prepare, Promise, Append, AppendReply:
+		return false
+	default:
+		return true
+	}
+}
+
 type Election struct {
 	Ids []uint64
 }
@@ -86,6 +130,134 @@ type Ack struct {
 type KeepAlive struct {
 }
 
+// Pushed periodically by the nodes to the leader, carrying their health
+type Heartbeat struct {
+	Name          string
+	Uptime        time.Duration
+	ActiveClients uint32
+	// Time at which the node finished processing its last batch, zero if
+	// it has not processed any
+	LastBatch time.Time
+}
+
+// Sent by a node or restarter to be monitored by the leader
+type Join struct {
+	Name string
+}
+
+// Sent by a node or restarter that stops on purpose, so that it is no
+// longer monitored
+type Leave struct {
+	Name string
+}
+
+// Restarters and nodes of the cluster, propagated by the leader
+type Membership struct {
+	// Incremented by the leader with every change
+	Version    uint64   `json:"version"`
+	Restarters []uint64 `json:"restarters"`
+	Nodes      []string `json:"nodes"`
+}
+
+// Sent by a new leader to take over the replicated log in its term
+type Prepare struct {
+	Term      uint64
+	From      uint64
+	LastIndex uint64
+	LastTerm  uint64
+	Commit    uint64
+}
+
+// Answer to a Prepare. Restarters with a more up to date log than the
+// leader send it the entries it is missing
+type Promise struct {
+	Term      uint64
+	From      uint64
+	Granted   bool
+	LastIndex uint64
+	LastTerm  uint64
+	LogSegment
+}
+
+// Replicates the log of the leader, and doubles as its heartbeat
+type Append struct {
+	Term   uint64
+	From   uint64
+	Commit uint64
+	LogSegment
+}
+
+type AppendReply struct {
+	Term    uint64
+	From    uint64
+	Success bool
+	// Last index known to match the log of the leader, or a hint of where
+	// the logs diverge if not successful
+	Match uint64
+}
+
+// Entries following the given index, or a snapshot of the state if the
+// entries were already compacted
+type LogSegment struct {
+	PrevIndex uint64
+	PrevTerm  uint64
+	Entries   []LogEntry
+	Snapshot  []byte
+}
+
+type EntryKind uint8
+
+const (
+	NoopEntry EntryKind = iota
+	// A restart of the node begins, and is recorded in its history
+	RestartEntry
+	// The restart of the node finished
+	RestartedEntry
+	QuarantineEntry
+	ReleaseEntry
+	// Monitoring of the node was paused or resumed by an operator
+	PauseEntry
+	ResumeEntry
+)
+
+type LogEntry struct {
+	Term uint64
+	Kind EntryKind
+	Node string
+	At   time.Time
+}
+
+// Fixed size fields of a log entry, followed by the node
+type entryHeader struct {
+	Term uint64
+	Kind EntryKind
+	At   int64
+}
+
+// Fixed size fields of a promise, followed by the log segment
+type promiseHeader struct {
+	Term      uint64
+	From      uint64
+	Granted   bool
+	LastIndex uint64
+	LastTerm  uint64
+}
+
+// Fixed size fields of an append, followed by the log segment
+type appendHeader struct {
+	Term   uint64
+	From   uint64
+	Commit uint64
+}
+
+// Fixed size fields of a heartbeat, followed by the name
+type heartbeatHeader struct {
+	NameLen       uint16
+	Uptime        int64
+	ActiveClients uint32
+	LastBatch     int64
+}
+
 // Encode messages
 func (e Election) Encode(buf []byte) ([]byte, error) { return encodeIds(e.Ids, buf) }
 func (c Coordinator) Encode(buf []byte) ([]byte, error) {
@@ -102,6 +274,102 @@ func (c Coordinator) Encode(buf []byte) ([]byte, error) {
 }
 func (a Ack) Encode(buf []byte) ([]byte, error)       { return buf, nil }
 func (k KeepAlive) Encode(buf []byte) ([]byte, error) { return buf, nil }
+func (h Heartbeat) Encode(buf []byte) ([]byte, error) {
+	var lastBatch int64
+	if !h.LastBatch.IsZero() {
+		lastBatch = h.LastBatch.UnixMilli()
+	}
+	buf, err := binary.Append(buf, binary.LittleEndian, heartbeatHeader{
+		NameLen:       uint16(len(h.Name)),
+		Uptime:        int64(h.Uptime),
+		ActiveClients: h.ActiveClients,
+		LastBatch:     lastBatch,
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	return append(buf, h.Name...), nil
+}
+func (j Join) Encode(buf []byte) ([]byte, error)  { return encodeName(j.Name, buf) }
+func (l Leave) Encode(buf []byte) ([]byte, error) { return encodeName(l.Name, buf) }
+func (m Membership) Encode(buf []byte) ([]byte, error) {
+	buf, err := binary.Append(buf, binary.LittleEndian, m.Version)
+	if err != nil {
+		return []byte{}, err
+	}
+	buf, err = encodeIds(m.Restarters, buf)
+	if err != nil {
+		return []byte{}, err
+	}
+	buf, err = binary.Append(buf, binary.LittleEndian, uint32(len(m.Nodes)))
+	if err != nil {
+		return []byte{}, err
+	}
+	for _, node := range m.Nodes {
+		buf, err = encodeName(node, buf)
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+	return buf, nil
+}
+
+func (p Prepare) Encode(buf []byte) ([]byte, error) {
+	return binary.Append(buf, binary.LittleEndian, p)
+}
+func (p Promise) Encode(buf []byte) ([]byte, error) {
+	buf, err := binary.Append(buf, binary.LittleEndian, promiseHeader{
+		Term:      p.Term,
+		From:      p.From,
+		Granted:   p.Granted,
+		LastIndex: p.LastIndex,
+		LastTerm:  p.LastTerm,
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	return p.LogSegment.encode(buf)
+}
+func (a Append) Encode(buf []byte) ([]byte, error) {
+	buf, err := binary.Append(buf, binary.LittleEndian, appendHeader{Term: a.Term, From: a.From, Commit: a.Commit})
+	if err != nil {
+		return []byte{}, err
+	}
+	return a.LogSegment.encode(buf)
+}
+func (a AppendReply) Encode(buf []byte) ([]byte, error) {
+	return binary.Append(buf, binary.LittleEndian, a)
+}
+
+func (s LogSegment) encode(buf []byte) ([]byte, error) {
+	buf, err := binary.Append(buf, binary.LittleEndian, []uint64{s.PrevIndex, s.PrevTerm})
+	if err != nil {
+		return []byte{}, err
+	}
+	buf, err = binary.Append(buf, binary.LittleEndian, uint32(len(s.Entries)))
+	if err != nil {
+		return []byte{}, err
+	}
+	for _, entry := range s.Entries {
+		var at int64
+		if !entry.At.IsZero() {
+			at = entry.At.UnixMilli()
+		}
+		buf, err = binary.Append(buf, binary.LittleEndian, entryHeader{Term: entry.Term, Kind: entry.Kind, At: at})
+		if err != nil {
+			return []byte{}, err
+		}
+		buf, err = encodeName(entry.Node, buf)
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+	buf, err = binary.Append(buf, binary.LittleEndian, uint32(len(s.Snapshot)))
+	if err != nil {
+		return []byte{}, err
+	}
+	return append(buf, s.Snapshot...), nil
+}
 
 // Decode messages
 func DecodeElection(buf []byte) (Election, error) {
@@ -122,12 +390,166 @@ func DecodeCoordinator(buf []byte) (Coordinator, error) {
 }
 func DecodeAck(buf []byte) (Ack, error)             { return Ack{}, nil }
 func DecodeKeepAlive(buf []byte) (KeepAlive, error) { return KeepAlive{}, nil }
+func DecodeHeartbeat(buf []byte) (Heartbeat, error) {
+	var header heartbeatHeader
+	n, err := binary.Decode(buf, binary.LittleEndian, &header)
+	if err != nil {
+		return Heartbeat{}, err
+	}
+	buf = buf[n:]
+	if len(buf) < int(header.NameLen) {
+		return Heartbeat{}, ErrInvalidMessage
+	}
+
+	h := Heartbeat{
+		Name:          string(buf[:header.NameLen]),
+		Uptime:        time.Duration(header.Uptime),
+		ActiveClients: header.ActiveClients,
+	}
+	if header.LastBatch != 0 {
+		h.LastBatch = time.UnixMilli(header.LastBatch)
+	}
+	return h, nil
+}
+
+func DecodeJoin(buf []byte) (Join, error) {
+	name, _, err := decodeName(buf)
+	return Join{Name: name}, err
+}
+func DecodeLeave(buf []byte) (Leave, error) {
+	name, _, err := decodeName(buf)
+	return Leave{Name: name}, err
+}
+func DecodeMembership(buf []byte) (Membership, error) {
+	var version uint64
+	n, err := binary.Decode(buf, binary.LittleEndian, &version)
+	if err != nil {
+		return Membership{}, err
+	}
+	buf = buf[n:]
+
+	restarters, err := decodeIds(buf)
+	if err != nil {
+		return Membership{}, err
+	}
+	buf = buf[8*(len(restarters)+1):]
+
+	var count uint32
+	n, err = binary.Decode(buf, binary.LittleEndian, &count)
+	if err != nil {
+		return Membership{}, err
+	}
+	buf = buf[n:]
+
+	nodes := make([]string, 0)
+	for i := uint32(0); i < count; i++ {
+		var node string
+		node, buf, err = decodeName(buf)
+		if err != nil {
+			return Membership{}, err
+		}
+		nodes = append(nodes, node)
+	}
+	return Membership{Version: version, Restarters: restarters, Nodes: nodes}, nil
+}
+
+func DecodePrepare(buf []byte) (Prepare, error) {
+	var p Prepare
+	_, err := binary.Decode(buf, binary.LittleEndian, &p)
+	return p, err
+}
+func DecodePromise(buf []byte) (Promise, error) {
+	var header promiseHeader
+	n, err := binary.Decode(buf, binary.LittleEndian, &header)
+	if err != nil {
+		return Promise{}, err
+	}
+	segment, err := decodeSegment(buf[n:])
+	return Promise{
+		Term:       header.Term,
+		From:       header.From,
+		Granted:    header.Granted,
+		LastIndex:  header.LastIndex,
+		LastTerm:   header.LastTerm,
+		LogSegment: segment,
+	}, err
+}
+func DecodeAppend(buf []byte) (Append, error) {
+	var header appendHeader
+	n, err := binary.Decode(buf, binary.LittleEndian, &header)
+	if err != nil {
+		return Append{}, err
+	}
+	segment, err := decodeSegment(buf[n:])
+	return Append{Term: header.Term, From: header.From, Commit: header.Commit, LogSegment: segment}, err
+}
+func DecodeAppendReply(buf []byte) (AppendReply, error) {
+	var a AppendReply
+	_, err := binary.Decode(buf, binary.LittleEndian, &a)
+	return a, err
+}
+
+func decodeSegment(buf []byte) (LogSegment, error) {
+	prev := make([]uint64, 2)
+	n, err := binary.Decode(buf, binary.LittleEndian, prev)
+	if err != nil {
+		return LogSegment{}, err
+	}
+	buf = buf[n:]
+
+	var count uint32
+	n, err = binary.Decode(buf, binary.LittleEndian, &count)
+	if err != nil {
+		return LogSegment{}, err
+	}
+	buf = buf[n:]
+
+	s := LogSegment{PrevIndex: prev[0], PrevTerm: prev[1], Entries: make([]LogEntry, 0)}
+	for i := uint32(0); i < count; i++ {
+		var header entryHeader
+		n, err = binary.Decode(buf, binary.LittleEndian, &header)
+		if err != nil {
+			return LogSegment{}, err
+		}
+		entry := LogEntry{Term: header.Term, Kind: header.Kind}
+		if header.At != 0 {
+			entry.At = time.UnixMilli(header.At)
+		}
+		entry.Node, buf, err = decodeName(buf[n:])
+		if err != nil {
+			return LogSegment{}, err
+		}
+		s.Entries = append(s.Entries, entry)
+	}
+
+	var length uint32
+	n, err = binary.Decode(buf, binary.LittleEndian, &length)
+	if err != nil {
+		return LogSegment{}, err
+	}
+	buf = buf[n:]
+	if len(buf) < int(length) {
+		return LogSegment{}, ErrInvalidMessage
+	}
+	if length > 0 {
+		s.Snapshot = slices.Clone(buf[:length])
+	}
+	return s, nil
+}
 
 // Return message type
 func (e Election) Type() MsgType    { return ElectionMsg }
 func (C Coordinator) Type() MsgType { return CoordinatorMsg }
 func (a Ack) Type() MsgType         { return AckMsg }
 func (k KeepAlive) Type() MsgType   { return KeepAliveMsg }
+func (h Heartbeat) Type() MsgType   { return HeartbeatMsg }
+func (j Join) Type() MsgType        { return JoinMsg }
+func (l Leave) Type() MsgType       { return LeaveMsg }
+func (m Membership) Type() MsgType  { return MembershipMsg }
+func (p Prepare) Type() MsgType     { return PrepareMsg }
+func (p Promise) Type() MsgType     { return PromiseMsg }
+func (a Append) Type() MsgType      { return AppendMsg }
+func (a AppendReply) Type() MsgType { return AppendReplyMsg }
 
 func encodeIds(ids []uint64, buf []byte) ([]byte, error) {
 	seen := uint64(len(ids))
@@ -165,3 +587,25 @@ func decodeIds(msg []byte) ([]uint64, error) {
 	}
 	return ids, nil
 }
+
+func encodeName(name string, buf []byte) ([]byte, error) {
+	buf, err := binary.Append(buf, binary.LittleEndian, uint16(len(name)))
+	if err != nil {
+		return []byte{}, err
+	}
+	return append(buf, name...), nil
+}
+
+// Returns the name and the rest of the buffer
+func decodeName(buf []byte) (string, []byte, error) {
+	var length uint16
+	n, err := binary.Decode(buf, binary.LittleEndian, &length)
+	if err != nil {
+		return "", nil, err
+	}
+	buf = buf[n:]
+	if len(buf) < int(length) {
+		return "", nil, ErrInvalidMessage
+	}
+	return string(buf[:length]), buf[length:], nil
+}
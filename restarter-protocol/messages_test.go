@@ -4,6 +4,7 @@ import (
 	"distribuidos/tp1/restarter-protocol"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSerializeElection(t *testing.T) {
@@ -45,6 +46,93 @@ func TestSerializeCoordinator(t *testing.T) {
 	}
 }
 
+func TestSerializeHeartbeat(t *testing.T) {
+	h := restarter.Heartbeat{
+		Name:          "q3-group-2",
+		Uptime:        90 * time.Second,
+		ActiveClients: 3,
+		LastBatch:     time.UnixMilli(1700000000123),
+	}
+
+	buf, err := h.Encode(nil)
+	if err != nil {
+		t.Fatalf("Failed to encode heartbeat msg: %v", err)
+	}
+	recv_h, err := restarter.DecodeHeartbeat(buf)
+	if err != nil {
+		t.Fatalf("Failed to decode heartbeat msg: %v", err)
+	}
+
+	if !reflect.DeepEqual(h, recv_h) {
+		t.Fatalf("Expected %v, but received %v", h, recv_h)
+	}
+
+	_, err = restarter.DecodeHeartbeat(buf[:len(buf)-1])
+	if err == nil {
+		t.Fatalf("Expected truncated heartbeat to fail")
+	}
+}
+
+func TestSerializeMembership(t *testing.T) {
+	m := restarter.Membership{
+		Version:    7,
+		Restarters: []uint64{0, 1, 4},
+		Nodes:      []string{"gateway", "q3-group-1", "q3-group-2"},
+	}
+
+	buf, err := m.Encode(nil)
+	if err != nil {
+		t.Fatalf("Failed to encode membership msg: %v", err)
+	}
+	recv_m, err := restarter.DecodeMembership(buf)
+	if err != nil {
+		t.Fatalf("Failed to decode membership msg: %v", err)
+	}
+
+	if !reflect.DeepEqual(m, recv_m) {
+		t.Fatalf("Expected %v, but received %v", m, recv_m)
+	}
+
+	_, err = restarter.DecodeMembership(buf[:len(buf)-1])
+	if err == nil {
+		t.Fatalf("Expected truncated membership to fail")
+	}
+}
+
+func TestSerializeAppend(t *testing.T) {
+	a := restarter.Append{
+		Term:   515,
+		From:   3,
+		Commit: 41,
+		LogSegment: restarter.LogSegment{
+			PrevIndex: 40,
+			PrevTerm:  259,
+			Entries: []restarter.LogEntry{
+				{Term: 515, Kind: restarter.NoopEntry},
+				{Term: 515, Kind: restarter.RestartEntry, Node: "q3-group-2", At: time.UnixMilli(1700000000123)},
+			},
+		},
+	}
+
+	buf, err := a.Encode(nil)
+	if err != nil {
+		t.Fatalf("Failed to encode append msg: %v", err)
+	}
+	recv_a, err := restarter.DecodeAppend(buf)
+	if err != nil {
+		t.Fatalf("Failed to decode append msg: %v", err)
+	}
+
+	if !reflect.DeepEqual(a, recv_a) {
+		t.Fatalf("Expected %v, but received %v", a, recv_a)
+	}
+
+	_, err = restarter.DecodeAppend(buf[:len(buf)-1])
+	if err == nil {
+		t.Fatalf("Expected truncated append to fail")
+	}
+}
+
 func TestSerializePacket(t *testing.T) {
 	packetList := []restarter.Packet{
 		{
@@ -63,6 +151,47 @@ func TestSerializePacket(t *testing.T) {
 		{
 			Id:  1,
 			Msg: restarter.Ack{},
+		},
+		{
+			Id:  2,
+			Msg: restarter.Heartbeat{Name: "gateway", Uptime: time.Second},
+		},
+		{
+			Id:  3,
+			Msg: restarter.Join{Name: "restarter-4"},
+		},
+		{
+			Id:  4,
+			Msg: restarter.Leave{Name: "q1-filter-2"},
+		},
+		{
+			Id:  5,
+			Msg: restarter.Membership{Version: 1, Restarters: []uint64{0}, Nodes: []string{}},
+		},
+		{
+			Id:  6,
+			Msg: restarter.Prepare{Term: 259, From: 3, LastIndex: 10, LastTerm: 258, Commit: 9},
+		},
+		{
+			Id: 7,
+			Msg: restarter.Promise{Term: 259, From: 1, Granted: true, LastIndex: 11, LastTerm: 258, LogSegment: restarter.LogSegment{
+				PrevIndex: 10,
+				PrevTerm:  258,
+				Entries:   []restarter.LogEntry{{Term: 258, Kind: restarter.RestartedEntry, Node: "q1-filter-2"}},
+			}},
+		},
+		{
+			Id: 8,
+			Msg: restarter.Append{Term: 259, From: 3, Commit: 11, LogSegment: restarter.LogSegment{
+				PrevIndex: 12,
+				PrevTerm:  259,
+				Entries:   []restarter.LogEntry{},
+				Snapshot:  []byte(`{"nodes":{}}`),
+			}},
+		},
+		{
+			Id:  9,
+			Msg: restarter.AppendReply{Term: 259, From: 1, Success: true, Match: 12},
 		}}
 
 	for _, p := range packetList {
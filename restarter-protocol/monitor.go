@@ -0,0 +1,354 @@
+package restarter
+
+import (
+	"context"
+	"distribuidos/tp1/utils"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time without heartbeats after which the leader announces itself to a node
+const ANNOUNCE_PERIOD = time.Second
+
+type MonitorConfig struct {
+	// Expected interval between heartbeats. Restarters also send keep
+	// alives to their neighbor with this interval, and wait for their ack
+	// for as long
+	Interval time.Duration
+	// Time given to restarted nodes to start, before suspecting them again
+	StartupGrace time.Duration
+	// Failure detector of each node
+	Detector DetectorConfig
+	// Suspicion thresholds by node class, overriding the one of the detector
+	Thresholds map[string]float64
+	// Time given to a replicated restart to finish, see RESTART_LEASE
+	RestartLease time.Duration
+}
+
+func DefaultMonitorConfig() MonitorConfig {
+	return MonitorConfig{
+		Interval:     HEARTBEAT_INTERVAL,
+		StartupGrace: 10 * time.Second,
+		Detector: DetectorConfig{
+			Threshold:     8,
+			MinStdDev:     50 * time.Millisecond,
+			MaxSamples:    100,
+			FirstInterval: HEARTBEAT_INTERVAL,
+		},
+		Thresholds:   make(map[string]float64),
+		RestartLease: RESTART_LEASE,
+	}
+}
+
+// Configuration of the detector of the given node, according to its class
+func (c MonitorConfig) DetectorFor(name string) DetectorConfig {
+	config := c.Detector
+	config.FirstInterval = c.Interval
+	if threshold, ok := c.Thresholds[NodeClass(name)]; ok {
+		config.Threshold = threshold
+	}
+	return config
+}
+
+// Parses suspicion thresholds of the form `class=threshold;class=threshold`
+func ParseThresholds(spec string) (map[string]float64, error) {
+	thresholds := make(map[string]float64)
+	if strings.TrimSpace(spec) == "" {
+		return thresholds, nil
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		class, value, ok := strings.Cut(entry, "=")
+		class = strings.TrimSpace(class)
+		if !ok || class == "" {
+			return nil, fmt.Errorf("invalid threshold entry %q, expected class=threshold", entry)
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid threshold for %v: %q", class, value)
+		}
+		thresholds[class] = threshold
+	}
+
+	return thresholds, nil
+}
+
+// Liveness and last reported health of a node monitored by the leader
+type nodeStatus struct {
+	detector   *PhiAccrualDetector
+	health     Heartbeat
+	lastHeard  time.Time
+	announced  time.Time
+	restarting bool
+	// whether its quarantine is being released, as it was heard again
+	releasing bool
+	// times the node was suspected since this restarter became leader
+	failures int
+}
+
+// Monitors the nodes from their heartbeats until the context is cancelled.
+// Nodes are given a startup grace, as they may still be sending their
+// heartbeats to the previous leader. If restarts are replicated, the log is
+// taken over first
+func (r *Restarter) StartMonitoring(ctx context.Context) {
+	if r.replog != nil {
+		go r.replicate(ctx)
+		if r.takeOver(ctx) != nil {
+			return
+		}
+	}
+
+	r.statusMu.Lock()
+	r.statuses = make(map[string]*nodeStatus)
+	r.statusMu.Unlock()
+	r.syncStatuses()
+
+	defer func() {
+		r.statusMu.Lock()
+		r.statuses = nil
+		r.statusMu.Unlock()
+	}()
+
+	ticker := time.NewTicker(r.monitor.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if r.replog != nil && !r.replog.Leading() {
+				// another restarter took over the log with a
+				// higher term, so it is taken over again
+				if r.takeOver(ctx) != nil {
+					return
+				}
+			}
+			r.checkNodes(ctx, now)
+		}
+	}
+}
+
+// Starts monitoring the nodes that joined the cluster, and stops monitoring
+// the ones that left
+func (r *Restarter) syncStatuses() {
+	nodes := r.Members().Nodes
+	start := time.Now().Add(r.monitor.StartupGrace)
+
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	if r.statuses == nil {
+		return
+	}
+
+	for _, node := range nodes {
+		if _, ok := r.statuses[node]; !ok {
+			r.statuses[node] = &nodeStatus{
+				detector: NewPhiAccrualDetector(r.monitor.DetectorFor(node), start),
+			}
+		}
+	}
+	for node := range r.statuses {
+		if !slices.Contains(nodes, node) {
+			log.Infof("Node %v left, it is no longer monitored", node)
+			delete(r.statuses, node)
+		}
+	}
+}
+
+// Restarts the suspected nodes, and announces the leader to the nodes that
+// are not sending heartbeats
+func (r *Restarter) checkNodes(ctx context.Context, now time.Time) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	for name, status := range r.statuses {
+		if status.restarting || r.history.IsPaused(name) || r.history.IsQuarantined(name) {
+			continue
+		}
+		// restarted by a previous leader, which may still be waiting
+		// for it
+		if r.restartInProgress(name, now) {
+			continue
+		}
+		if status.detector.Suspects(now) {
+			status.restarting = true
+			status.failures += 1
+			go r.restartSuspected(ctx, name, status.detector.Phi(now))
+			continue
+		}
+		if now.Sub(status.lastHeard) > ANNOUNCE_PERIOD && now.Sub(status.announced) > ANNOUNCE_PERIOD {
+			status.announced = now
+			go r.announce(ctx, name)
+		}
+	}
+}
+
+func (r *Restarter) restartSuspected(ctx context.Context, name string, phi float64) {
+	log.Errorf("Node %v has fallen (phi %.2f). Restarting...", name, phi)
+
+	err := r.restartNode(ctx, name)
+	if err != nil && !errors.Is(err, ErrQuarantined) {
+		log.Errorf("Failed to restart %v: %v", name, err)
+	}
+
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	status, ok := r.statuses[name]
+	if !ok {
+		return
+	}
+	status.detector = NewPhiAccrualDetector(r.monitor.DetectorFor(name), time.Now().Add(r.monitor.StartupGrace))
+	status.lastHeard = time.Time{}
+	status.restarting = false
+}
+
+// Tells the node that this restarter is the leader, so that it sends its
+// heartbeats here. Announcements are not retried, as they are repeated
+// while the node is silent
+func (r *Restarter) announce(ctx context.Context, name string) {
+	r.notify(ctx, Coordinator{Leader: uint64(r.id)}, name, utils.NODE_PORT)
+}
+
+func (r *Restarter) handleHeartbeat(ctx context.Context, msg Heartbeat, now time.Time) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	if r.statuses == nil {
+		// not leader
+		return
+	}
+	status, ok := r.statuses[msg.Name]
+	if !ok {
+		// the node was monitored by a previous leader that did not
+		// propagate it, so it joins again
+		go func() {
+			err := r.handleJoin(ctx, Join{Name: msg.Name})
+			if err != nil {
+				log.Errorf("Failed to add %v: %v", msg.Name, err)
+			}
+		}()
+		return
+	}
+	status.detector.Heartbeat(now)
+	status.health = msg
+	status.lastHeard = now
+
+	// releasing goes through the log, so it is not done while receiving
+	if !status.releasing && r.history.IsQuarantined(msg.Name) {
+		status.releasing = true
+		go r.releaseHeard(ctx, msg.Name)
+	}
+}
+
+func (r *Restarter) releaseHeard(ctx context.Context, name string) {
+	r.heard(ctx, name)
+
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	if status, ok := r.statuses[name]; ok {
+		status.releasing = false
+	}
+}
+
+// Last health reported by the node, if it is being monitored and has sent
+// any heartbeat
+func (r *Restarter) NodeHealth(name string) (Heartbeat, bool) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	status, ok := r.statuses[name]
+	if !ok || status.lastHeard.IsZero() {
+		return Heartbeat{}, false
+	}
+	return status.health, true
+}
+
+// Monitors the next restarter of the ring, which changes as restarters join
+// and leave the cluster
+func (r *Restarter) monitorNeighbor(ctx context.Context) {
+	for {
+		r.membersMu.Lock()
+		changed := r.membersChanged
+		r.membersMu.Unlock()
+
+		neighbor := r.ring()[0]
+		neighborCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if neighbor != r.id {
+				r.monitorNode(neighborCtx, restarterName(neighbor), utils.RESTARTER_PORT)
+			}
+		}()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		}
+		cancel()
+		<-done
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Sends keep alives to the node, and restarts it when its failure detector
+// suspects it
+func (r *Restarter) monitorNode(ctx context.Context, name string, port int) {
+	config := r.monitor.DetectorFor(name)
+	detector := NewPhiAccrualDetector(config, time.Now())
+
+	ticker := time.NewTicker(r.monitor.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if detector.Suspects(now) && !r.history.IsPaused(name) && !r.history.IsQuarantined(name) {
+				log.Errorf("Node %v has fallen (phi %.2f). Restarting...", name, detector.Phi(now))
+
+				err := r.restartNode(ctx, name)
+				if err != nil && !errors.Is(err, ErrQuarantined) {
+					log.Errorf("Failed to restart %v: %v", name, err)
+				}
+				detector = NewPhiAccrualDetector(config, time.Now().Add(r.monitor.StartupGrace))
+				continue
+			}
+
+			if r.keepAlive(ctx, name, port) {
+				detector.Heartbeat(time.Now())
+				r.heard(ctx, name)
+			}
+		}
+	}
+}
+
+// Sends a keep alive to the node, returning whether it was acknowledged
+// within the monitoring interval
+func (r *Restarter) keepAlive(ctx context.Context, name string, port int) bool {
+	addr, err := r.network.Resolve(name, port)
+	if err != nil {
+		log.Debugf("Failed to resolve %v: %v", name, err)
+		return false
+	}
+
+	packet := Packet{Id: r.newMsgId(), Msg: KeepAlive{}}
+	err = r.send(ctx, packet, addr, r.monitor.Interval)
+	if err != nil {
+		r.forgetMsg(packet.Id)
+		if !errors.Is(err, ErrTimeout) {
+			log.Errorf("Failed to send keep alive: %v", err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package restarter
+
+import (
+	"distribuidos/tp1/utils"
+	"net"
+)
+
+// Packet connection of a restarter, satisfied by *net.UDPConn
+type Conn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// Network through which restarters reach each other and the nodes. It is
+// replaced in tests to simulate faults
+type Network interface {
+	Listen(address string) (Conn, error)
+	Resolve(host string, port int) (*net.UDPAddr, error)
+}
+
+type UDPNetwork struct{}
+
+func (UDPNetwork) Listen(address string) (Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", udpAddr)
+}
+
+func (UDPNetwork) Resolve(host string, port int) (*net.UDPAddr, error) {
+	return utils.GetUDPAddr(host, port)
+}
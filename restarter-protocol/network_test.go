@@ -0,0 +1,171 @@
+package restarter_test
+
+import (
+	"distribuidos/tp1/restarter-protocol"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults injected by the simulated network on election messages
+type faults struct {
+	Drop      float64
+	Duplicate float64
+	MaxDelay  time.Duration
+}
+
+// In-memory network between restarters, which drops, duplicates and delays
+// the Election, Coordinator, Ack and replicated log packets
+type simNetwork struct {
+	mu     sync.Mutex
+	rand   *rand.Rand
+	faults faults
+	conns  map[string]*simConn
+	// hosts whose packets are dropped, by IP
+	isolated map[string]bool
+}
+
+func newSimNetwork(seed int64, f faults) *simNetwork {
+	return &simNetwork{
+		rand:     rand.New(rand.NewSource(seed)),
+		faults:   f,
+		conns:    make(map[string]*simConn),
+		isolated: make(map[string]bool),
+	}
+}
+
+// Drops every packet sent to or from the host
+func (n *simNetwork) isolate(host string) {
+	addr, err := n.Resolve(host, 0)
+	if err != nil {
+		panic(err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.isolated[addr.IP.String()] = true
+}
+
+// Restarters are given addresses from their ids, other hosts do not exist
+func (n *simNetwork) Resolve(host string, port int) (*net.UDPAddr, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(host, restarter.RESTARTER_NAME))
+	if !strings.HasPrefix(host, restarter.RESTARTER_NAME) || err != nil || id < 0 || id > 250 {
+		return nil, fmt.Errorf("unknown host %v", host)
+	}
+	return &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(id+1)), Port: port}, nil
+}
+
+func (n *simNetwork) Listen(address string) (restarter.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := n.Resolve(host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	conn := &simConn{
+		network: n,
+		addr:    addr,
+		inbox:   make(chan simPacket, 1024),
+		closed:  make(chan struct{}),
+	}
+	n.conns[addr.String()] = conn
+	return conn, nil
+}
+
+func (n *simNetwork) deliver(from *net.UDPAddr, to *net.UDPAddr, data []byte) {
+	n.mu.Lock()
+	conn, ok := n.conns[to.String()]
+	copies, delays := 1, []time.Duration{0}
+	switch restarter.MsgType(data[0]) {
+	case restarter.ElectionMsg, restarter.CoordinatorMsg, restarter.AckMsg,
+		restarter.PrepareMsg, restarter.PromiseMsg, restarter.AppendMsg, restarter.AppendReplyMsg:
+		if n.rand.Float64() < n.faults.Drop {
+			copies = 0
+		} else if n.rand.Float64() < n.faults.Duplicate {
+			copies = 2
+		}
+		delays = make([]time.Duration, copies)
+		for i := range delays {
+			if n.faults.MaxDelay > 0 {
+				delays[i] = time.Duration(n.rand.Int63n(int64(n.faults.MaxDelay)))
+			}
+		}
+	}
+	isolated := n.isolated[from.IP.String()] || n.isolated[to.IP.String()]
+	n.mu.Unlock()
+
+	if !ok || isolated {
+		return
+	}
+	for _, delay := range delays {
+		packet := simPacket{from: from, data: data}
+		time.AfterFunc(delay, func() { conn.receive(packet) })
+	}
+}
+
+type simPacket struct {
+	from *net.UDPAddr
+	data []byte
+}
+
+type simConn struct {
+	network   *simNetwork
+	addr      *net.UDPAddr
+	inbox     chan simPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *simConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case packet := <-c.inbox:
+		return copy(b, packet.data), packet.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *simConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.network.deliver(c.addr, addr, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (c *simConn) receive(packet simPacket) {
+	select {
+	case <-c.closed:
+	case c.inbox <- packet:
+	default:
+		// the buffer of the socket is full
+	}
+}
+
+func (c *simConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *simConn) Close() error {
+	err := errors.New("already closed")
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = nil
+	})
+	return err
+}
@@ -0,0 +1,120 @@
+package restarter
+
+import (
+	"math"
+	"regexp"
+	"sync"
+	"time"
+)
+
+type DetectorConfig struct {
+	// Suspicion level above which a node is considered fallen. A threshold
+	// of 8 means that the chance of a false positive is about 1e-8
+	Threshold float64
+	// Pause tolerated on top of the expected heartbeat interval
+	AcceptablePause time.Duration
+	// Lower bound of the deviation of intervals, so that perfectly regular
+	// heartbeats do not make the detector too sensitive
+	MinStdDev time.Duration
+	// Amount of intervals kept to estimate their distribution
+	MaxSamples int
+	// Interval expected before receiving any heartbeat
+	FirstInterval time.Duration
+}
+
+// Phi accrual failure detector. Instead of deciding whether a node is alive,
+// it outputs a suspicion level that grows with the time since the last
+// heartbeat, scaled by the distribution of the previous inter-arrival times.
+// Safe for concurrent use
+type PhiAccrualDetector struct {
+	config    DetectorConfig
+	mu        sync.Mutex
+	intervals []time.Duration
+	next      int
+	last      time.Time
+}
+
+// Creates a detector that expects heartbeats from the given time on
+func NewPhiAccrualDetector(config DetectorConfig, start time.Time) *PhiAccrualDetector {
+	d := &PhiAccrualDetector{
+		config:    config,
+		intervals: make([]time.Duration, 0, config.MaxSamples),
+		last:      start,
+	}
+	// bootstrap the distribution with the expected interval, so that the
+	// first heartbeats are judged against it
+	deviation := config.FirstInterval / 4
+	d.intervals = append(d.intervals, config.FirstInterval-deviation, config.FirstInterval+deviation)
+	return d
+}
+
+func (d *PhiAccrualDetector) Heartbeat(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Before(d.last) {
+		return
+	}
+	interval := now.Sub(d.last)
+	d.last = now
+
+	if len(d.intervals) < d.config.MaxSamples {
+		d.intervals = append(d.intervals, interval)
+		return
+	}
+	d.intervals[d.next] = interval
+	d.next = (d.next + 1) % len(d.intervals)
+}
+
+// Suspicion level of the node at the given time
+func (d *PhiAccrualDetector) Phi(now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	elapsed := now.Sub(d.last)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	mean, stdDev := d.distribution()
+	return phi(float64(elapsed), mean+float64(d.config.AcceptablePause), stdDev)
+}
+
+func (d *PhiAccrualDetector) Suspects(now time.Time) bool {
+	return d.Phi(now) > d.config.Threshold
+}
+
+// requires lock
+func (d *PhiAccrualDetector) distribution() (float64, float64) {
+	var sum float64
+	for _, interval := range d.intervals {
+		sum += float64(interval)
+	}
+	mean := sum / float64(len(d.intervals))
+
+	var variance float64
+	for _, interval := range d.intervals {
+		variance += (float64(interval) - mean) * (float64(interval) - mean)
+	}
+	variance /= float64(len(d.intervals))
+
+	return mean, math.Max(math.Sqrt(variance), float64(d.config.MinStdDev))
+}
+
+// Computes -log10 of the probability of a heartbeat arriving later than the
+// elapsed time, approximating the normal distribution with a logistic one
+func phi(elapsed float64, mean float64, stdDev float64) float64 {
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
+
+var nodeNumber = regexp.MustCompile(`-\d+$`)
+
+// Class of a node, which is its name without its instance number
+func NodeClass(name string) string {
+	return nodeNumber.ReplaceAllString(name, "")
+}
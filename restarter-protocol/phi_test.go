@@ -0,0 +1,115 @@
+package restarter_test
+
+import (
+	"distribuidos/tp1/restarter-protocol"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var detectorConfig = restarter.DetectorConfig{
+	Threshold:     8,
+	MinStdDev:     10 * time.Millisecond,
+	MaxSamples:    10,
+	FirstInterval: 100 * time.Millisecond,
+}
+
+func TestPhiAccrualDetector(t *testing.T) {
+	start := time.Unix(0, 0)
+	detector := restarter.NewPhiAccrualDetector(detectorConfig, start)
+
+	now := start
+	for i := 0; i < 20; i++ {
+		now = now.Add(100 * time.Millisecond)
+		detector.Heartbeat(now)
+	}
+
+	if phi := detector.Phi(now.Add(100 * time.Millisecond)); phi > 1 {
+		t.Fatalf("Expected low suspicion on a regular heartbeat, got %v", phi)
+	}
+	if detector.Suspects(now.Add(150 * time.Millisecond)) {
+		t.Fatalf("Expected a short delay not to be suspected")
+	}
+	if !detector.Suspects(now.Add(500 * time.Millisecond)) {
+		t.Fatalf("Expected a missing heartbeat to be suspected in under a second")
+	}
+
+	previous := 0.0
+	for elapsed := 50 * time.Millisecond; elapsed < time.Second; elapsed += 50 * time.Millisecond {
+		phi := detector.Phi(now.Add(elapsed))
+		if phi < previous {
+			t.Fatalf("Expected suspicion to grow over time, got %v after %v", phi, previous)
+		}
+		previous = phi
+	}
+}
+
+func TestPhiAccrualDetectorAdapts(t *testing.T) {
+	start := time.Unix(0, 0)
+	detector := restarter.NewPhiAccrualDetector(detectorConfig, start)
+
+	// a node that regularly pauses, like one collecting garbage
+	now := start
+	for i := 0; i < 20; i++ {
+		interval := 100 * time.Millisecond
+		if i%2 == 0 {
+			interval = time.Second
+		}
+		now = now.Add(interval)
+		detector.Heartbeat(now)
+	}
+
+	if detector.Suspects(now.Add(time.Second)) {
+		t.Fatalf("Expected usual pauses not to be suspected, phi %v", detector.Phi(now.Add(time.Second)))
+	}
+	if !detector.Suspects(now.Add(10 * time.Second)) {
+		t.Fatalf("Expected long pauses to be suspected")
+	}
+}
+
+func TestPhiAccrualDetectorGrace(t *testing.T) {
+	start := time.Unix(0, 0)
+	detector := restarter.NewPhiAccrualDetector(detectorConfig, start.Add(time.Second))
+
+	if phi := detector.Phi(start.Add(500 * time.Millisecond)); phi != 0 {
+		t.Fatalf("Expected no suspicion before starting, got %v", phi)
+	}
+	if !detector.Suspects(start.Add(2 * time.Second)) {
+		t.Fatalf("Expected a node that never started to be suspected")
+	}
+}
+
+func TestDetectorFor(t *testing.T) {
+	config := restarter.DefaultMonitorConfig()
+	config.Thresholds = map[string]float64{"language-filter": 16}
+
+	if threshold := config.DetectorFor("language-filter-2").Threshold; threshold != 16 {
+		t.Fatalf("Expected threshold of the class, got %v", threshold)
+	}
+	if threshold := config.DetectorFor("q1-count-1").Threshold; threshold != config.Detector.Threshold {
+		t.Fatalf("Expected default threshold, got %v", threshold)
+	}
+	if class := restarter.NodeClass("q3-group-2"); class != "q3-group" {
+		t.Fatalf("Expected class q3-group, got %v", class)
+	}
+	if class := restarter.NodeClass("gateway"); class != "gateway" {
+		t.Fatalf("Expected class gateway, got %v", class)
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	thresholds, err := restarter.ParseThresholds("language-filter=16; q3-group = 12")
+	if err != nil {
+		t.Fatalf("Failed to parse thresholds: %v", err)
+	}
+	expected := map[string]float64{"language-filter": 16, "q3-group": 12}
+	if !reflect.DeepEqual(thresholds, expected) {
+		t.Fatalf("Expected %v, got %v", expected, thresholds)
+	}
+
+	for _, spec := range []string{"language-filter", "=3", "q3-group=high", "q3-group=-1"} {
+		if _, err := restarter.ParseThresholds(spec); err == nil {
+			t.Fatalf("Expected %q to fail", spec)
+		}
+	}
+}
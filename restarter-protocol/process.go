@@ -0,0 +1,169 @@
+package restarter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Time given to processes to stop before killing them
+const STOP_TIMEOUT = 5 * time.Second
+
+var ErrUnknownNode = errors.New("unknown node")
+
+// Command that launches a node as a local process
+type ProcessConfig struct {
+	Command []string          `yaml:"command"`
+	Env     map[string]string `yaml:"env"`
+	// Working directory of the node, defaults to the one of the restarter
+	Dir string `yaml:"dir"`
+}
+
+// Reads the command of each node from a YAML file, keyed by node name
+func LoadProcesses(path string) (map[string]ProcessConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var processes map[string]ProcessConfig
+	err = yaml.Unmarshal(data, &processes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid processes file: %w", err)
+	}
+	for name, process := range processes {
+		if len(process.Command) == 0 {
+			return nil, fmt.Errorf("node %v has no command", name)
+		}
+	}
+	return processes, nil
+}
+
+// Supervises nodes launched as local processes. The PID of each node is
+// tracked in a file, so that any restarter of the host can stop a node
+// before launching it again. The output of each node is appended to a log
+// file next to its PID file
+type ProcessBackend struct {
+	processes map[string]ProcessConfig
+	dir       string
+	mu        sync.Mutex
+}
+
+func NewProcessBackend(processes map[string]ProcessConfig, dir string) (*ProcessBackend, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessBackend{processes: processes, dir: dir}, nil
+}
+
+func (p *ProcessBackend) Restart(ctx context.Context, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.processes[name]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownNode, name)
+	}
+	err := p.stop(ctx, name)
+	if err != nil {
+		return err
+	}
+	return p.launch(name)
+}
+
+// Returns the PID of the node, if it is running
+func (p *ProcessBackend) Pid(name string) (int, bool) {
+	data, err := os.ReadFile(p.pidPath(name))
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || !alive(pid) {
+		return 0, false
+	}
+	return pid, true
+}
+
+func (p *ProcessBackend) launch(name string) error {
+	process := p.processes[name]
+
+	output, err := os.OpenFile(filepath.Join(p.dir, name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(process.Command[0], process.Command[1:]...)
+	cmd.Dir = process.Dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = os.Environ()
+	for _, key := range slices.Sorted(maps.Keys(process.Env)) {
+		cmd.Env = append(cmd.Env, key+"="+process.Env[key])
+	}
+	// nodes must not receive the signals sent to the restarter
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		output.Close()
+		return fmt.Errorf("failed to launch %v: %w", name, err)
+	}
+
+	// reap the process when it exits, so that it does not linger as a zombie
+	go func() {
+		_ = cmd.Wait()
+		output.Close()
+	}()
+
+	log.Infof("Launched %v with PID %v", name, cmd.Process.Pid)
+	return os.WriteFile(p.pidPath(name), []byte(strconv.Itoa(cmd.Process.Pid)), 0640)
+}
+
+// Stops the node if it is running, killing it if it does not exit in time
+func (p *ProcessBackend) stop(ctx context.Context, name string) error {
+	pid, running := p.Pid(name)
+	if !running {
+		return nil
+	}
+
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+
+	deadline := time.After(STOP_TIMEOUT)
+	for alive(pid) {
+		select {
+		case <-deadline:
+			log.Warningf("%v did not stop in time, killing it", name)
+			err = syscall.Kill(pid, syscall.SIGKILL)
+			if err != nil && !errors.Is(err, syscall.ESRCH) {
+				return err
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+func (p *ProcessBackend) pidPath(name string) string {
+	return filepath.Join(p.dir, name+".pid")
+}
+
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
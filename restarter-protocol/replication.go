@@ -0,0 +1,726 @@
+package restarter
+
+import (
+	"context"
+	"distribuidos/tp1/database"
+	"distribuidos/tp1/utils"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Entries kept in the log before compacting it, unless configured otherwise
+const DEFAULT_LOG_SIZE = 1024
+
+// Entries sent in a single append
+const MAX_APPEND_ENTRIES = 64
+
+// Restarts are cancelled if they do not finish within the lease, unless
+// configured otherwise. Once it expires, a new leader may restart the node
+// again
+const RESTART_LEASE = 30 * time.Second
+
+const LOG_KEY = "restarter-log"
+
+// Terms end with the id of their leader in their lowest byte, so restarters
+// with greater ids can't use the log
+const MAX_LOG_RESTARTER_ID = 255
+
+var ErrNotLeader = errors.New("not the leader of the log")
+var ErrRestarterId = errors.New("restarter id too large for the log")
+
+// The leader replicates its decisions on the nodes through a Raft-style log,
+// so that a new leader continues from the same restart history. Leaders are
+// still chosen by the election, but before acting, the leader prepares a
+// term of its own with a majority of the restarters, adopting the most up
+// to date log among them, and commits an empty entry. Restarts are only
+// performed once their entry is committed, so a previous leader can no
+// longer restart nodes once a new one took over.
+//
+// Every restarter applies the committed entries to its restart history.
+// Restarters are restarted by their neighbors even without a leader, so
+// their restarts are not replicated.
+
+// Persisted state of the log
+type logState struct {
+	// Highest term seen. Terms end with the id of their leader, so that
+	// no two leaders share one
+	Term          uint64 `json:"term"`
+	SnapshotIndex uint64 `json:"snapshot_index"`
+	SnapshotTerm  uint64 `json:"snapshot_term"`
+	Commit        uint64 `json:"commit"`
+	// Restarts in progress at the snapshot
+	Restarting map[string]time.Time `json:"restarting"`
+	Entries    []LogEntry           `json:"entries"`
+}
+
+// State sent to restarters that fell behind the compacted entries
+type logSnapshot struct {
+	Nodes      map[string]NodeHistory `json:"nodes"`
+	Restarting map[string]time.Time   `json:"restarting"`
+}
+
+type ReplicatedLog struct {
+	db    *database.Database
+	size  int
+	mu    sync.Mutex
+	state logState
+	// index of the last entry applied to the history
+	applied uint64
+	// restarts in progress, by the time they began
+	restarting map[string]time.Time
+	// closed when the log changes
+	changed chan struct{}
+
+	// state of the leader, only while it prepares or leads its term
+	preparing bool
+	leading   bool
+	promises  map[uint64]Promise
+	next      map[uint64]uint64
+	match     map[uint64]uint64
+}
+
+// Opens the log stored at the given path, creating it if it does not exist.
+// Once it has more than size entries, the applied ones are compacted
+func NewReplicatedLog(path string, size int) (*ReplicatedLog, error) {
+	db, err := database.NewDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &ReplicatedLog{
+		db:      db,
+		size:    size,
+		state:   logState{Restarting: make(map[string]time.Time), Entries: make([]LogEntry, 0)},
+		changed: make(chan struct{}),
+	}
+
+	exists, err := db.Exists(LOG_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		file, err := db.Get(LOG_KEY)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		err = json.NewDecoder(file).Decode(&l.state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// committed entries after the snapshot are applied again, which has no
+	// effect on the ones that were already applied
+	l.applied = l.state.SnapshotIndex
+	l.restarting = maps.Clone(l.state.Restarting)
+	if l.restarting == nil {
+		l.restarting = make(map[string]time.Time)
+	}
+	return l, nil
+}
+
+// Current term, and index of the last committed entry
+func (l *ReplicatedLog) Commit() (uint64, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state.Term, l.state.Commit
+}
+
+// Time at which the restart of the node in progress began, if any
+func (l *ReplicatedLog) Restarting(name string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at, ok := l.restarting[name]
+	return at, ok
+}
+
+// Whether a replicated restart of the node began within its lease, and did
+// not finish
+func (r *Restarter) restartInProgress(name string, now time.Time) bool {
+	if r.replog == nil {
+		return false
+	}
+	at, ok := r.replog.Restarting(name)
+	return ok && now.Before(at.Add(r.monitor.RestartLease))
+}
+
+// Takes over the log as leader, returning once an entry of its own term is
+// committed, or with an error once the context is cancelled
+func (r *Restarter) takeOver(ctx context.Context) error {
+	for {
+		err := r.prepareTerm(ctx)
+		if err != nil {
+			return err
+		}
+
+		// entries of previous terms are committed along with this one
+		_, err = r.propose(ctx, LogEntry{Kind: NoopEntry})
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warningf("Failed to take over the log: %v", err)
+	}
+
+	term, commit := r.replog.Commit()
+	log.Infof("Leading the log in term %v, committed up to %v", term, commit)
+	return nil
+}
+
+// Prepares a new term until a majority of the restarters promise it, or the
+// context is cancelled
+func (r *Restarter) prepareTerm(ctx context.Context) error {
+	l := r.replog
+	ticker := time.NewTicker(r.monitor.Interval)
+	defer ticker.Stop()
+
+	for {
+		l.mu.Lock()
+		if !l.preparing && !l.leading {
+			l.startTerm(uint64(r.id))
+			log.Infof("Taking over the log in term %v", l.state.Term)
+			err := l.save()
+			if err != nil {
+				log.Errorf("Failed to save the log: %v", err)
+			}
+		}
+		if l.preparing && l.prepared(uint64(r.id), r.voters) {
+			l.lead()
+		}
+		prepare := Prepare{
+			Term:      l.state.Term,
+			From:      uint64(r.id),
+			LastIndex: l.lastIndex(),
+			LastTerm:  l.termAt(l.lastIndex()),
+			Commit:    l.state.Commit,
+		}
+		leading, changed := l.leading, l.changed
+		l.mu.Unlock()
+
+		if leading {
+			return nil
+		}
+		for _, id := range r.otherRestarters() {
+			r.notify(ctx, prepare, restarterName(id), utils.RESTARTER_PORT)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-changed:
+		}
+	}
+}
+
+// Whether this restarter leads the log
+func (l *ReplicatedLog) Leading() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+// Sends the missing entries to the followers periodically, until the
+// context is cancelled, and stops leading the log
+func (r *Restarter) replicate(ctx context.Context) {
+	defer func() {
+		r.replog.mu.Lock()
+		r.replog.stepDown()
+		r.replog.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(r.monitor.Interval)
+	defer ticker.Stop()
+	for {
+		r.replicateAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Appends the entry as leader, returning its index once it is committed
+func (r *Restarter) propose(ctx context.Context, entry LogEntry) (uint64, error) {
+	l := r.replog
+	l.mu.Lock()
+	if !l.leading {
+		l.mu.Unlock()
+		return 0, ErrNotLeader
+	}
+	entry.Term = l.state.Term
+	l.state.Entries = append(l.state.Entries, entry)
+	index, term := l.lastIndex(), l.state.Term
+	l.advanceCommit(r.history, uint64(r.id), r.voters)
+	err := l.save()
+	l.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	r.replicateAll(ctx)
+
+	for {
+		l.mu.Lock()
+		committed := l.state.Commit >= index
+		lost := !l.leading || l.state.Term != term
+		changed := l.changed
+		l.mu.Unlock()
+
+		if committed {
+			return index, nil
+		}
+		if lost {
+			return 0, ErrNotLeader
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (r *Restarter) replicateAll(ctx context.Context) {
+	for _, id := range r.otherRestarters() {
+		r.replicateTo(ctx, uint64(id))
+	}
+}
+
+func (r *Restarter) replicateTo(ctx context.Context, id uint64) {
+	l := r.replog
+	l.mu.Lock()
+	if !l.leading {
+		l.mu.Unlock()
+		return
+	}
+	next, ok := l.next[id]
+	if !ok {
+		next = l.lastIndex() + 1
+		l.next[id] = next
+	}
+	msg := Append{
+		Term:       l.state.Term,
+		From:       uint64(r.id),
+		Commit:     l.state.Commit,
+		LogSegment: l.segment(next-1, r.history),
+	}
+	l.mu.Unlock()
+
+	r.notify(ctx, msg, restarterName(int(id)), utils.RESTARTER_PORT)
+}
+
+func (r *Restarter) handlePrepare(ctx context.Context, msg Prepare) error {
+	l := r.replog
+	l.mu.Lock()
+	before := l.version()
+	promise := Promise{From: uint64(r.id), LastIndex: l.lastIndex(), LastTerm: l.termAt(l.lastIndex())}
+	if msg.Term >= l.state.Term {
+		l.observeTerm(msg.Term)
+		promise.Granted = true
+
+		// the leader is missing entries, which are either after its last
+		// one, if it matches, or after its commit, which all logs share
+		if upToDate(promise.LastTerm, promise.LastIndex, msg.LastTerm, msg.LastIndex) {
+			after := msg.Commit
+			if msg.LastIndex <= promise.LastIndex && l.termAt(msg.LastIndex) == msg.LastTerm {
+				after = msg.LastIndex
+			}
+			promise.LogSegment = l.segment(after, r.history)
+		}
+	}
+	promise.Term = l.state.Term
+	err := l.saveChanges(before)
+	l.mu.Unlock()
+
+	r.notify(ctx, promise, restarterName(int(msg.From)), utils.RESTARTER_PORT)
+	return err
+}
+
+func (r *Restarter) handlePromise(msg Promise) error {
+	l := r.replog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	before := l.version()
+	if msg.Term > l.state.Term {
+		l.observeTerm(msg.Term)
+		return l.saveChanges(before)
+	}
+	if !l.preparing || msg.Term != l.state.Term || !msg.Granted {
+		return nil
+	}
+
+	if upToDate(msg.LastTerm, msg.LastIndex, l.termAt(l.lastIndex()), l.lastIndex()) {
+		l.install(msg.LogSegment, r.history)
+	}
+	l.promises[msg.From] = msg
+	l.notifyChange()
+	return l.saveChanges(before)
+}
+
+func (r *Restarter) handleAppend(ctx context.Context, msg Append) error {
+	l := r.replog
+	l.mu.Lock()
+	before := l.version()
+	reply := AppendReply{From: uint64(r.id)}
+	if msg.Term >= l.state.Term {
+		l.observeTerm(msg.Term)
+		reply.Success, reply.Match = l.install(msg.LogSegment, r.history)
+		if reply.Success && msg.Commit > l.state.Commit {
+			l.state.Commit = min(msg.Commit, reply.Match)
+			l.apply(r.history)
+		}
+	}
+	reply.Term = l.state.Term
+	err := l.saveChanges(before)
+	l.mu.Unlock()
+
+	r.notify(ctx, reply, restarterName(int(msg.From)), utils.RESTARTER_PORT)
+	return err
+}
+
+func (r *Restarter) handleAppendReply(ctx context.Context, msg AppendReply) error {
+	l := r.replog
+	l.mu.Lock()
+	before := l.version()
+	if msg.Term > l.state.Term {
+		l.observeTerm(msg.Term)
+		err := l.saveChanges(before)
+		l.mu.Unlock()
+		return err
+	}
+	if !l.leading || msg.Term != l.state.Term {
+		l.mu.Unlock()
+		return nil
+	}
+
+	if msg.Success {
+		l.match[msg.From] = max(l.match[msg.From], msg.Match)
+		l.next[msg.From] = l.match[msg.From] + 1
+		l.advanceCommit(r.history, uint64(r.id), r.voters)
+		err := l.saveChanges(before)
+		l.mu.Unlock()
+		return err
+	}
+
+	// the follower is missing entries, so they are sent right away
+	l.next[msg.From] = max(1, min(l.next[msg.From]-1, msg.Match+1))
+	l.mu.Unlock()
+	r.replicateTo(ctx, msg.From)
+	return nil
+}
+
+// Whether a majority of the voters granted. Voters are the restarters of
+// the configuration, as membership changes are not replicated through the
+// log: with the dynamic view, restarters that saw different changes could
+// reach disjoint majorities. Restarters that join later follow the log, but
+// their vote is not counted, and the ones that leave are still counted
+func quorum(voters []uint64, granted func(id uint64) bool) bool {
+	count := 0
+	for _, id := range voters {
+		if granted(id) {
+			count += 1
+		}
+	}
+	return count >= len(voters)/2+1
+}
+
+// Whether a log ending with the first term and index is more up to date than
+// one ending with the second ones
+func upToDate(term uint64, index uint64, otherTerm uint64, otherIndex uint64) bool {
+	return term > otherTerm || (term == otherTerm && index > otherIndex)
+}
+
+// Starts preparing a term higher than any seen, which ends with the id
+// requires lock
+func (l *ReplicatedLog) startTerm(id uint64) {
+	l.state.Term = (l.state.Term>>8+1)<<8 | id
+	l.preparing = true
+	l.leading = false
+	l.promises = make(map[uint64]Promise)
+	l.notifyChange()
+}
+
+// Whether a majority of the voters promised the term, and the log of this
+// restarter is as up to date as theirs
+// requires lock
+func (l *ReplicatedLog) prepared(self uint64, voters []uint64) bool {
+	promised := quorum(voters, func(id uint64) bool {
+		_, ok := l.promises[id]
+		return id == self || ok
+	})
+	if !promised {
+		return false
+	}
+	for _, promise := range l.promises {
+		if upToDate(promise.LastTerm, promise.LastIndex, l.termAt(l.lastIndex()), l.lastIndex()) {
+			return false
+		}
+	}
+	return true
+}
+
+// requires lock
+func (l *ReplicatedLog) lead() {
+	l.preparing = false
+	l.leading = true
+	l.next = make(map[uint64]uint64)
+	l.match = make(map[uint64]uint64)
+	l.notifyChange()
+}
+
+// requires lock
+func (l *ReplicatedLog) stepDown() {
+	if l.preparing || l.leading {
+		l.preparing = false
+		l.leading = false
+		l.notifyChange()
+	}
+}
+
+// A restarter that sees a higher term stops leading its own
+// requires lock
+func (l *ReplicatedLog) observeTerm(term uint64) {
+	if term > l.state.Term {
+		l.state.Term = term
+		l.stepDown()
+	}
+}
+
+// Commits the last entry of the term replicated by a majority of the voters
+// requires lock
+func (l *ReplicatedLog) advanceCommit(history *RestartHistory, self uint64, voters []uint64) {
+	for index := l.lastIndex(); index > l.state.Commit; index-- {
+		if l.termAt(index) != l.state.Term {
+			return
+		}
+		replicated := quorum(voters, func(id uint64) bool {
+			return id == self || l.match[id] >= index
+		})
+		if replicated {
+			l.state.Commit = index
+			l.apply(history)
+			return
+		}
+	}
+}
+
+// Applies the committed entries to the history, and compacts the log
+// requires lock
+func (l *ReplicatedLog) apply(history *RestartHistory) {
+	for l.applied < l.state.Commit {
+		l.applied += 1
+		entry := l.entry(l.applied)
+
+		var err error
+		switch entry.Kind {
+		case RestartEntry:
+			l.restarting[entry.Node] = entry.At
+			err = history.Record(entry.Node, entry.At)
+		case RestartedEntry:
+			delete(l.restarting, entry.Node)
+		case QuarantineEntry:
+			err = history.Quarantine(entry.Node)
+		case ReleaseEntry:
+			err = history.Release(entry.Node)
+		case PauseEntry:
+			err = history.SetPaused(entry.Node, true)
+		case ResumeEntry:
+			err = history.SetPaused(entry.Node, false)
+		}
+		if err != nil {
+			log.Errorf("Failed to apply entry %v to the history: %v", l.applied, err)
+		}
+	}
+	l.notifyChange()
+
+	if len(l.state.Entries) > l.size {
+		compacted := l.applied - l.state.SnapshotIndex
+		l.state.SnapshotTerm = l.termAt(l.applied)
+		l.state.SnapshotIndex = l.applied
+		l.state.Restarting = maps.Clone(l.restarting)
+		l.state.Entries = append([]LogEntry{}, l.state.Entries[compacted:]...)
+	}
+}
+
+// Installs the segment, returning whether it matched the log, and the last
+// index known to match the one of the sender, or a hint of where they
+// diverge otherwise
+// requires lock
+func (l *ReplicatedLog) install(segment LogSegment, history *RestartHistory) (bool, uint64) {
+	if segment.Snapshot != nil {
+		return l.installSnapshot(segment, history)
+	}
+
+	prev, entries := segment.PrevIndex, segment.Entries
+	if prev > l.lastIndex() {
+		return false, l.lastIndex()
+	}
+	if prev < l.state.SnapshotIndex {
+		// compacted entries are committed, so they already match
+		skip := min(uint64(len(entries)), l.state.SnapshotIndex-prev)
+		prev, entries = prev+skip, entries[skip:]
+	} else if l.termAt(prev) != segment.PrevTerm {
+		return false, prev - 1
+	}
+
+	for i, entry := range entries {
+		index := prev + 1 + uint64(i)
+		if index <= l.lastIndex() {
+			if l.termAt(index) == entry.Term {
+				continue
+			}
+			if index <= l.state.Commit {
+				log.Errorf("Refusing to replace committed entry %v", index)
+				return false, l.state.Commit
+			}
+			l.state.Entries = l.state.Entries[:index-l.state.SnapshotIndex-1]
+		}
+		l.state.Entries = append(l.state.Entries, entry)
+	}
+	return true, prev + uint64(len(entries))
+}
+
+// requires lock
+func (l *ReplicatedLog) installSnapshot(segment LogSegment, history *RestartHistory) (bool, uint64) {
+	if segment.PrevIndex <= l.state.Commit {
+		return true, segment.PrevIndex
+	}
+
+	var snapshot logSnapshot
+	err := json.Unmarshal(segment.Snapshot, &snapshot)
+	if err != nil {
+		log.Errorf("Failed to decode snapshot: %v", err)
+		return false, l.state.Commit
+	}
+	err = history.restoreReplicated(snapshot.Nodes)
+	if err != nil {
+		log.Errorf("Failed to restore snapshot: %v", err)
+		return false, l.state.Commit
+	}
+
+	if segment.PrevIndex < l.lastIndex() && l.termAt(segment.PrevIndex) == segment.PrevTerm {
+		l.state.Entries = append([]LogEntry{}, l.state.Entries[segment.PrevIndex-l.state.SnapshotIndex:]...)
+	} else {
+		l.state.Entries = make([]LogEntry, 0)
+	}
+	l.state.SnapshotIndex = segment.PrevIndex
+	l.state.SnapshotTerm = segment.PrevTerm
+	l.state.Commit = segment.PrevIndex
+	l.restarting = snapshot.Restarting
+	if l.restarting == nil {
+		l.restarting = make(map[string]time.Time)
+	}
+	l.state.Restarting = maps.Clone(l.restarting)
+	l.applied = segment.PrevIndex
+	log.Infof("Installed snapshot up to entry %v", segment.PrevIndex)
+	return true, segment.PrevIndex
+}
+
+// Entries after the index, or a snapshot of the history if they were
+// already compacted
+// requires lock
+func (l *ReplicatedLog) segment(after uint64, history *RestartHistory) LogSegment {
+	if after < l.state.SnapshotIndex {
+		// the history is ahead of the snapshot, which has no effect, as
+		// entries are applied again on top of it
+		snapshot, err := json.Marshal(logSnapshot{
+			Nodes:      history.replicated(time.Now()),
+			Restarting: l.state.Restarting,
+		})
+		if err != nil || len(snapshot) > MAX_PACKAGE_SIZE/2 {
+			log.Errorf("Failed to build snapshot of %v bytes: %v", len(snapshot), err)
+			return LogSegment{PrevIndex: l.lastIndex(), PrevTerm: l.termAt(l.lastIndex())}
+		}
+		return LogSegment{PrevIndex: l.state.SnapshotIndex, PrevTerm: l.state.SnapshotTerm, Snapshot: snapshot}
+	}
+
+	after = min(after, l.lastIndex())
+	start := after - l.state.SnapshotIndex
+	end := min(uint64(len(l.state.Entries)), start+MAX_APPEND_ENTRIES)
+	return LogSegment{
+		PrevIndex: after,
+		PrevTerm:  l.termAt(after),
+		// copied, as it is encoded without the lock
+		Entries: slices.Clone(l.state.Entries[start:end]),
+	}
+}
+
+// requires lock
+func (l *ReplicatedLog) lastIndex() uint64 {
+	return l.state.SnapshotIndex + uint64(len(l.state.Entries))
+}
+
+// Term of the entry, or zero if it was compacted
+// requires lock
+func (l *ReplicatedLog) termAt(index uint64) uint64 {
+	if index == l.state.SnapshotIndex {
+		return l.state.SnapshotTerm
+	}
+	if index < l.state.SnapshotIndex || index > l.lastIndex() {
+		return 0
+	}
+	return l.entry(index).Term
+}
+
+// requires lock
+func (l *ReplicatedLog) entry(index uint64) LogEntry {
+	return l.state.Entries[index-l.state.SnapshotIndex-1]
+}
+
+// requires lock
+func (l *ReplicatedLog) notifyChange() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Position of the log, which changes along with its persisted state. Logs
+// with the same last term and index have the same entries
+type logVersion struct {
+	term, commit, snapshotIndex, lastIndex, lastTerm uint64
+}
+
+// requires lock
+func (l *ReplicatedLog) version() logVersion {
+	return logVersion{l.state.Term, l.state.Commit, l.state.SnapshotIndex, l.lastIndex(), l.termAt(l.lastIndex())}
+}
+
+// Saves the log if it changed since the given version
+// requires lock
+func (l *ReplicatedLog) saveChanges(before logVersion) error {
+	if l.version() == before {
+		return nil
+	}
+	return l.save()
+}
+
+// requires lock
+func (l *ReplicatedLog) save() (err error) {
+	snapshot, err := l.db.NewSnapshot()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, snapshot.Abort())
+			return
+		}
+		err = snapshot.Commit()
+	}()
+
+	file, err := snapshot.Create(LOG_KEY)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(file).Encode(l.state)
+}
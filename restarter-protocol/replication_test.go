@@ -0,0 +1,353 @@
+package restarter_test
+
+import (
+	"context"
+	"distribuidos/tp1/restarter-protocol"
+	"distribuidos/tp1/utils"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Restarts quickly, and quarantines nodes after a few of them
+var replicatedPolicy = restarter.RestartPolicy{
+	BaseBackoff: 10 * time.Millisecond,
+	MaxBackoff:  50 * time.Millisecond,
+	Window:      time.Minute,
+	MaxRestarts: 3,
+}
+
+func startReplicatedCluster(t *testing.T, network *simNetwork, policy restarter.RestartPolicy, logSize int, nodes ...string) *cluster {
+	c := &cluster{
+		algorithm: restarter.BULLY_ELECTION,
+		network:   network,
+		nodes:     nodes,
+		policy:    policy,
+		logSize:   logSize,
+	}
+	c.startAll(t)
+	return c
+}
+
+// Monitors the nodes while the restarter is the leader, as its command does
+func monitorWhileLeader(ctx context.Context, r *restarter.Restarter, id int) {
+	var cancel context.CancelFunc
+	for ctx.Err() == nil {
+		leader := r.Leader() == id
+		if leader && cancel == nil {
+			var monitorCtx context.Context
+			monitorCtx, cancel = context.WithCancel(ctx)
+			go r.StartMonitoring(monitorCtx)
+		} else if !leader && cancel != nil {
+			cancel()
+			cancel = nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Waits until the node satisfies the condition in the history of every
+// alive restarter
+func (c *cluster) waitNode(t *testing.T, name string, cond func(restarter.NodeStatus) bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		nodes := make([]restarter.NodeStatus, 0)
+		agreed := true
+		for id, r := range c.restarters {
+			if !c.alive[id] {
+				continue
+			}
+			node, ok := nodeStatus(r, name)
+			nodes = append(nodes, node)
+			agreed = agreed && ok && cond(node)
+		}
+		if agreed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Restarters did not agree on %v, they have %+v", name, nodes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func nodeStatus(r *restarter.Restarter, name string) (restarter.NodeStatus, bool) {
+	nodes := r.Status().Nodes
+	i := slices.IndexFunc(nodes, func(n restarter.NodeStatus) bool { return n.Name == name })
+	if i == -1 {
+		return restarter.NodeStatus{}, false
+	}
+	return nodes[i], true
+}
+
+func quarantinedAfter(restarts int) func(restarter.NodeStatus) bool {
+	return func(n restarter.NodeStatus) bool {
+		return n.Quarantined && len(n.Restarts) == restarts
+	}
+}
+
+// Restarts of the node performed by the restarter
+func (c *cluster) restarts(id int, name string) int {
+	count := 0
+	for _, restarted := range c.backends[id].Restarts() {
+		if restarted == name {
+			count += 1
+		}
+	}
+	return count
+}
+
+func TestReplicatedRestarts(t *testing.T) {
+	// the log is small, so that it is compacted
+	c := startReplicatedCluster(t, newSimNetwork(1, electionFaults["lossy"]), replicatedPolicy, 4, "q1-filter-1")
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+
+	// the node never sends heartbeats, so it is restarted until quarantined,
+	// and every restarter learns about each restart
+	c.waitNode(t, "q1-filter-1", quarantinedAfter(replicatedPolicy.MaxRestarts), 20*time.Second)
+	total := 0
+	for id := range c.restarters {
+		total += c.restarts(id, "q1-filter-1")
+	}
+	if total != replicatedPolicy.MaxRestarts {
+		t.Fatalf("Expected %v restarts, got %v", replicatedPolicy.MaxRestarts, total)
+	}
+
+	// the new leader continues from the same history, so the node stays
+	// quarantined
+	previous := c.restarters[REPLICAS-1].Status()
+	c.kill(REPLICAS - 1)
+	c.waitLeader(t, REPLICAS-2, 10*time.Second)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		// it commits an entry of its own term once it takes over
+		status := c.restarters[REPLICAS-2].Status()
+		if status.Term > previous.Term && status.Commit > previous.Commit {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("New leader did not take over the log")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if restarts := c.restarts(REPLICAS-2, "q1-filter-1"); restarts != 0 {
+		t.Fatalf("Expected the new leader not to restart the quarantined node, it restarted it %v times", restarts)
+	}
+
+	// a restarter that joins catches up from a snapshot
+	c.start(t, REPLICAS)
+	c.waitNode(t, "q1-filter-1", quarantinedAfter(replicatedPolicy.MaxRestarts), 20*time.Second)
+}
+
+func TestHeardNodeLeavesQuarantine(t *testing.T) {
+	network := newSimNetwork(1, faults{})
+	c := startReplicatedCluster(t, network, replicatedPolicy, restarter.DEFAULT_LOG_SIZE, "q1-filter-1")
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+	c.waitNode(t, "q1-filter-1", quarantinedAfter(replicatedPolicy.MaxRestarts), 20*time.Second)
+
+	// the simulated network only knows restarters, so the node borrows the
+	// address of one that does not exist
+	node, err := network.Listen(fmt.Sprintf("%v200:%v", restarter.RESTARTER_NAME, utils.NODE_PORT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	leader, err := network.Resolve(restarter.RESTARTER_NAME+fmt.Sprint(REPLICAS-1), utils.RESTARTER_PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the node comes back on its own, and keeps sending heartbeats
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			heartbeat := restarter.Heartbeat{Name: "q1-filter-1", Uptime: time.Second}
+			buf, err := restarter.Packet{Id: uint64(time.Now().UnixNano()), Msg: heartbeat}.Encode()
+			if err == nil {
+				_, _ = node.WriteToUDP(buf, leader)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	c.waitNode(t, "q1-filter-1", func(n restarter.NodeStatus) bool { return !n.Quarantined }, 10*time.Second)
+}
+
+func TestIsolatedLeaderCannotRestart(t *testing.T) {
+	policy := replicatedPolicy
+	policy.MaxRestarts = 1000
+	network := newSimNetwork(1, faults{})
+	c := startReplicatedCluster(t, network, policy, restarter.DEFAULT_LOG_SIZE, "q1-filter-1")
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+	c.waitNode(t, "q1-filter-1", func(n restarter.NodeStatus) bool { return len(n.Restarts) > 0 }, 20*time.Second)
+
+	// the leader is cut off from the rest, but still believes to be the
+	// leader. Restarts committed before are given time to finish
+	network.isolate(restarter.RESTARTER_NAME + "3")
+	c.alive[REPLICAS-1] = false
+	time.Sleep(100 * time.Millisecond)
+	isolated := c.restarts(REPLICAS-1, "q1-filter-1")
+
+	// the rest elect a new leader, which keeps restarting the node
+	c.waitLeader(t, REPLICAS-2, 10*time.Second)
+	deadline := time.Now().Add(20 * time.Second)
+	for c.restarts(REPLICAS-2, "q1-filter-1") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("New leader did not restart the node")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if restarts := c.restarts(REPLICAS-1, "q1-filter-1"); restarts != isolated {
+		t.Fatalf("Isolated leader restarted the node %v times after losing its majority", restarts-isolated)
+	}
+}
+
+func TestLeftRestartersStillVote(t *testing.T) {
+	policy := replicatedPolicy
+	policy.MaxRestarts = 1000
+	c := startReplicatedCluster(t, newSimNetwork(1, faults{}), policy, restarter.DEFAULT_LOG_SIZE, "q1-filter-1")
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+	c.waitNode(t, "q1-filter-1", func(n restarter.NodeStatus) bool { return len(n.Restarts) > 0 }, 20*time.Second)
+
+	// half of the restarters leave, so the rest see a majority among
+	// themselves, but not among the restarters that vote on the log
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for id := range REPLICAS / 2 {
+		err := c.restarters[id].Leave(ctx)
+		if err != nil {
+			t.Fatalf("Failed to leave: %v", err)
+		}
+		c.kill(id)
+	}
+	c.waitMembers(t, hasRestarters(2, 3), 10*time.Second)
+	time.Sleep(100 * time.Millisecond)
+	restarts := c.restarts(REPLICAS-1, "q1-filter-1")
+
+	time.Sleep(time.Second)
+	if after := c.restarts(REPLICAS-1, "q1-filter-1"); after != restarts {
+		t.Fatalf("Leader restarted the node %v times without a majority of the voters", after-restarts)
+	}
+}
+
+func TestReplicatedPause(t *testing.T) {
+	policy := replicatedPolicy
+	policy.MaxRestarts = 1000
+	c := startReplicatedCluster(t, newSimNetwork(1, faults{}), policy, restarter.DEFAULT_LOG_SIZE, "q1-filter-1")
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+
+	// only the leader can pause the node
+	ctx := context.Background()
+	err := c.restarters[0].PauseMonitoring(ctx, "q1-filter-1")
+	if !errors.Is(err, restarter.ErrNotLeader) {
+		t.Fatalf("Expected a follower not to pause the node, got %v", err)
+	}
+	err = whileTakingOver(func() error { return c.restarters[REPLICAS-1].PauseMonitoring(ctx, "q1-filter-1") })
+	if err != nil {
+		t.Fatalf("Failed to pause the node: %v", err)
+	}
+	c.waitNode(t, "q1-filter-1", func(n restarter.NodeStatus) bool { return n.Paused }, 10*time.Second)
+
+	// the new leader keeps the node paused
+	previous := c.restarters[REPLICAS-1].Status()
+	c.kill(REPLICAS - 1)
+	c.waitLeader(t, REPLICAS-2, 10*time.Second)
+	c.waitNode(t, "q1-filter-1", func(n restarter.NodeStatus) bool { return n.Paused }, 10*time.Second)
+	deadline := time.Now().Add(10 * time.Second)
+	for c.restarters[REPLICAS-2].Status().Term <= previous.Term {
+		if time.Now().After(deadline) {
+			t.Fatalf("New leader did not take over the log")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if restarts := c.restarts(REPLICAS-2, "q1-filter-1"); restarts != 0 {
+		t.Fatalf("Expected the new leader not to restart the paused node, it restarted it %v times", restarts)
+	}
+
+	err = whileTakingOver(func() error { return c.restarters[REPLICAS-2].ResumeMonitoring(ctx, "q1-filter-1") })
+	if err != nil {
+		t.Fatalf("Failed to resume the node: %v", err)
+	}
+	deadline = time.Now().Add(10 * time.Second)
+	for c.restarts(REPLICAS-2, "q1-filter-1") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the resumed node to be restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Retries the operation while the leader has not taken over the log yet
+func whileTakingOver(op func() error) error {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := op()
+		if !errors.Is(err, restarter.ErrNotLeader) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRestarterIdTooLarge(t *testing.T) {
+	replog, err := restarter.NewReplicatedLog(t.TempDir(), restarter.DEFAULT_LOG_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := restarter.MAX_LOG_RESTARTER_ID + 1
+	_, err = restarter.NewRestarter(restarter.Config{
+		Address:  fmt.Sprintf("%v%v:14300", restarter.RESTARTER_NAME, id),
+		Id:       id,
+		Replicas: REPLICAS,
+		Election: restarter.DefaultElectionConfig(),
+		Log:      replog,
+		Network:  newSimNetwork(1, faults{}),
+	})
+	if !errors.Is(err, restarter.ErrRestarterId) {
+		t.Fatalf("Expected the restarter id to be rejected, got %v", err)
+	}
+
+	// restarters with such ids can't join a cluster that uses the log
+	network := newSimNetwork(1, faults{})
+	c := startReplicatedCluster(t, network, replicatedPolicy, restarter.DEFAULT_LOG_SIZE)
+	c.waitLeader(t, REPLICAS-1, 10*time.Second)
+
+	// the simulated network has no address for it, so it borrows another one
+	node, err := network.Listen(fmt.Sprintf("%v200:%v", restarter.RESTARTER_NAME, utils.NODE_PORT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	leader, err := network.Resolve(restarter.RESTARTER_NAME+fmt.Sprint(REPLICAS-1), utils.RESTARTER_PORT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{restarter.RESTARTER_NAME + fmt.Sprint(id), "q1-filter-1"} {
+		buf, err := restarter.Packet{Id: uint64(i), Msg: restarter.Join{Name: name}}.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = node.WriteToUDP(buf, leader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// joins are handled in order, so the restarter was already rejected
+	// once the node is a member
+	c.waitMembers(t, func(m restarter.Membership) bool {
+		return slices.Contains(m.Nodes, "q1-filter-1")
+	}, 10*time.Second)
+	if members := c.restarters[REPLICAS-1].Members(); !slices.Equal(members.Restarters, []uint64{0, 1, 2, 3}) {
+		t.Fatalf("Expected the restarter not to join, got %+v", members)
+	}
+}
@@ -0,0 +1,320 @@
+package restarter
+
+import (
+	"context"
+	"distribuidos/tp1/utils"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Interval between heartbeats pushed by the nodes
+const HEARTBEAT_INTERVAL = 250 * time.Millisecond
+
+// Interval between join requests, while the node does not know the leader
+const JOIN_PERIOD = time.Second
+
+// Restarters known by the nodes, unless RESTARTERS is set
+const DEFAULT_RESTARTERS = 4
+
+var ErrNotRunning = errors.New("reporter is not running")
+
+// Pushes the heartbeats of a node to the leader restarter, which is learnt
+// from the Coordinator messages it sends to the node. Keep alives are still
+// answered, so that the node can also be polled. Until the leader is known,
+// the node asks the restarters to join the cluster
+type Reporter struct {
+	name    string
+	started time.Time
+	seeds   []string
+	leader  atomic.Pointer[net.UDPAddr]
+	clients atomic.Int64
+	// unix milliseconds, zero if no batch was processed
+	lastBatch atomic.Int64
+	conn      atomic.Pointer[net.UDPConn]
+	// set once the node leaves, so that it no longer sends heartbeats
+	left      atomic.Bool
+	auth      *Authenticator
+	mu        sync.Mutex
+	lastMsgId uint64
+	ackMap    map[uint64]chan struct{}
+}
+
+func NewReporter(name string) *Reporter {
+	return &Reporter{
+		name:    name,
+		started: time.Now(),
+		seeds:   RestarterSeeds(),
+		auth:    SecretAuthenticator(),
+		ackMap:  make(map[uint64]chan struct{}),
+	}
+}
+
+// Names of the restarters the node asks to join the cluster. They are the
+// first RESTARTERS ones, as any restarter relays the request to the leader
+func RestarterSeeds() []string {
+	count, err := strconv.Atoi(os.Getenv("RESTARTERS"))
+	if err != nil || count <= 0 {
+		count = DEFAULT_RESTARTERS
+	}
+	seeds := make([]string, count)
+	for id := range seeds {
+		seeds[id] = restarterName(id)
+	}
+	return seeds
+}
+
+// Name of the node running in this process. Nodes are named after their
+// stage, followed by their partition if the stage is replicated, unless
+// NODE_NAME is set
+func NodeName() string {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name
+	}
+	if stage := os.Getenv("STAGE"); stage != "" {
+		if partition := os.Getenv("PARTITION_ID"); partition != "" {
+			return fmt.Sprintf("%v-%v", stage, partition)
+		}
+		return stage
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+func (r *Reporter) SetActiveClients(n int) {
+	r.clients.Store(int64(n))
+}
+
+func (r *Reporter) BatchProcessed(at time.Time) {
+	r.lastBatch.Store(at.UnixMilli())
+}
+
+// Heartbeat with the current health of the node
+func (r *Reporter) Heartbeat(now time.Time) Heartbeat {
+	h := Heartbeat{
+		Name:          r.name,
+		Uptime:        now.Sub(r.started),
+		ActiveClients: uint32(r.clients.Load()),
+	}
+	if lastBatch := r.lastBatch.Load(); lastBatch != 0 {
+		h.LastBatch = time.UnixMilli(lastBatch)
+	}
+	return h
+}
+
+// Listens for messages of the restarters at the given address, and pushes
+// heartbeats to the leader once it is known
+func (r *Reporter) Run(ctx context.Context, address string) (err error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return fmt.Errorf("failed to resolve address: %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start listener on address %v: %v", address, err)
+	}
+
+	log.Infof("Listening in %v", udpAddr)
+	if r.auth == nil {
+		log.Warningf("RESTARTER_SECRET is not set, packets are not authenticated")
+	}
+
+	closer := utils.SpawnCloser(ctx, conn)
+	defer func() {
+		closeErr := closer.Close()
+		err = errors.Join(err, closeErr)
+	}()
+
+	r.conn.Store(conn)
+	defer r.conn.Store(nil)
+
+	go r.push(ctx, conn)
+
+	buf := make([]byte, MAX_PACKAGE_SIZE)
+	for {
+		n, rAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return fmt.Errorf("read error: %v", err)
+		}
+
+		data, err := r.auth.Open(buf[:n], time.Now())
+		replayed := errors.Is(err, ErrReplayed)
+		if err != nil && !replayed {
+			log.Warningf("Rejected packet from %v: %v", rAddr, err)
+			continue
+		}
+
+		packet, err := Decode(data)
+		if err != nil {
+			log.Errorf("Failed to decode message: %v", err)
+			continue
+		}
+
+		switch msg := packet.Msg.(type) {
+		case Ack:
+			if replayed {
+				continue
+			}
+			r.mu.Lock()
+			ch, ok := r.ackMap[packet.Id]
+			delete(r.ackMap, packet.Id)
+			r.mu.Unlock()
+			if ok {
+				close(ch)
+			}
+			continue
+		case Coordinator:
+			if replayed {
+				// a retransmission, which is only acked again
+				break
+			}
+			// the leader announces itself from its own socket, so heartbeats
+			// are sent back to the same address
+			previous := r.leader.Swap(rAddr)
+			if previous == nil || previous.String() != rAddr.String() {
+				log.Infof("Leader is %v, sending heartbeats to %v", msg.Leader, rAddr)
+			}
+		case KeepAlive:
+		default:
+			continue
+		}
+
+		err = r.sendPacket(conn, rAddr, Packet{Id: packet.Id, Msg: Ack{}})
+		if err != nil {
+			return fmt.Errorf("failed to send ack: %v", err)
+		}
+	}
+}
+
+func (r *Reporter) push(ctx context.Context, conn *net.UDPConn) {
+	ticker := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+
+	var joined time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if r.left.Load() {
+				continue
+			}
+			leader := r.leader.Load()
+			if leader == nil {
+				if now.Sub(joined) >= JOIN_PERIOD {
+					joined = now
+					r.join(conn)
+				}
+				continue
+			}
+
+			err := r.sendPacket(conn, leader, Packet{Id: r.newMsgId(), Msg: r.Heartbeat(now)})
+			if err != nil && ctx.Err() == nil {
+				log.Warningf("Failed to send heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// Asks every seed to join the cluster. Requests are not retried, as they are
+// repeated until the leader announces itself
+func (r *Reporter) join(conn *net.UDPConn) {
+	for _, seed := range r.seeds {
+		addr, err := utils.GetUDPAddr(seed, utils.RESTARTER_PORT)
+		if err != nil {
+			log.Debugf("Failed to resolve %v: %v", seed, err)
+			continue
+		}
+		err = r.sendPacket(conn, addr, Packet{Id: r.newMsgId(), Msg: Join{Name: r.name}})
+		if err != nil {
+			log.Warningf("Failed to send join to %v: %v", seed, err)
+		}
+	}
+}
+
+// Leaves the cluster, so that the node is no longer restarted. It is sent to
+// the leader, or to the seeds if it is not known, until one of them answers.
+// Heartbeats stop, as the leader would otherwise add the node again
+func (r *Reporter) Leave(ctx context.Context) error {
+	conn := r.conn.Load()
+	if conn == nil {
+		return ErrNotRunning
+	}
+	r.left.Store(true)
+
+	addrs := make([]*net.UDPAddr, 0)
+	if leader := r.leader.Load(); leader != nil {
+		addrs = append(addrs, leader)
+	}
+	for _, seed := range r.seeds {
+		addr, err := utils.GetUDPAddr(seed, utils.RESTARTER_PORT)
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	var err error = ErrNoLeader
+	for _, addr := range addrs {
+		for i := 0; i < MAX_ATTEMPTS; i++ {
+			err = r.sendWithAck(ctx, conn, addr, Leave{Name: r.name})
+			if err == nil {
+				log.Infof("Node %v left the cluster", r.name)
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	return err
+}
+
+func (r *Reporter) sendWithAck(ctx context.Context, conn *net.UDPConn, addr *net.UDPAddr, msg Message) error {
+	id := r.newMsgId()
+	ch := make(chan struct{})
+	r.mu.Lock()
+	r.ackMap[id] = ch
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.ackMap, id)
+		r.mu.Unlock()
+	}()
+
+	err := r.sendPacket(conn, addr, Packet{Id: id, Msg: msg})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-time.After(ACK_TIMEOUT):
+		return ErrTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Reporter) newMsgId() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastMsgId = nextMsgId(r.lastMsgId, time.Now())
+	return r.lastMsgId
+}
+
+func (r *Reporter) sendPacket(conn *net.UDPConn, addr *net.UDPAddr, p Packet) error {
+	encoded, err := p.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteToUDP(r.auth.Seal(encoded), addr)
+	return err
+}
@@ -0,0 +1,259 @@
+package restarter_test
+
+import (
+	"context"
+	"distribuidos/tp1/restarter-protocol"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNodeName(t *testing.T) {
+	t.Setenv("NODE_NAME", "")
+	t.Setenv("STAGE", "q3-group")
+	t.Setenv("PARTITION_ID", "2")
+	if name := restarter.NodeName(); name != "q3-group-2" {
+		t.Fatalf("Expected q3-group-2, got %v", name)
+	}
+
+	t.Setenv("PARTITION_ID", "")
+	if name := restarter.NodeName(); name != "q3-group" {
+		t.Fatalf("Expected q3-group, got %v", name)
+	}
+
+	t.Setenv("NODE_NAME", "gateway")
+	if name := restarter.NodeName(); name != "gateway" {
+		t.Fatalf("Expected gateway, got %v", name)
+	}
+}
+
+func TestReporter(t *testing.T) {
+	// reserve a free port for the node
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeAddr := listener.LocalAddr().(*net.UDPAddr)
+	listener.Close()
+
+	reporter := restarter.NewReporter("q1-count-1")
+	reporter.SetActiveClients(2)
+	reporter.BatchProcessed(time.UnixMilli(1000))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = reporter.Run(ctx, nodeAddr.String()) }()
+
+	leader, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	announcement, err := restarter.Packet{Id: 7, Msg: restarter.Coordinator{Leader: 3}}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acked, heartbeats := false, 0
+	deadline := time.Now().Add(2 * time.Second)
+	for heartbeats < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected ack and heartbeats, got ack %v and %v heartbeats", acked, heartbeats)
+		}
+		// the node may not be listening yet
+		if !acked {
+			_, err = leader.WriteToUDP(announcement, nodeAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_ = leader.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		buf := make([]byte, restarter.MAX_PACKAGE_SIZE)
+		_, _, err := leader.ReadFromUDP(buf)
+		if err != nil {
+			continue
+		}
+		packet, err := restarter.Decode(buf)
+		if err != nil {
+			t.Fatalf("Failed to decode packet: %v", err)
+		}
+
+		switch msg := packet.Msg.(type) {
+		case restarter.Ack:
+			acked = packet.Id == 7
+		case restarter.Heartbeat:
+			heartbeats += 1
+			if msg.Name != "q1-count-1" || msg.ActiveClients != 2 || !msg.LastBatch.Equal(time.UnixMilli(1000)) {
+				t.Fatalf("Unexpected heartbeat %+v", msg)
+			}
+			if msg.Uptime <= 0 {
+				t.Fatalf("Expected positive uptime, got %v", msg.Uptime)
+			}
+		}
+	}
+	if !acked {
+		t.Fatalf("Expected announcement to be acknowledged")
+	}
+}
+
+func TestReporterSecret(t *testing.T) {
+	t.Setenv("RESTARTER_SECRET", "secret")
+	auth := restarter.NewAuthenticator([]byte("secret"), restarter.MAX_PACKET_AGE)
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeAddr := listener.LocalAddr().(*net.UDPAddr)
+	listener.Close()
+
+	reporter := restarter.NewReporter("q1-count-1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = reporter.Run(ctx, nodeAddr.String()) }()
+
+	leader, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	// waits for an authenticated ack of the announcement, resending it
+	// until the node listens
+	waitAck := func(announcement []byte, timeout time.Duration) bool {
+		deadline := time.Now().Add(timeout)
+		for time.Now().Before(deadline) {
+			_, err = leader.WriteToUDP(announcement, nodeAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = leader.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			buf := make([]byte, restarter.MAX_PACKAGE_SIZE)
+			n, _, err := leader.ReadFromUDP(buf)
+			if err != nil {
+				continue
+			}
+			data, err := auth.Open(buf[:n], time.Now())
+			if err != nil {
+				t.Fatalf("Node sent an unauthenticated packet: %v", err)
+			}
+			packet, err := restarter.Decode(data)
+			if err != nil {
+				t.Fatalf("Failed to decode packet: %v", err)
+			}
+			if _, ok := packet.Msg.(restarter.Ack); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	unsealed, err := restarter.Packet{Id: uint64(time.Now().UnixNano()), Msg: restarter.Coordinator{Leader: 3}}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waitAck(unsealed, time.Second) {
+		t.Fatalf("Expected unauthenticated announcement to be rejected")
+	}
+
+	sealed, err := restarter.Packet{Id: uint64(time.Now().UnixNano()), Msg: restarter.Coordinator{Leader: 3}}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitAck(auth.Seal(sealed), 2*time.Second) {
+		t.Fatalf("Expected authenticated announcement to be acknowledged")
+	}
+}
+
+func TestReporterLeave(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeAddr := listener.LocalAddr().(*net.UDPAddr)
+	listener.Close()
+
+	reporter := restarter.NewReporter("q1-count-1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = reporter.Run(ctx, nodeAddr.String()) }()
+
+	leader, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	announcement, err := restarter.Packet{Id: 7, Msg: restarter.Coordinator{Leader: 3}}.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reads the next packet, announcing the leader until the node listens
+	read := func() (restarter.Packet, bool) {
+		_ = leader.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		buf := make([]byte, restarter.MAX_PACKAGE_SIZE)
+		n, _, err := leader.ReadFromUDP(buf)
+		if err != nil {
+			return restarter.Packet{}, false
+		}
+		packet, err := restarter.Decode(buf[:n])
+		if err != nil {
+			t.Fatalf("Failed to decode packet: %v", err)
+		}
+		return packet, true
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for heartbeat := false; !heartbeat; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected heartbeats")
+		}
+		_, err = leader.WriteToUDP(announcement, nodeAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, _ := read()
+		_, heartbeat = packet.Msg.(restarter.Heartbeat)
+	}
+
+	left := make(chan error, 1)
+	go func() { left <- reporter.Leave(ctx) }()
+
+	// the leave is sent to the leader, which acknowledges it
+	for {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the node to leave")
+		}
+		packet, _ := read()
+		if msg, ok := packet.Msg.(restarter.Leave); ok {
+			if msg.Name != "q1-count-1" {
+				t.Fatalf("Unexpected leave %+v", msg)
+			}
+			ack, err := restarter.Packet{Id: packet.Id, Msg: restarter.Ack{}}.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = leader.WriteToUDP(ack, nodeAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if err := <-left; err != nil {
+		t.Fatalf("Failed to leave: %v", err)
+	}
+
+	// heartbeats sent before leaving may still arrive
+	time.Sleep(100 * time.Millisecond)
+	for _, ok := read(); ok; _, ok = read() {
+	}
+	deadline = time.Now().Add(4 * restarter.HEARTBEAT_INTERVAL)
+	for time.Now().Before(deadline) {
+		if packet, ok := read(); ok {
+			t.Fatalf("Expected no packets after leaving, got %+v", packet.Msg)
+		}
+	}
+}
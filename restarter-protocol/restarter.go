@@ -5,19 +5,21 @@ import (
 	"distribuidos/tp1/utils"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
-	"os/exec"
-	"strings"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/op/go-logging"
 )
 
+// File with the names of the monitored nodes
 const CONFIG_PATH = ".restarter-config"
 const MAX_ATTEMPTS = 3
-const MAX_PACKAGE_SIZE = 1024
+
+// Largest UDP payload, so that the membership of large clusters fits
+const MAX_PACKAGE_SIZE = 65507
+const ACK_TIMEOUT = 2 * time.Second
 
 const RESTARTER_NAME = "restarter-"
 
@@ -26,48 +28,105 @@ var log = logging.MustGetLogger("log")
 var ErrFallenNode = errors.New("Never got ack")
 var ErrTimeout = errors.New("Never got ack")
 
+type Config struct {
+	// Address the restarter listens at
+	Address string
+	Id      int
+	// Restarters and nodes initially in the cluster, other ones can join
+	// at runtime. Restarters have ids from 0 to Replicas - 1, and only they
+	// vote on the replicated log
+	Replicas int
+	Nodes    []string
+	Election ElectionConfig
+	Backend  RestartBackend
+	Monitor  MonitorConfig
+	History  *RestartHistory
+	// Log through which the leader replicates its restarts. Each restarter
+	// keeps its history on its own if nil
+	Log *ReplicatedLog
+	// Defaults to UDP
+	Network Network
+	// Packets are not authenticated if nil
+	Auth *Authenticator
+}
+
 type Restarter struct {
 	id           int
-	nodes        []string
-	replicas     int
-	conn         *net.UDPConn
+	network      Network
+	conn         Conn
+	auth         *Authenticator
 	ackMap       map[uint64]chan bool
 	lastMsgId    uint64
 	condLeaderId *sync.Cond
 	leaderId     int
 	mu           *sync.Mutex
 	wg           *sync.WaitGroup
+	election     ElectionConfig
+	// state of the bully election, guarded by mu
+	electing         bool
+	coordinatorRound uint64
+	backend          RestartBackend
+	monitor          MonitorConfig
+	history          *RestartHistory
+	replog           *ReplicatedLog
+	// restarters whose votes count towards a quorum of the log
+	voters    []uint64
+	members   Membership
+	membersMu *sync.Mutex
+	// closed when the membership changes
+	membersChanged chan struct{}
+	// set once the restarter leaves the cluster, guarded by membersMu
+	left bool
+	// status of each node, only while monitoring them as leader
+	statuses map[string]*nodeStatus
+	statusMu *sync.Mutex
 }
 
-func NewRestarter(address string, id int, replicas int) (*Restarter, error) {
-	nodes, err := utils.ReadNodes(CONFIG_PATH)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read nodes config: %v", err)
+func NewRestarter(config Config) (*Restarter, error) {
+	if config.Election.Algorithm != RING_ELECTION && config.Election.Algorithm != BULLY_ELECTION {
+		return nil, fmt.Errorf("%w %q", ErrUnknownElection, config.Election.Algorithm)
 	}
-
-	udpAddr, err := net.ResolveUDPAddr("udp", address)
-	if err != nil {
-		return nil, fmt.Errorf("did not receive a valid address: %v", err)
+	if config.Log != nil && max(config.Id, config.Replicas-1) > MAX_LOG_RESTARTER_ID {
+		return nil, fmt.Errorf("%w: %v", ErrRestarterId, max(config.Id, config.Replicas-1))
 	}
-	conn, err := net.ListenUDP("udp", udpAddr)
+	network := config.Network
+	if network == nil {
+		network = UDPNetwork{}
+	}
+
+	conn, err := network.Listen(config.Address)
 	if err != nil {
-		return nil, fmt.Errorf("failed to start listening from connection: %v", err)
+		return nil, fmt.Errorf("failed to start listening at %v: %v", config.Address, err)
 	}
 
 	var mu sync.Mutex
 	cond := sync.NewCond(&mu)
 
+	members := Membership{Restarters: make([]uint64, 0), Nodes: slices.Sorted(slices.Values(config.Nodes))}
+	for id := 0; id < config.Replicas; id++ {
+		members.Restarters = append(members.Restarters, uint64(id))
+	}
+
 	return &Restarter{
-		nodes:        nodes,
-		conn:         conn,
-		id:           id,
-		replicas:     replicas,
-		condLeaderId: cond,
-		mu:           &mu,
-		ackMap:       make(map[uint64]chan bool),
-		lastMsgId:    0,
-		wg:           &sync.WaitGroup{},
-		leaderId:     -1,
+		network:        network,
+		conn:           conn,
+		auth:           config.Auth,
+		id:             config.Id,
+		condLeaderId:   cond,
+		mu:             &mu,
+		ackMap:         make(map[uint64]chan bool),
+		wg:             &sync.WaitGroup{},
+		leaderId:       -1,
+		election:       config.Election,
+		backend:        config.Backend,
+		monitor:        config.Monitor,
+		history:        config.History,
+		replog:         config.Log,
+		voters:         slices.Clone(members.Restarters),
+		members:        members,
+		membersMu:      &sync.Mutex{},
+		membersChanged: make(chan struct{}),
+		statusMu:       &sync.Mutex{},
 	}, nil
 }
 
@@ -81,77 +140,52 @@ func (r *Restarter) Start(ctx context.Context) error {
 
 	log.Infof("Listening at %v", r.conn.LocalAddr().String())
 
-	go func() {
-		time.Sleep(3 * time.Second)
-		err := r.startElection(ctx)
-		utils.Expect(err, "Failed to start election")
-	}()
+	go r.keepLeader(ctx)
 
-	go r.monitorNode(ctx, fmt.Sprintf("%v%v", RESTARTER_NAME, (r.id+1)%r.replicas), utils.RESTARTER_PORT)
+	go r.monitorNeighbor(ctx)
 
 	return r.read(ctx)
 }
 
-func (r *Restarter) StartMonitoring(ctx context.Context) {
-	for _, node := range r.nodes {
-		time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			go func(nodeName string) {
-				r.monitorNode(ctx, nodeName, utils.NODE_PORT)
-			}(node)
-		}
-	}
-}
-
-func (r *Restarter) monitorNode(ctx context.Context, containerName string, port int) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(time.Duration(rand.Intn(4000)+3000) * time.Millisecond):
-			msg := KeepAlive{}
-
-			err := r.safeSend(ctx, msg, containerName, port)
-			if errors.Is(err, ErrFallenNode) {
-				log.Errorf("Node %v has fallen. Restarting...", containerName)
-
-				err := r.restartNode(ctx, containerName)
-				if err != nil {
-					log.Errorf("Failed to restart neighbor: %v", err)
-				}
-				continue
-			}
-
-			if err != nil {
-				log.Errorf("Failed to send keep alive: %v", err)
-			}
-		}
-	}
-}
-
 func (r *Restarter) read(ctx context.Context) error {
+	// decoded messages do not reference the buffer, so it is reused
+	buf := make([]byte, MAX_PACKAGE_SIZE)
 	for {
-		buf := make([]byte, MAX_PACKAGE_SIZE)
-		_, recvAddr, err := r.conn.ReadFromUDP(buf)
+		n, recvAddr, err := r.conn.ReadFromUDP(buf)
 		if err != nil {
 			return fmt.Errorf("Failed to read: %v", err)
 		}
 
-		packet, err := Decode(buf)
+		data, err := r.auth.Open(buf[:n], time.Now())
+		replayed := errors.Is(err, ErrReplayed)
+		if err != nil && !replayed {
+			log.Warningf("Rejected packet from %v: %v", recvAddr, err)
+			continue
+		}
+
+		packet, err := Decode(data)
 		if err != nil {
 			log.Errorf("Failed to decode packet: %v", err)
 			continue
 		}
 
+		if replayed {
+			// a retransmission whose ack was lost, so it is acked again
+			if needsAck(packet.Msg) {
+				err = r.sendAck(recvAddr, packet.Id)
+				if err != nil {
+					log.Errorf("Failed to send ack: %v", err)
+				}
+			}
+			continue
+		}
+
 		switch msg := packet.Msg.(type) {
 		case Ack:
 			r.handleAck(packet.Id)
 		case Coordinator:
 			go func() {
-				err = r.sendAck(recvAddr, packet.Id)
+				err := r.sendAck(recvAddr, packet.Id)
 				if err != nil {
 					log.Errorf("Failed to send ack: %v", err)
 				}
@@ -162,7 +196,7 @@ func (r *Restarter) read(ctx context.Context) error {
 			}()
 		case Election:
 			go func() {
-				err = r.sendAck(recvAddr, packet.Id)
+				err := r.sendAck(recvAddr, packet.Id)
 				if err != nil {
 					log.Errorf("Failed to send ack: %v", err)
 				}
@@ -176,6 +210,79 @@ func (r *Restarter) read(ctx context.Context) error {
 			if err != nil {
 				log.Errorf("Failed to send ack: %v", err)
 			}
+		case Heartbeat:
+			r.handleHeartbeat(ctx, msg, time.Now())
+		case Join:
+			go func() {
+				err := r.sendAck(recvAddr, packet.Id)
+				if err != nil {
+					log.Errorf("Failed to send ack: %v", err)
+				}
+				err = r.handleJoin(ctx, msg)
+				if err != nil {
+					log.Errorf("Failed to handle join message: %v", err)
+				}
+			}()
+		case Leave:
+			go func() {
+				err := r.sendAck(recvAddr, packet.Id)
+				if err != nil {
+					log.Errorf("Failed to send ack: %v", err)
+				}
+				err = r.handleLeave(ctx, msg)
+				if err != nil {
+					log.Errorf("Failed to handle leave message: %v", err)
+				}
+			}()
+		case Membership:
+			go func() {
+				err := r.sendAck(recvAddr, packet.Id)
+				if err != nil {
+					log.Errorf("Failed to send ack: %v", err)
+				}
+				err = r.handleMembership(ctx, msg)
+				if err != nil {
+					log.Errorf("Failed to handle membership message: %v", err)
+				}
+			}()
+		// log messages are not acked, as they are sent again until
+		// answered
+		case Prepare:
+			if r.replog != nil {
+				go func() {
+					err := r.handlePrepare(ctx, msg)
+					if err != nil {
+						log.Errorf("Failed to handle prepare message: %v", err)
+					}
+				}()
+			}
+		case Promise:
+			if r.replog != nil {
+				go func() {
+					err := r.handlePromise(msg)
+					if err != nil {
+						log.Errorf("Failed to handle promise message: %v", err)
+					}
+				}()
+			}
+		case Append:
+			if r.replog != nil {
+				go func() {
+					err := r.handleAppend(ctx, msg)
+					if err != nil {
+						log.Errorf("Failed to handle append message: %v", err)
+					}
+				}()
+			}
+		case AppendReply:
+			if r.replog != nil {
+				go func() {
+					err := r.handleAppendReply(ctx, msg)
+					if err != nil {
+						log.Errorf("Failed to handle append reply message: %v", err)
+					}
+				}()
+			}
 		}
 	}
 }
@@ -184,7 +291,7 @@ func (r *Restarter) safeSend(ctx context.Context, msg Message, name string, port
 	var err error
 	msgId := r.newMsgId()
 
-	addr, err := utils.GetUDPAddr(name, port)
+	addr, err := r.network.Resolve(name, port)
 	if err != nil {
 		return errors.Join(ErrFallenNode, err)
 	}
@@ -195,7 +302,7 @@ func (r *Restarter) safeSend(ctx context.Context, msg Message, name string, port
 	}
 
 	for attempts := 0; attempts < MAX_ATTEMPTS; attempts++ {
-		err = r.send(ctx, packet, addr)
+		err = r.send(ctx, packet, addr, r.election.AckTimeout)
 		if errors.Is(err, ErrTimeout) {
 			log.Warningf("Timeout, trying to send again message %v", msgId)
 		} else {
@@ -206,12 +313,12 @@ func (r *Restarter) safeSend(ctx context.Context, msg Message, name string, port
 	return errors.Join(ErrFallenNode, err)
 }
 
-func (r *Restarter) send(ctx context.Context, p Packet, addr *net.UDPAddr) error {
+func (r *Restarter) send(ctx context.Context, p Packet, addr *net.UDPAddr, timeout time.Duration) error {
 	encoded, err := p.Encode()
 	if err != nil {
 		return err
 	}
-	_, err = r.conn.WriteToUDP(encoded, addr)
+	_, err = r.conn.WriteToUDP(r.auth.Seal(encoded), addr)
 	if err != nil {
 		return err
 	}
@@ -228,7 +335,7 @@ func (r *Restarter) send(ctx context.Context, p Packet, addr *net.UDPAddr) error
 		r.mu.Lock()
 		delete(r.ackMap, p.Id)
 		r.mu.Unlock()
-	case <-time.After(2 * time.Second):
+	case <-time.After(timeout):
 		return ErrTimeout
 	case <-ctx.Done():
 		return nil
@@ -247,7 +354,7 @@ func (r *Restarter) sendAck(prevNeighbor *net.UDPAddr, msgId uint64) error {
 		return err
 	}
 
-	_, err = r.conn.WriteToUDP(msg, prevNeighbor)
+	_, err = r.conn.WriteToUDP(r.auth.Seal(msg), prevNeighbor)
 	if err != nil {
 		return err
 	}
@@ -266,9 +373,16 @@ func (r *Restarter) handleAck(msgId uint64) {
 	}
 }
 
+// Discards the ack channel of a message that will not be sent again
+func (r *Restarter) forgetMsg(msgId uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.ackMap, msgId)
+}
+
 func (r *Restarter) newMsgId() uint64 {
 	r.mu.Lock()
-	r.lastMsgId += 1
+	r.lastMsgId = nextMsgId(r.lastMsgId, time.Now())
 	// we make the channel buffered to avoid blocking the read loop
 	r.ackMap[r.lastMsgId] = make(chan bool, 1)
 	defer r.mu.Unlock()
@@ -276,6 +390,8 @@ func (r *Restarter) newMsgId() uint64 {
 	return r.lastMsgId
 }
 
+// Restarts the node after its backoff. Crash looping nodes are quarantined
+// instead of restarted, returning ErrQuarantined
 func (r *Restarter) restartNode(ctx context.Context, containerName string) error {
 	if r.isLeader(containerName) {
 		log.Infof("Leader has fallen")
@@ -284,25 +400,112 @@ func (r *Restarter) restartNode(ctx context.Context, containerName string) error
 			return err
 		}
 	}
+	if r.replicated(containerName) {
+		return r.replicatedRestart(ctx, containerName)
+	}
 
-	cmdStr := fmt.Sprintf("docker restart %v", containerName)
-	err := exec.CommandContext(ctx, "/bin/sh", "-c", cmdStr).Run()
+	backoff, err := r.history.Backoff(containerName, time.Now())
+	if errors.Is(err, ErrQuarantined) {
+		r.logCrashLoop(containerName)
+	}
+	if err != nil {
+		return err
+	}
+	err = r.waitBackoff(ctx, containerName, backoff)
 	if err != nil {
 		return err
 	}
 
-	// wait if SIGTERM signal triggered
-	time.Sleep(5 * time.Second)
+	err = r.backend.Restart(ctx, containerName)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return r.history.Record(containerName, time.Now())
 }
 
-func (r *Restarter) isLeader(containerName string) bool {
-	if !strings.Contains(containerName, RESTARTER_NAME) {
-		return false
+// Restarts the node once the restart is committed to the log, so that a
+// leader that was replaced can no longer restart it. The restart is
+// cancelled if it does not finish within its lease, after which another
+// leader may restart the node again
+func (r *Restarter) replicatedRestart(ctx context.Context, containerName string) error {
+	backoff, err := r.history.NextRestart(containerName, time.Now())
+	if errors.Is(err, ErrCrashLooping) {
+		r.logCrashLoop(containerName)
+		_, err = r.propose(ctx, LogEntry{Kind: QuarantineEntry, Node: containerName})
+		return errors.Join(ErrQuarantined, err)
+	}
+	if err != nil {
+		return err
+	}
+	err = r.waitBackoff(ctx, containerName, backoff)
+	if err != nil {
+		return err
 	}
 
-	neighborId := (r.id + 1) % r.replicas
+	// entries keep milliseconds, so the lease is computed from the same time
+	at := time.UnixMilli(time.Now().UnixMilli())
+	_, err = r.propose(ctx, LogEntry{Kind: RestartEntry, Node: containerName, At: at})
+	if err != nil {
+		return err
+	}
+
+	restartCtx, cancel := context.WithDeadline(ctx, at.Add(r.monitor.RestartLease))
+	defer cancel()
+	err = r.backend.Restart(restartCtx, containerName)
+
+	_, proposeErr := r.propose(ctx, LogEntry{Kind: RestartedEntry, Node: containerName})
+	return errors.Join(err, proposeErr)
+}
+
+func (r *Restarter) waitBackoff(ctx context.Context, containerName string, backoff time.Duration) error {
+	if backoff <= 0 {
+		return nil
+	}
+	log.Infof("Waiting %v before restarting %v", backoff, containerName)
+	select {
+	case <-time.After(backoff):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	return neighborId == r.leaderId
+func (r *Restarter) logCrashLoop(containerName string) {
+	log.Criticalf("Node %v is crash looping, it was restarted %v times in the last %v. It is quarantined until an operator fixes it",
+		containerName, r.history.policy.MaxRestarts, r.history.policy.Window)
+}
+
+// Whether the restarts of the node are replicated through the log. Restarters
+// are restarted by their neighbors, which do not lead the log
+func (r *Restarter) replicated(name string) bool {
+	_, isRestarter := restarterId(name)
+	return r.replog != nil && !isRestarter
+}
+
+// Releases the node from quarantine once it is alive again, which means
+// that an operator has fixed it
+func (r *Restarter) heard(ctx context.Context, name string) {
+	if !r.history.IsQuarantined(name) {
+		return
+	}
+	err := r.release(ctx, name)
+	if err != nil {
+		log.Errorf("Failed to release %v from quarantine: %v", name, err)
+		return
+	}
+	log.Infof("Node %v is alive again, releasing it from quarantine", name)
+}
+
+func (r *Restarter) release(ctx context.Context, name string) error {
+	if r.replicated(name) {
+		_, err := r.propose(ctx, LogEntry{Kind: ReleaseEntry, Node: name})
+		return err
+	}
+	return r.history.Release(name)
+}
+
+func (r *Restarter) isLeader(containerName string) bool {
+	leader := r.Leader()
+	return leader != -1 && containerName == restarterName(leader)
 }
@@ -0,0 +1,222 @@
+package results
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+)
+
+type DifferenceKind int
+
+const (
+	// A row of the expected results is not in the actual results
+	Missing DifferenceKind = iota
+	// A row of the actual results is not in the expected results
+	Unexpected
+	// A row is in both results, but with different values
+	Mismatch
+	// Rows are not sorted by stat, in descending order
+	Unordered
+)
+
+func (k DifferenceKind) String() string {
+	switch k {
+	case Missing:
+		return "missing"
+	case Unexpected:
+		return "unexpected"
+	case Mismatch:
+		return "mismatch"
+	case Unordered:
+		return "unordered"
+	}
+	return "unknown"
+}
+
+type Difference struct {
+	Query int
+	Kind  DifferenceKind
+	// Rows involved, formatted as CSV. Empty when not applicable
+	Expected string
+	Actual   string
+}
+
+func (d Difference) String() string {
+	switch d.Kind {
+	case Missing:
+		return fmt.Sprintf("- %v", d.Expected)
+	case Unexpected:
+		return fmt.Sprintf("+ %v", d.Actual)
+	case Unordered:
+		return fmt.Sprintf("! %v (out of order)", d.Actual)
+	default:
+		return fmt.Sprintf("~ %v -> %v", d.Expected, d.Actual)
+	}
+}
+
+type Options struct {
+	// Maximum absolute difference between two numeric values to consider them equal
+	Tolerance float64
+}
+
+// Compares the actual results with the expected ones:
+//   - Q1: counts of each platform
+//   - Q2, Q3: top N by stat. Ties may be in any order, and when the last
+//     position is tied, any of the tied games may be chosen
+//   - Q4, Q5: set of games, in any order
+func Compare(actual Results, expected Results, opts Options) []Difference {
+	diffs := compareCounts(actual.Q1, expected.Q1, opts)
+	for _, query := range []int{2, 3} {
+		diffs = append(diffs, compareTop(query, actual.Stats(query), expected.Stats(query), opts)...)
+	}
+	for _, query := range []int{4, 5} {
+		diffs = append(diffs, compareSet(query, actual.Stats(query), expected.Stats(query), opts)...)
+	}
+	return diffs
+}
+
+func compareCounts(actual map[string]float64, expected map[string]float64, opts Options) []Difference {
+	diffs := make([]Difference, 0)
+	for _, column := range slices.Sorted(maps.Keys(expected)) {
+		value, ok := actual[column]
+		if !ok {
+			diffs = append(diffs, Difference{Query: 1, Kind: Missing, Expected: formatCount(column, expected[column])})
+			continue
+		}
+		if !opts.equal(value, expected[column]) {
+			diffs = append(diffs, Difference{
+				Query:    1,
+				Kind:     Mismatch,
+				Expected: formatCount(column, expected[column]),
+				Actual:   formatCount(column, value),
+			})
+		}
+	}
+	for _, column := range slices.Sorted(maps.Keys(actual)) {
+		if _, ok := expected[column]; !ok {
+			diffs = append(diffs, Difference{Query: 1, Kind: Unexpected, Actual: formatCount(column, actual[column])})
+		}
+	}
+	return diffs
+}
+
+func compareSet(query int, actual []Stat, expected []Stat, opts Options) []Difference {
+	diffs := make([]Difference, 0)
+
+	actualByID := indexStats(actual)
+	for _, e := range expected {
+		a, ok := actualByID[e.AppID]
+		if !ok {
+			diffs = append(diffs, Difference{Query: query, Kind: Missing, Expected: e.String()})
+			continue
+		}
+		if a.Name != e.Name || !opts.equal(a.Stat, e.Stat) {
+			diffs = append(diffs, Difference{Query: query, Kind: Mismatch, Expected: e.String(), Actual: a.String()})
+		}
+	}
+
+	expectedByID := indexStats(expected)
+	for _, a := range actual {
+		if _, ok := expectedByID[a.AppID]; !ok {
+			diffs = append(diffs, Difference{Query: query, Kind: Unexpected, Actual: a.String()})
+		}
+	}
+
+	return diffs
+}
+
+func compareTop(query int, actual []Stat, expected []Stat, opts Options) []Difference {
+	diffs := make([]Difference, 0)
+
+	for i := 1; i < len(actual); i++ {
+		if actual[i].Stat > actual[i-1].Stat+opts.Tolerance {
+			diffs = append(diffs, Difference{Query: query, Kind: Unordered, Actual: actual[i].String()})
+		}
+	}
+
+	if len(expected) == 0 {
+		return append(diffs, compareSet(query, actual, expected, opts)...)
+	}
+
+	// games tied in the last position may have been cut off differently,
+	// so only their amount is compared
+	last := slices.MinFunc(expected, func(a, b Stat) int { return cmp.Compare(a.Stat, b.Stat) }).Stat
+	expectedAbove, expectedTied := splitTied(expected, last, opts)
+	actualAbove, actualTied := splitTied(actual, last, opts)
+
+	diffs = append(diffs, compareSet(query, actualAbove, expectedAbove, opts)...)
+	tiedDiffs := compareSet(query, actualTied, expectedTied, opts)
+	if len(actualTied) != len(expectedTied) {
+		return append(diffs, tiedDiffs...)
+	}
+	for _, d := range tiedDiffs {
+		if d.Kind == Mismatch {
+			diffs = append(diffs, d)
+		}
+	}
+
+	return diffs
+}
+
+// Splits the stats into those above the given value, and those tied with
+// it. Stats below it are considered above, so that they are reported
+func splitTied(stats []Stat, value float64, opts Options) (above []Stat, tied []Stat) {
+	for _, s := range stats {
+		if opts.equal(s.Stat, value) {
+			tied = append(tied, s)
+		} else {
+			above = append(above, s)
+		}
+	}
+	return
+}
+
+func indexStats(stats []Stat) map[uint64]Stat {
+	index := make(map[uint64]Stat, len(stats))
+	for _, s := range stats {
+		index[s.AppID] = s
+	}
+	return index
+}
+
+func (o Options) equal(a, b float64) bool {
+	return math.Abs(a-b) <= o.Tolerance
+}
+
+func (s Stat) String() string {
+	return fmt.Sprintf("%v,%v,%v", s.AppID, s.Name, formatFloat(s.Stat))
+}
+
+func formatCount(column string, value float64) string {
+	return fmt.Sprintf("%v=%v", column, formatFloat(value))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Writes the differences of each query, returns whether there were any
+func WriteReport(w io.Writer, diffs []Difference) bool {
+	byQuery := make(map[int][]Difference)
+	for _, d := range diffs {
+		byQuery[d.Query] = append(byQuery[d.Query], d)
+	}
+
+	for query := 1; query <= QUERIES; query++ {
+		queryDiffs := byQuery[query]
+		if len(queryDiffs) == 0 {
+			fmt.Fprintf(w, "Q%v: OK\n", query)
+			continue
+		}
+		fmt.Fprintf(w, "Q%v: %v differences\n", query, len(queryDiffs))
+		for _, d := range queryDiffs {
+			fmt.Fprintf(w, "  %v\n", d)
+		}
+	}
+
+	return len(diffs) > 0
+}
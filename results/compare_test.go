@@ -0,0 +1,135 @@
+package results_test
+
+import (
+	"distribuidos/tp1/results"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func stats(s ...results.Stat) []results.Stat { return append([]results.Stat{}, s...) }
+
+func base() results.Results {
+	return results.Results{
+		Q1: map[string]float64{"Linux": 1, "Mac": 2, "Windows": 3},
+		Q2: stats(
+			results.Stat{AppID: 1, Name: "a", Stat: 30},
+			results.Stat{AppID: 2, Name: "b", Stat: 20},
+			results.Stat{AppID: 3, Name: "c", Stat: 20},
+			results.Stat{AppID: 4, Name: "d", Stat: 10},
+		),
+		Q3: stats(),
+		Q4: stats(
+			results.Stat{AppID: 5, Name: "e", Stat: 5001},
+			results.Stat{AppID: 6, Name: "f", Stat: 6000},
+		),
+		Q5: stats(),
+	}
+}
+
+func TestCompareEqual(t *testing.T) {
+	diffs := results.Compare(base(), base(), results.Options{})
+	if len(diffs) != 0 {
+		t.Fatalf("Expected no differences, got %v", diffs)
+	}
+}
+
+func TestCompareTies(t *testing.T) {
+	actual := base()
+	// tied games swapped
+	actual.Q2 = stats(actual.Q2[0], actual.Q2[2], actual.Q2[1], actual.Q2[3])
+	// a different game tied in the last position
+	actual.Q2[3] = results.Stat{AppID: 7, Name: "g", Stat: 10}
+	// sets in any order
+	actual.Q4 = stats(actual.Q4[1], actual.Q4[0])
+
+	diffs := results.Compare(actual, base(), results.Options{})
+	if len(diffs) != 0 {
+		t.Fatalf("Expected no differences, got %v", diffs)
+	}
+}
+
+func TestCompareDifferences(t *testing.T) {
+	actual := base()
+	actual.Q1 = map[string]float64{"Linux": 1, "Mac": 4, "Windows": 3}
+	actual.Q2 = stats(actual.Q2[1], actual.Q2[0], actual.Q2[2])
+	actual.Q4 = stats(
+		results.Stat{AppID: 5, Name: "e", Stat: 5002},
+		results.Stat{AppID: 8, Name: "h", Stat: 7000},
+	)
+
+	diffs := results.Compare(actual, base(), results.Options{})
+	expected := []results.Difference{
+		{Query: 1, Kind: results.Mismatch, Expected: "Mac=2", Actual: "Mac=4"},
+		{Query: 2, Kind: results.Unordered, Actual: "1,a,30"},
+		{Query: 2, Kind: results.Missing, Expected: "4,d,10"},
+		{Query: 4, Kind: results.Mismatch, Expected: "5,e,5001", Actual: "5,e,5002"},
+		{Query: 4, Kind: results.Missing, Expected: "6,f,6000"},
+		{Query: 4, Kind: results.Unexpected, Actual: "8,h,7000"},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("Expected differences %v, got %v", expected, diffs)
+	}
+}
+
+func TestCompareTolerance(t *testing.T) {
+	actual := base()
+	actual.Q4 = stats(
+		results.Stat{AppID: 5, Name: "e", Stat: 5001.4},
+		results.Stat{AppID: 6, Name: "f", Stat: 5999.6},
+	)
+
+	diffs := results.Compare(actual, base(), results.Options{Tolerance: 0.5})
+	if len(diffs) != 0 {
+		t.Fatalf("Expected no differences, got %v", diffs)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"1.csv": "Linux,Mac,Windows\n1,2,3\n",
+		"2.csv": "AppID,Name,Average playtime forever\n1,a,30\n2,b,20\n3,c,20\n4,d,10\n",
+		"3.csv": "AppID,Name,Reviews\n",
+		"4.csv": "AppID,Name,Reviews\n5,e,5001\n6,f,6000.0\n",
+		"5.csv": "AppID,Name,Reviews\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := results.Load(dir)
+	if err != nil {
+		t.Fatalf("Failed to load results: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, base()) {
+		t.Fatalf("Expected %v, got %v", base(), loaded)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	err := results.Write(dir, base())
+	if err != nil {
+		t.Fatalf("Failed to write results: %v", err)
+	}
+
+	loaded, err := results.Load(dir)
+	if err != nil {
+		t.Fatalf("Failed to load results: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, base()) {
+		t.Fatalf("Expected %v, got %v", base(), loaded)
+	}
+	q1, err := os.ReadFile(filepath.Join(dir, "1.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(q1) != "Linux,Mac,Windows\n1,2,3\n" {
+		t.Fatalf("Unexpected Q1 file: %q", q1)
+	}
+}
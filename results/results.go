@@ -0,0 +1,179 @@
+// Package results loads and compares the results of the queries, as
+// written by the client or by the reference solution
+package results
+
+import (
+	"distribuidos/tp1/protocol"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const QUERIES = 5
+
+var invalidResultsError = errors.New("invalid results file")
+
+// A row of the results of Q2 to Q5
+type Stat struct {
+	AppID uint64
+	Name  string
+	Stat  float64
+}
+
+// Results of all queries. Q1 holds the games count of each platform
+type Results struct {
+	Q1 map[string]float64
+	Q2 []Stat
+	Q3 []Stat
+	Q4 []Stat
+	Q5 []Stat
+}
+
+// Returns the results of the given query, from 2 to 5
+func (r Results) Stats(query int) []Stat {
+	switch query {
+	case 2:
+		return r.Q2
+	case 3:
+		return r.Q3
+	case 4:
+		return r.Q4
+	case 5:
+		return r.Q5
+	}
+	return nil
+}
+
+// Loads the results from a directory with a `N.csv` file for each query
+func Load(dir string) (Results, error) {
+	var r Results
+	var err error
+
+	r.Q1, err = loadCounts(filepath.Join(dir, "1.csv"))
+	if err != nil {
+		return r, err
+	}
+	stats := []*[]Stat{&r.Q2, &r.Q3, &r.Q4, &r.Q5}
+	for i, s := range stats {
+		*s, err = loadStats(filepath.Join(dir, fmt.Sprintf("%v.csv", i+2)))
+		if err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+func readCSV(path string) ([]string, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w %v: %w", invalidResultsError, path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("%w %v: missing header", invalidResultsError, path)
+	}
+	return records[0], records[1:], nil
+}
+
+// Loads a single row of counts, keyed by column name
+func loadCounts(path string) (map[string]float64, error) {
+	header, rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("%w %v: expected a single row, got %v", invalidResultsError, path, len(rows))
+	}
+
+	counts := make(map[string]float64)
+	for i, column := range header {
+		counts[column], err = strconv.ParseFloat(rows[0][i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w %v: column %v: %w", invalidResultsError, path, column, err)
+		}
+	}
+	return counts, nil
+}
+
+// Loads rows of the form `AppID,Name,Stat`
+func loadStats(path string) ([]Stat, error) {
+	_, rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]Stat, 0, len(rows))
+	for i, row := range rows {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("%w %v: line %v: expected 3 fields, got %v", invalidResultsError, path, i+2, len(row))
+		}
+		appID, err := strconv.ParseUint(row[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w %v: line %v: %w", invalidResultsError, path, i+2, err)
+		}
+		stat, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w %v: line %v: %w", invalidResultsError, path, i+2, err)
+		}
+		stats = append(stats, Stat{AppID: appID, Name: row[1], Stat: stat})
+	}
+	return stats, nil
+}
+
+// Writes the results to a directory, with a `N.csv` file for each query
+func Write(dir string, r Results) error {
+	header := protocol.Q1Result{}.Header()
+	counts := make([]string, len(header))
+	for i, column := range header {
+		counts[i] = formatFloat(r.Q1[column])
+	}
+	err := writeCSV(filepath.Join(dir, "1.csv"), header, [][]string{counts})
+	if err != nil {
+		return err
+	}
+
+	headers := [][]string{
+		protocol.Q2Result{}.Header(),
+		protocol.Q3Result{}.Header(),
+		protocol.Q4Result{}.Header(),
+		protocol.Q5Result{}.Header(),
+	}
+	for i, header := range headers {
+		query := i + 2
+		rows := make([][]string, 0, len(r.Stats(query)))
+		for _, s := range r.Stats(query) {
+			rows = append(rows, []string{strconv.FormatUint(s.AppID, 10), s.Name, formatFloat(s.Stat)})
+		}
+		err = writeCSV(filepath.Join(dir, fmt.Sprintf("%v.csv", query)), header, rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCSV(path string, header []string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	_ = writer.Write(header)
+	_ = writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
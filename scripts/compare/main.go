@@ -0,0 +1,38 @@
+package main
+
+import (
+	"distribuidos/tp1/results"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	tolerance := flag.Float64("tolerance", 0, "maximum difference between two numeric values to consider them equal")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Compare results with reference values\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %v [flags] <results-dir> <reference-dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	actual, err := results.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load results: %v\n", err)
+		os.Exit(2)
+	}
+	expected, err := results.Load(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load reference: %v\n", err)
+		os.Exit(2)
+	}
+
+	diffs := results.Compare(actual, expected, results.Options{Tolerance: *tolerance})
+	if results.WriteReport(os.Stdout, diffs) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"distribuidos/tp1/pipeline"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+)
+
+// Path where a custom pipeline is mounted in the nodes, without extension
+const PIPELINE_MOUNT = "/pipeline"
+
+// File with the pipeline mounted in the nodes when replicas are overridden
+const EFFECTIVE_PIPELINE = ".pipeline.yaml"
+
+func generateCompose(w io.Writer, spec pipeline.Spec, cfg config) {
+	generateInit(w)
+	generateRabbit(w)
+	for _, stage := range spec.Stages {
+		generateStage(w, cfg, stage)
+	}
+	generateClient(w, cfg)
+	generateRestarter(w, cfg)
+	if cfg.Stress {
+		generateKiller(w, cfg)
+	}
+	generateNet(w)
+}
+
+func generateInit(w io.Writer) {
+	fmt.Fprintln(w, "name: tp1")
+	fmt.Fprintln(w, "services:")
+}
+
+func generateRabbit(w io.Writer) {
+	fmt.Fprintln(w, "  rabbitmq:")
+	fmt.Fprintln(w, "    container_name: rabbitmq")
+	fmt.Fprintln(w, "    image: rabbitmq:4-management")
+	fmt.Fprintln(w, "    ports:")
+	fmt.Fprintln(w, "      - 5672:5672")
+	fmt.Fprintln(w, "      - 15672:15672")
+	fmt.Fprintln(w, "    networks:")
+	fmt.Fprintln(w, "      - net")
+	fmt.Fprintln(w, "    healthcheck:")
+	fmt.Fprintln(w, "      test: [\"CMD\", \"rabbitmqctl\", \"status\"]")
+	fmt.Fprintln(w, "      interval: 5s")
+	fmt.Fprintln(w, "      timeout: 5s")
+	fmt.Fprintln(w, "      retries: 3")
+}
+
+func generateClient(w io.Writer, cfg config) {
+	for i := 1; i <= cfg.Clients; i++ {
+		fmt.Fprintf(w, "  client-%v:\n", i)
+		fmt.Fprintf(w, "    container_name: client-%v\n", i)
+		fmt.Fprintln(w, "    image: tp1:latest")
+		fmt.Fprintln(w, "    entrypoint: /build/client")
+		fmt.Fprintln(w, "    environment:")
+		fmt.Fprintln(w, "      - GATEWAY_CONN_ADDR=gateway:9001")
+		fmt.Fprintln(w, "      - GATEWAY_DATA_ADDR=gateway:9002")
+		fmt.Fprintln(w, "    volumes:")
+		fmt.Fprintf(w, "      - %v:/work/.data\n", cfg.Dataset)
+		fmt.Fprintf(w, "      - ./.results-%v:/work/.results\n", i)
+		fmt.Fprintln(w, "    networks:")
+		fmt.Fprintln(w, "      - net")
+		fmt.Fprintln(w, "    depends_on:")
+		fmt.Fprintln(w, "      - gateway")
+	}
+}
+
+func generateStage(w io.Writer, cfg config, stage pipeline.Stage) {
+	for i, instance := range stage.Instances() {
+		fmt.Fprintf(w, "  %v:\n", instance)
+		fmt.Fprintf(w, "    container_name: %v\n", instance)
+		fmt.Fprintln(w, "    image: tp1:latest")
+		fmt.Fprintf(w, "    entrypoint: /build/%v\n", stage.Binary)
+		fmt.Fprintln(w, "    environment:")
+		fmt.Fprintln(w, "      - RABBIT_IP=rabbitmq")
+		fmt.Fprintf(w, "      - STAGE=%v\n", stage.Name)
+		if stage.Replicas > 0 {
+			fmt.Fprintf(w, "      - PARTITION_ID=%v\n", i+1)
+		}
+		for _, key := range slices.Sorted(maps.Keys(stage.Environment)) {
+			fmt.Fprintf(w, "      - %v=%v\n", key, stage.Environment[key])
+		}
+		if cfg.Pipeline != "" {
+			fmt.Fprintf(w, "      - PIPELINE=%v\n", PIPELINE_MOUNT+filepath.Ext(cfg.Pipeline))
+		}
+		// nodes ask the first restarters to join the cluster
+		fmt.Fprintf(w, "      - RESTARTERS=%v\n", cfg.Restarters)
+		if cfg.Secret != "" {
+			fmt.Fprintf(w, "      - RESTARTER_SECRET=%v\n", cfg.Secret)
+		}
+		if (cfg.Volumes && stage.Persistent) || cfg.Pipeline != "" {
+			fmt.Fprintln(w, "    volumes:")
+		}
+		if cfg.Volumes && stage.Persistent {
+			fmt.Fprintf(w, "      - ./.backup/%v:/work\n", instance)
+		}
+		if cfg.Pipeline != "" {
+			fmt.Fprintf(w, "      - %v:%v\n", cfg.Pipeline, PIPELINE_MOUNT+filepath.Ext(cfg.Pipeline))
+		}
+		fmt.Fprintln(w, "    networks:")
+		fmt.Fprintln(w, "      - net")
+		fmt.Fprintln(w, "    depends_on:")
+		if stage.Binary == "gateway" {
+			fmt.Fprintln(w, "      rabbitmq:")
+			fmt.Fprintln(w, "        condition: service_healthy")
+		} else {
+			fmt.Fprintln(w, "      - gateway")
+		}
+	}
+}
+
+func generateRestarter(w io.Writer, cfg config) {
+	for i := 0; i < cfg.Restarters; i++ {
+		fmt.Fprintf(w, "  restarter-%v:\n", i)
+		fmt.Fprintf(w, "    container_name: restarter-%v\n", i)
+		fmt.Fprintln(w, "    image: tp1:latest")
+		fmt.Fprintln(w, "    entrypoint: /build/restarter")
+		fmt.Fprintln(w, "    environment:")
+		fmt.Fprintf(w, "      - ID=%v\n", i)
+		fmt.Fprintf(w, "      - ADDRESS=restarter-%v:14300\n", i)
+		fmt.Fprintf(w, "      - REPLICAS=%v\n", cfg.Restarters)
+		if cfg.Secret != "" {
+			fmt.Fprintf(w, "      - RESTARTER_SECRET=%v\n", cfg.Secret)
+		}
+		fmt.Fprintln(w, "    volumes:")
+		fmt.Fprintln(w, "      - ./.restarter-config:/work/.restarter-config")
+		fmt.Fprintln(w, "      - /var/run/docker.sock:/var/run/docker.sock")
+		fmt.Fprintln(w, "    depends_on:")
+		fmt.Fprintln(w, "      - gateway")
+		fmt.Fprintln(w, "    networks:")
+		fmt.Fprintln(w, "      - net")
+	}
+}
+
+func generateKiller(w io.Writer, cfg config) {
+	fmt.Fprintln(w, "  killer:")
+	fmt.Fprintln(w, "    container_name: killer")
+	fmt.Fprintln(w, "    image: tp1:latest")
+	fmt.Fprintln(w, "    entrypoint: /build/killer")
+	fmt.Fprintln(w, "    environment:")
+	fmt.Fprintln(w, "      - NODES_PATH=.killer-config")
+	fmt.Fprintf(w, "      - PERIOD=%v\n", cfg.KillerPeriod)
+	fmt.Fprintln(w, "    volumes:")
+	fmt.Fprintln(w, "      - ./.killer-config:/work/.killer-config")
+	fmt.Fprintln(w, "      - /var/run/docker.sock:/var/run/docker.sock")
+	fmt.Fprintln(w, "    depends_on:")
+	fmt.Fprintln(w, "      - gateway")
+	fmt.Fprintln(w, "    networks:")
+	fmt.Fprintln(w, "      - net")
+}
+
+func generateNet(w io.Writer) {
+	fmt.Fprintln(w, "networks:")
+	fmt.Fprintln(w, "  net:")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the generated deployment
+const COMPOSE = "compose"
+const KUBERNETES = "kubernetes"
+
+type config struct {
+	// Either compose or kubernetes
+	Format string `yaml:"format"`
+	// Pipeline file, the default one is used if empty
+	Pipeline string `yaml:"pipeline"`
+	// Replicas of each stage, overriding the ones of the pipeline
+	Replicas map[string]int `yaml:"replicas"`
+	// Dataset directory mounted in the clients
+	Dataset    string `yaml:"dataset"`
+	Clients    int    `yaml:"clients"`
+	Restarters int    `yaml:"restarters"`
+	// Whether to run the killer, that stops nodes at random
+	Stress bool `yaml:"stress"`
+	// Milliseconds between each node killed in stress mode
+	KillerPeriod int `yaml:"killer-period"`
+	// Whether to bind mount the state of persistent stages
+	Volumes bool `yaml:"volumes"`
+	// Size of each persistent volume claim, when generating manifests
+	Storage string `yaml:"storage"`
+	// Secret with which nodes and restarters authenticate their packets,
+	// which are not authenticated if empty
+	Secret string `yaml:"secret"`
+}
+
+func defaultConfig() config {
+	return config{
+		Format:       COMPOSE,
+		Replicas:     make(map[string]int),
+		Dataset:      "./.data",
+		Clients:      3,
+		Restarters:   4,
+		Stress:       true,
+		KillerPeriod: 5000,
+		Volumes:      true,
+		Storage:      "1Gi",
+	}
+}
+
+// Replicas given as stage=n, either comma separated or repeating the flag
+type replicasFlag map[string]int
+
+func (r replicasFlag) String() string {
+	entries := make([]string, 0, len(r))
+	for stage, n := range r {
+		entries = append(entries, fmt.Sprintf("%v=%v", stage, n))
+	}
+	return strings.Join(entries, ",")
+}
+
+func (r replicasFlag) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		stage, n, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("expected stage=replicas, got %q", entry)
+		}
+		replicas, err := strconv.Atoi(n)
+		if err != nil {
+			return fmt.Errorf("invalid replicas for stage %v: %w", stage, err)
+		}
+		r[strings.TrimSpace(stage)] = replicas
+	}
+	return nil
+}
+
+// Reads the configuration file, if given, and overrides it with the flags
+// explicitly set
+func getConfig() (config, error) {
+	c := defaultConfig()
+
+	var path string
+	var flags config
+	replicas := make(replicasFlag)
+	flag.StringVar(&path, "config", "", "configuration file")
+	flag.StringVar(&flags.Format, "format", c.Format, "either compose or kubernetes")
+	flag.StringVar(&flags.Pipeline, "pipeline", "", "pipeline file, the default one is used if empty")
+	flag.Var(replicas, "replicas", "replicas of each stage, as stage=n")
+	flag.StringVar(&flags.Dataset, "dataset", c.Dataset, "dataset directory mounted in the clients")
+	flag.IntVar(&flags.Clients, "clients", c.Clients, "amount of clients")
+	flag.IntVar(&flags.Restarters, "restarters", c.Restarters, "amount of restarters")
+	flag.BoolVar(&flags.Stress, "stress", c.Stress, "run the killer")
+	flag.IntVar(&flags.KillerPeriod, "killer-period", c.KillerPeriod, "milliseconds between each node killed")
+	flag.BoolVar(&flags.Volumes, "volumes", c.Volumes, "setup bind mounts")
+	flag.StringVar(&flags.Storage, "storage", c.Storage, "size of each persistent volume claim")
+	flag.StringVar(&flags.Secret, "secret", "", "secret shared by nodes and restarters")
+	flag.Parse()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		err = yaml.Unmarshal(data, &c)
+		if err != nil {
+			return c, fmt.Errorf("invalid config file: %w", err)
+		}
+		if c.Replicas == nil {
+			c.Replicas = make(map[string]int)
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "format":
+			c.Format = flags.Format
+		case "pipeline":
+			c.Pipeline = flags.Pipeline
+		case "replicas":
+			for stage, n := range replicas {
+				c.Replicas[stage] = n
+			}
+		case "dataset":
+			c.Dataset = flags.Dataset
+		case "clients":
+			c.Clients = flags.Clients
+		case "restarters":
+			c.Restarters = flags.Restarters
+		case "stress":
+			c.Stress = flags.Stress
+		case "killer-period":
+			c.KillerPeriod = flags.KillerPeriod
+		case "volumes":
+			c.Volumes = flags.Volumes
+		case "storage":
+			c.Storage = flags.Storage
+		case "secret":
+			c.Secret = flags.Secret
+		}
+	})
+
+	return c, c.validate()
+}
+
+func (c config) validate() error {
+	if c.Format != COMPOSE && c.Format != KUBERNETES {
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+	if c.Format == KUBERNETES && c.Storage == "" {
+		return errors.New("storage must not be empty")
+	}
+	if c.Dataset == "" {
+		return errors.New("dataset must not be empty")
+	}
+	if c.Clients < 0 || c.Restarters < 0 {
+		return errors.New("clients and restarters must not be negative")
+	}
+	if c.Stress && c.KillerPeriod <= 0 {
+		return errors.New("killer period must be positive")
+	}
+	for stage, n := range c.Replicas {
+		if n < 0 {
+			return fmt.Errorf("stage %v has negative replicas", stage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"distribuidos/tp1/pipeline"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// Compares the output with the golden file, or rewrites it with -update
+func checkGolden(t *testing.T, name string, output []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := os.WriteFile(path, output, 0644)
+		if err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Fatalf("Output differs from %v, run the tests with -update if the change is expected", path)
+	}
+}
+
+// Decodes every document of the output, returning their kinds
+func decodeKinds(t *testing.T, output []byte) map[string]int {
+	kinds := make(map[string]int)
+	decoder := yaml.NewDecoder(bytes.NewReader(output))
+	for {
+		var document struct {
+			Kind string `yaml:"kind"`
+		}
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return kinds
+		}
+		if err != nil {
+			t.Fatalf("Generated invalid YAML: %v", err)
+		}
+		kinds[document.Kind] += 1
+	}
+}
+
+func TestCompose(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+
+	var b bytes.Buffer
+	generateCompose(&b, spec, cfg)
+	decodeKinds(t, b.Bytes())
+	checkGolden(t, "compose.yaml", b.Bytes())
+}
+
+// Services of a generated compose file, with their environment and volumes
+type composeFile struct {
+	Services map[string]struct {
+		Environment []string `yaml:"environment"`
+		Volumes     []string `yaml:"volumes"`
+	} `yaml:"services"`
+}
+
+// Checks that every stage partitioned by another one resolves as many
+// partitions as instances of that stage were generated
+func checkPartitionsAgree(t *testing.T, spec pipeline.Spec, instances map[string]int) {
+	for _, stage := range spec.Stages {
+		if stage.Partitions == "" {
+			continue
+		}
+		partitions, err := spec.Partitions(stage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if partitions != instances[stage.Partitions] {
+			t.Fatalf("Stage %v splits into %v partitions, but %v has %v instances", stage.Name, partitions, stage.Partitions, instances[stage.Partitions])
+		}
+	}
+}
+
+func TestComposeReplicas(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Replicas = map[string]int{"q1-count": 5}
+	spec, err = spec.WithReplicas(cfg.Replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = withEffectivePipeline(spec, cfg, filepath.Join(t.TempDir(), EFFECTIVE_PIPELINE))
+	if err != nil {
+		t.Fatalf("Failed to write pipeline: %v", err)
+	}
+
+	var b bytes.Buffer
+	generateCompose(&b, spec, cfg)
+	var compose composeFile
+	err = yaml.Unmarshal(b.Bytes(), &compose)
+	if err != nil {
+		t.Fatalf("Generated invalid YAML: %v", err)
+	}
+
+	// every node reads the pipeline with the overridden replicas
+	mount := PIPELINE_MOUNT + ".yaml"
+	instances := make(map[string]int)
+	for _, stage := range spec.Stages {
+		for _, instance := range stage.Instances() {
+			service := compose.Services[instance]
+			if !slices.Contains(service.Environment, "PIPELINE="+mount) || !slices.Contains(service.Volumes, cfg.Pipeline+":"+mount) {
+				t.Fatalf("Node %v does not read the generated pipeline, it has %+v", instance, service)
+			}
+			instances[stage.Name] += 1
+		}
+	}
+	if instances["q1-count"] != 5 {
+		t.Fatalf("Expected 5 q1-count nodes, got %v", instances["q1-count"])
+	}
+
+	mounted, err := pipeline.Load(cfg.Pipeline)
+	if err != nil {
+		t.Fatalf("Failed to load the generated pipeline: %v", err)
+	}
+	checkPartitionsAgree(t, mounted, instances)
+}
+
+func TestComposeSecret(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Secret = "s3cret"
+
+	var b bytes.Buffer
+	generateCompose(&b, spec, cfg)
+
+	// every node and restarter shares the secret
+	expected := cfg.Restarters
+	for _, stage := range spec.Stages {
+		expected += len(stage.Instances())
+	}
+	if count := strings.Count(b.String(), "- RESTARTER_SECRET=s3cret\n"); count != expected {
+		t.Fatalf("Expected the secret in %v services, got %v", expected, count)
+	}
+}
+
+func TestKubernetes(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+
+	var b bytes.Buffer
+	err = generateKubernetes(&b, spec, cfg)
+	if err != nil {
+		t.Fatalf("Failed to generate manifests: %v", err)
+	}
+
+	kinds := decodeKinds(t, b.Bytes())
+	persistent, stateless := 0, 0
+	for _, stage := range spec.Stages {
+		if stage.Persistent {
+			persistent += len(stage.Instances())
+		} else {
+			stateless += len(stage.Instances())
+		}
+	}
+	if kinds["StatefulSet"] != persistent {
+		t.Fatalf("Expected %v stateful sets, got %v", persistent, kinds["StatefulSet"])
+	}
+	// besides the stateless stages, rabbit and the restarters are deployments
+	if kinds["Deployment"] != stateless+1+cfg.Restarters {
+		t.Fatalf("Expected %v deployments, got %v", stateless+1+cfg.Restarters, kinds["Deployment"])
+	}
+	if kinds["Job"] != cfg.Clients {
+		t.Fatalf("Expected %v jobs, got %v", cfg.Clients, kinds["Job"])
+	}
+
+	checkGolden(t, "kubernetes.yaml", b.Bytes())
+}
+
+// Workload or ConfigMap of the generated manifests
+type kubernetesDocument struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Data map[string]string `yaml:"data"`
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Containers []struct {
+					Env []kubernetesEnv `yaml:"env"`
+				} `yaml:"containers"`
+			} `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+type kubernetesEnv struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+func TestKubernetesReplicas(t *testing.T) {
+	spec, err := pipeline.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Replicas = map[string]int{"q1-count": 5}
+	spec, err = spec.WithReplicas(cfg.Replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = generateKubernetes(&b, spec, cfg)
+	if err != nil {
+		t.Fatalf("Failed to generate manifests: %v", err)
+	}
+
+	stages := make(map[string]string)
+	for _, stage := range spec.Stages {
+		for _, instance := range stage.Instances() {
+			stages[instance] = stage.Name
+		}
+	}
+	var mounted *pipeline.Spec
+	instances := make(map[string]int)
+	decoder := yaml.NewDecoder(&b)
+	for {
+		var document kubernetesDocument
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Generated invalid YAML: %v", err)
+		}
+
+		if document.Kind == "ConfigMap" && document.Metadata.Name == "pipeline" {
+			parsed, err := pipeline.Parse([]byte(document.Data["pipeline.yaml"]), ".yaml")
+			if err != nil {
+				t.Fatalf("Failed to load the generated pipeline: %v", err)
+			}
+			mounted = &parsed
+		}
+		stage, ok := stages[document.Metadata.Name]
+		if !ok || (document.Kind != "Deployment" && document.Kind != "StatefulSet") {
+			continue
+		}
+		// every node reads the pipeline with the overridden replicas
+		envs := document.Spec.Template.Spec.Containers[0].Env
+		if !slices.Contains(envs, kubernetesEnv{"PIPELINE", PIPELINE_MOUNT + ".yaml"}) {
+			t.Fatalf("Node %v does not read the generated pipeline, it has %+v", document.Metadata.Name, envs)
+		}
+		instances[stage] += 1
+	}
+
+	if instances["q1-count"] != 5 {
+		t.Fatalf("Expected 5 q1-count nodes, got %v", instances["q1-count"])
+	}
+	if mounted == nil {
+		t.Fatalf("Expected the pipeline to be in a ConfigMap")
+	}
+	checkPartitionsAgree(t, *mounted, instances)
+}
+
+func TestKubernetesPipeline(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Pipeline = filepath.Join("testdata", "pipeline.yaml")
+	cfg.Clients = 1
+	cfg.Restarters = 0
+	cfg.Volumes = false
+
+	spec, err := pipeline.Load(cfg.Pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = generateKubernetes(&b, spec, cfg)
+	if err != nil {
+		t.Fatalf("Failed to generate manifests: %v", err)
+	}
+	decodeKinds(t, b.Bytes())
+	checkGolden(t, "kubernetes-pipeline.yaml", b.Bytes())
+}
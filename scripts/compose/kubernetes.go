@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"distribuidos/tp1/pipeline"
+	"distribuidos/tp1/restarter-protocol"
+	"distribuidos/tp1/utils"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Label shared by all the resources of the system
+const PART_OF_LABEL = "app.kubernetes.io/part-of: tp1"
+
+// Claim with the dataset read by the clients, which must be provisioned
+// before deploying the system
+const DATASET_CLAIM = "dataset"
+
+// Names of resources must be valid DNS labels, as they are used as hostnames
+var resourceName = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+type env struct {
+	name  string
+	value string
+}
+
+type port struct {
+	name     string
+	port     int
+	protocol string
+}
+
+// Writes the Kubernetes manifests of the system. Each instance of a stage
+// is its own workload, reachable through a headless service named after it,
+// so that nodes keep the same names as in Docker Compose. Persistent stages
+// are deployed as stateful sets, with a persistent volume for their state
+func generateKubernetes(w io.Writer, spec pipeline.Spec, cfg config) error {
+	names := nodeNames(spec, cfg)
+	for _, name := range names {
+		if !resourceName.MatchString(name) {
+			return fmt.Errorf("%q is not a valid resource name", name)
+		}
+	}
+
+	ext, data, err := customPipeline(spec, cfg)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		generatePipelineConfigMap(w, ext, data)
+	}
+
+	generateKubernetesRabbit(w)
+	for _, stage := range spec.Stages {
+		for i, instance := range stage.Instances() {
+			generateKubernetesStage(w, cfg, ext, stage, i+1, instance)
+		}
+	}
+	generateKubernetesClient(w, cfg)
+	if cfg.Restarters > 0 {
+		nodes := slices.DeleteFunc(names, func(name string) bool {
+			return strings.Contains(name, restarter.RESTARTER_NAME)
+		})
+		generateKubernetesRestarter(w, cfg, nodes)
+	}
+	return nil
+}
+
+// Pipeline read by the nodes instead of the embedded one, and its extension.
+// When the replicas are overridden, the nodes read the resulting pipeline,
+// so that they agree on the partitions. Returns nil if there is none
+func customPipeline(spec pipeline.Spec, cfg config) (string, []byte, error) {
+	if len(cfg.Replicas) > 0 {
+		data, err := yaml.Marshal(spec)
+		return ".yaml", data, err
+	}
+	if cfg.Pipeline != "" {
+		data, err := os.ReadFile(cfg.Pipeline)
+		return filepath.Ext(cfg.Pipeline), data, err
+	}
+	return "", nil, nil
+}
+
+func generatePipelineConfigMap(w io.Writer, ext string, data []byte) {
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: v1")
+	fmt.Fprintln(w, "kind: ConfigMap")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintln(w, "  name: pipeline")
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "data:")
+	fmt.Fprintf(w, "  pipeline%v: |\n", ext)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			fmt.Fprintln(w)
+			continue
+		}
+		fmt.Fprintf(w, "    %v\n", scanner.Text())
+	}
+}
+
+func generateKubernetesRabbit(w io.Writer) {
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: v1")
+	fmt.Fprintln(w, "kind: Service")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintln(w, "  name: rabbitmq")
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "spec:")
+	fmt.Fprintln(w, "  selector:")
+	fmt.Fprintf(w, "    %v: rabbitmq\n", restarter.INSTANCE_LABEL)
+	fmt.Fprintln(w, "  ports:")
+	fmt.Fprintln(w, "    - name: amqp")
+	fmt.Fprintln(w, "      port: 5672")
+	fmt.Fprintln(w, "    - name: management")
+	fmt.Fprintln(w, "      port: 15672")
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: apps/v1")
+	fmt.Fprintln(w, "kind: Deployment")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintln(w, "  name: rabbitmq")
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "spec:")
+	fmt.Fprintln(w, "  replicas: 1")
+	fmt.Fprintln(w, "  selector:")
+	fmt.Fprintln(w, "    matchLabels:")
+	fmt.Fprintf(w, "      %v: rabbitmq\n", restarter.INSTANCE_LABEL)
+	fmt.Fprintln(w, "  template:")
+	fmt.Fprintln(w, "    metadata:")
+	fmt.Fprintln(w, "      labels:")
+	fmt.Fprintf(w, "        %v\n", PART_OF_LABEL)
+	fmt.Fprintf(w, "        %v: rabbitmq\n", restarter.INSTANCE_LABEL)
+	fmt.Fprintln(w, "    spec:")
+	fmt.Fprintln(w, "      containers:")
+	fmt.Fprintln(w, "        - name: rabbitmq")
+	fmt.Fprintln(w, "          image: rabbitmq:4-management")
+	fmt.Fprintln(w, "          ports:")
+	fmt.Fprintln(w, "            - containerPort: 5672")
+	fmt.Fprintln(w, "            - containerPort: 15672")
+	fmt.Fprintln(w, "          readinessProbe:")
+	fmt.Fprintln(w, "            exec:")
+	fmt.Fprintln(w, "              command: [\"rabbitmqctl\", \"status\"]")
+	fmt.Fprintln(w, "            periodSeconds: 5")
+	fmt.Fprintln(w, "            timeoutSeconds: 5")
+	fmt.Fprintln(w, "            failureThreshold: 3")
+}
+
+// Nodes read the pipeline of the ConfigMap if its extension is not empty
+func generateKubernetesStage(w io.Writer, cfg config, pipelineExt string, stage pipeline.Stage, partition int, instance string) {
+	envs := []env{
+		{"RABBIT_IP", "rabbitmq"},
+		{"STAGE", stage.Name},
+	}
+	if stage.Replicas > 0 {
+		envs = append(envs, env{"PARTITION_ID", fmt.Sprint(partition)})
+	}
+	for _, key := range slices.Sorted(maps.Keys(stage.Environment)) {
+		envs = append(envs, env{key, stage.Environment[key]})
+	}
+	if pipelineExt != "" {
+		envs = append(envs, env{"PIPELINE", PIPELINE_MOUNT + pipelineExt})
+	}
+	// nodes ask the first restarters to join the cluster
+	envs = append(envs, env{"RESTARTERS", fmt.Sprint(cfg.Restarters)})
+	if cfg.Secret != "" {
+		envs = append(envs, env{"RESTARTER_SECRET", cfg.Secret})
+	}
+
+	ports := []port{{"keepalive", utils.NODE_PORT, "UDP"}}
+	if stage.Binary == "gateway" {
+		ports = append(ports, port{"connection", 9001, "TCP"}, port{"data", 9002, "TCP"})
+	}
+	generateHeadlessService(w, instance, ports)
+
+	persistent := stage.Persistent && cfg.Volumes
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: apps/v1")
+	if stage.Persistent {
+		fmt.Fprintln(w, "kind: StatefulSet")
+	} else {
+		fmt.Fprintln(w, "kind: Deployment")
+	}
+	generateWorkloadMetadata(w, instance, stage.Name)
+	if stage.Persistent {
+		fmt.Fprintf(w, "  serviceName: %v\n", instance)
+	}
+	fmt.Fprintln(w, "  template:")
+	generatePodMetadata(w, instance, stage.Name)
+	fmt.Fprintln(w, "    spec:")
+	fmt.Fprintln(w, "      containers:")
+	generateContainer(w, stage.Binary, envs)
+	if persistent || pipelineExt != "" {
+		fmt.Fprintln(w, "          volumeMounts:")
+	}
+	if persistent {
+		fmt.Fprintln(w, "            - name: state")
+		fmt.Fprintln(w, "              mountPath: /work")
+	}
+	if pipelineExt != "" {
+		fmt.Fprintln(w, "            - name: pipeline")
+		fmt.Fprintf(w, "              mountPath: %v\n", PIPELINE_MOUNT+pipelineExt)
+		fmt.Fprintf(w, "              subPath: pipeline%v\n", pipelineExt)
+		fmt.Fprintln(w, "      volumes:")
+		fmt.Fprintln(w, "        - name: pipeline")
+		fmt.Fprintln(w, "          configMap:")
+		fmt.Fprintln(w, "            name: pipeline")
+	}
+	if persistent {
+		fmt.Fprintln(w, "  volumeClaimTemplates:")
+		fmt.Fprintln(w, "    - metadata:")
+		fmt.Fprintln(w, "        name: state")
+		fmt.Fprintln(w, "      spec:")
+		fmt.Fprintln(w, "        accessModes: [\"ReadWriteOnce\"]")
+		fmt.Fprintln(w, "        resources:")
+		fmt.Fprintln(w, "          requests:")
+		fmt.Fprintf(w, "            storage: %v\n", cfg.Storage)
+	}
+}
+
+func generateKubernetesClient(w io.Writer, cfg config) {
+	for i := 1; i <= cfg.Clients; i++ {
+		name := fmt.Sprintf("client-%v", i)
+		results := fmt.Sprintf("results-%v", i)
+
+		fmt.Fprintln(w, "---")
+		fmt.Fprintln(w, "apiVersion: v1")
+		fmt.Fprintln(w, "kind: PersistentVolumeClaim")
+		fmt.Fprintln(w, "metadata:")
+		fmt.Fprintf(w, "  name: %v\n", results)
+		fmt.Fprintln(w, "  labels:")
+		fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+		fmt.Fprintln(w, "spec:")
+		fmt.Fprintln(w, "  accessModes: [\"ReadWriteOnce\"]")
+		fmt.Fprintln(w, "  resources:")
+		fmt.Fprintln(w, "    requests:")
+		fmt.Fprintf(w, "      storage: %v\n", cfg.Storage)
+
+		fmt.Fprintln(w, "---")
+		fmt.Fprintln(w, "apiVersion: batch/v1")
+		fmt.Fprintln(w, "kind: Job")
+		fmt.Fprintln(w, "metadata:")
+		fmt.Fprintf(w, "  name: %v\n", name)
+		fmt.Fprintln(w, "  labels:")
+		fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+		fmt.Fprintln(w, "spec:")
+		fmt.Fprintln(w, "  template:")
+		generatePodMetadata(w, name, "client")
+		fmt.Fprintln(w, "    spec:")
+		fmt.Fprintln(w, "      restartPolicy: OnFailure")
+		fmt.Fprintln(w, "      containers:")
+		generateContainer(w, "client", []env{
+			{"GATEWAY_CONN_ADDR", "gateway:9001"},
+			{"GATEWAY_DATA_ADDR", "gateway:9002"},
+		})
+		fmt.Fprintln(w, "          volumeMounts:")
+		fmt.Fprintln(w, "            - name: dataset")
+		fmt.Fprintln(w, "              mountPath: /work/.data")
+		fmt.Fprintln(w, "              readOnly: true")
+		fmt.Fprintln(w, "            - name: results")
+		fmt.Fprintln(w, "              mountPath: /work/.results")
+		fmt.Fprintln(w, "      volumes:")
+		fmt.Fprintln(w, "        - name: dataset")
+		fmt.Fprintln(w, "          persistentVolumeClaim:")
+		fmt.Fprintf(w, "            claimName: %v\n", DATASET_CLAIM)
+		fmt.Fprintln(w, "            readOnly: true")
+		fmt.Fprintln(w, "        - name: results")
+		fmt.Fprintln(w, "          persistentVolumeClaim:")
+		fmt.Fprintf(w, "            claimName: %v\n", results)
+	}
+}
+
+// Restarters delete the pods of fallen nodes through the Kubernetes API, so
+// they run with a service account allowed to do so
+func generateKubernetesRestarter(w io.Writer, cfg config, nodes []string) {
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: v1")
+	fmt.Fprintln(w, "kind: ConfigMap")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintln(w, "  name: restarter-config")
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "data:")
+	fmt.Fprintf(w, "  %v: |\n", restarter.CONFIG_PATH)
+	for _, node := range nodes {
+		fmt.Fprintf(w, "    %v\n", node)
+	}
+
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: v1")
+	fmt.Fprintln(w, "kind: ServiceAccount")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintln(w, "  name: restarter")
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: rbac.authorization.k8s.io/v1")
+	fmt.Fprintln(w, "kind: Role")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintln(w, "  name: restarter")
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "rules:")
+	fmt.Fprintln(w, "  - apiGroups: [\"\"]")
+	fmt.Fprintln(w, "    resources: [\"pods\"]")
+	fmt.Fprintln(w, "    verbs: [\"list\", \"delete\", \"deletecollection\"]")
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: rbac.authorization.k8s.io/v1")
+	fmt.Fprintln(w, "kind: RoleBinding")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintln(w, "  name: restarter")
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "subjects:")
+	fmt.Fprintln(w, "  - kind: ServiceAccount")
+	fmt.Fprintln(w, "    name: restarter")
+	fmt.Fprintln(w, "roleRef:")
+	fmt.Fprintln(w, "  apiGroup: rbac.authorization.k8s.io")
+	fmt.Fprintln(w, "  kind: Role")
+	fmt.Fprintln(w, "  name: restarter")
+
+	for i := 0; i < cfg.Restarters; i++ {
+		name := fmt.Sprintf("%v%v", restarter.RESTARTER_NAME, i)
+		generateHeadlessService(w, name, []port{{"election", utils.RESTARTER_PORT, "UDP"}})
+
+		fmt.Fprintln(w, "---")
+		fmt.Fprintln(w, "apiVersion: apps/v1")
+		fmt.Fprintln(w, "kind: Deployment")
+		generateWorkloadMetadata(w, name, "restarter")
+		fmt.Fprintln(w, "  template:")
+		generatePodMetadata(w, name, "restarter")
+		fmt.Fprintln(w, "    spec:")
+		fmt.Fprintln(w, "      serviceAccountName: restarter")
+		fmt.Fprintln(w, "      containers:")
+		envs := []env{
+			{"ID", fmt.Sprint(i)},
+			{"ADDRESS", fmt.Sprintf(":%v", utils.RESTARTER_PORT)},
+			{"REPLICAS", fmt.Sprint(cfg.Restarters)},
+			{"RESTART_MODE", restarter.KUBERNETES_MODE},
+		}
+		if cfg.Secret != "" {
+			envs = append(envs, env{"RESTARTER_SECRET", cfg.Secret})
+		}
+		generateContainer(w, "restarter", envs)
+		fmt.Fprintln(w, "          volumeMounts:")
+		fmt.Fprintln(w, "            - name: config")
+		fmt.Fprintf(w, "              mountPath: /work/%v\n", restarter.CONFIG_PATH)
+		fmt.Fprintf(w, "              subPath: %v\n", restarter.CONFIG_PATH)
+		fmt.Fprintln(w, "      volumes:")
+		fmt.Fprintln(w, "        - name: config")
+		fmt.Fprintln(w, "          configMap:")
+		fmt.Fprintln(w, "            name: restarter-config")
+	}
+}
+
+func generateHeadlessService(w io.Writer, name string, ports []port) {
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "apiVersion: v1")
+	fmt.Fprintln(w, "kind: Service")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintf(w, "  name: %v\n", name)
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintln(w, "spec:")
+	fmt.Fprintln(w, "  clusterIP: None")
+	fmt.Fprintln(w, "  selector:")
+	fmt.Fprintf(w, "    %v: %v\n", restarter.INSTANCE_LABEL, name)
+	fmt.Fprintln(w, "  ports:")
+	for _, p := range ports {
+		fmt.Fprintf(w, "    - name: %v\n", p.name)
+		fmt.Fprintf(w, "      port: %v\n", p.port)
+		fmt.Fprintf(w, "      protocol: %v\n", p.protocol)
+	}
+}
+
+func generateWorkloadMetadata(w io.Writer, instance string, component string) {
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintf(w, "  name: %v\n", instance)
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintf(w, "    %v\n", PART_OF_LABEL)
+	fmt.Fprintf(w, "    app.kubernetes.io/component: %v\n", component)
+	fmt.Fprintln(w, "spec:")
+	fmt.Fprintln(w, "  replicas: 1")
+	fmt.Fprintln(w, "  selector:")
+	fmt.Fprintln(w, "    matchLabels:")
+	fmt.Fprintf(w, "      %v: %v\n", restarter.INSTANCE_LABEL, instance)
+}
+
+func generatePodMetadata(w io.Writer, instance string, component string) {
+	fmt.Fprintln(w, "    metadata:")
+	fmt.Fprintln(w, "      labels:")
+	fmt.Fprintf(w, "        %v\n", PART_OF_LABEL)
+	fmt.Fprintf(w, "        app.kubernetes.io/component: %v\n", component)
+	fmt.Fprintf(w, "        %v: %v\n", restarter.INSTANCE_LABEL, instance)
+}
+
+func generateContainer(w io.Writer, binary string, envs []env) {
+	fmt.Fprintf(w, "        - name: %v\n", binary)
+	fmt.Fprintln(w, "          image: tp1:latest")
+	fmt.Fprintln(w, "          imagePullPolicy: IfNotPresent")
+	fmt.Fprintf(w, "          command: [\"/build/%v\"]\n", binary)
+	fmt.Fprintln(w, "          env:")
+	for _, e := range envs {
+		fmt.Fprintf(w, "            - name: %v\n", e.name)
+		fmt.Fprintf(w, "              value: %q\n", e.value)
+	}
+}
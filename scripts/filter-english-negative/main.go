@@ -1,6 +1,7 @@
 package main
 
 import (
+	"distribuidos/tp1/language"
 	"distribuidos/tp1/middleware"
 	"distribuidos/tp1/utils"
 	"encoding/csv"
@@ -11,8 +12,6 @@ import (
 	"os"
 	"strconv"
 	"sync"
-
-	lingua "github.com/pemistahl/lingua-go"
 )
 
 const MAX_THREADS = 8
@@ -20,13 +19,7 @@ const MAX_THREADS = 8
 func run(input, output chan []string) {
 	log.Println("Starting language filter")
 
-	languages := []lingua.Language{
-		lingua.English,
-		lingua.Spanish,
-	}
-	detector := lingua.NewLanguageDetectorBuilder().
-		FromLanguages(languages...).
-		Build()
+	detector := language.NewDetector()
 
 	for record := range input {
 		review, err := reviewFromFullRecord(record)
@@ -36,8 +29,7 @@ func run(input, output chan []string) {
 		if review.Score != middleware.NegativeScore {
 			continue
 		}
-		lang, _ := detector.DetectLanguageOf(review.Text)
-		if lang == lingua.English {
+		if language.IsEnglish(detector, review.Text) {
 			output <- record
 		}
 	}
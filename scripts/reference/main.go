@@ -0,0 +1,58 @@
+package main
+
+import (
+	"distribuidos/tp1/reference"
+	"distribuidos/tp1/results"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	workers := flag.Int("workers", 0, "amount of parsing workers, defaults to the number of CPUs")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Solve all queries locally, to obtain reference results\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %v [flags] <data-dir> <output-dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input, output := flag.Arg(0), flag.Arg(1)
+
+	err := run(input, output, reference.Options{Workers: *workers})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to solve queries: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(input string, output string, opts reference.Options) error {
+	games, err := os.Open(filepath.Join(input, "games.csv"))
+	if err != nil {
+		return err
+	}
+	defer games.Close()
+	reviews, err := os.Open(filepath.Join(input, "reviews.csv"))
+	if err != nil {
+		return err
+	}
+	defer reviews.Close()
+
+	start := time.Now()
+	r, err := reference.Solve(games, reviews, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Solved queries in %v\n", time.Since(start).Round(time.Millisecond))
+
+	err = os.MkdirAll(output, 0o755)
+	if err != nil {
+		return err
+	}
+	return results.Write(output, r)
+}
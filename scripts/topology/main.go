@@ -0,0 +1,44 @@
+package main
+
+import (
+	"distribuidos/tp1/pipeline"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	path := flag.String("pipeline", "", "pipeline file, the default one is used if empty")
+	format := flag.String("format", "mermaid", "either dot, mermaid, or markdown to wrap the mermaid diagram in a code block")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Draw the stages, queues and exchanges of the pipeline\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %v [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	spec, err := pipeline.Load(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load pipeline: %v\n", err)
+		os.Exit(1)
+	}
+	graph := spec.Graph()
+
+	switch *format {
+	case "dot":
+		err = graph.WriteDOT(os.Stdout)
+	case "mermaid":
+		err = graph.WriteMermaid(os.Stdout)
+	case "markdown":
+		fmt.Println("```mermaid")
+		err = graph.WriteMermaid(os.Stdout)
+		fmt.Println("```")
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write graph: %v\n", err)
+		os.Exit(1)
+	}
+}